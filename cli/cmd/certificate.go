@@ -16,6 +16,9 @@ func newCertificateCmd() *cobra.Command {
 	cmd.AddCommand(newCertificateRoot())
 	cmd.AddCommand(newCertificateIntermediate())
 	cmd.AddCommand(newCertificateChain())
+	cmd.AddCommand(newCertificateCSR())
+	cmd.AddCommand(newCertificateImport())
+	cmd.AddCommand(newCertificateRotate())
 
 	return cmd
 }
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+func newCertificateCSR() *cobra.Command {
+	var csrFilename string
+
+	cmd := &cobra.Command{
+		Use:   "csr <IP:PORT>",
+		Short: "returns a certificate signing request for the root key of the Marblerun coordinator",
+		Long: `returns a certificate signing request for the root key of the Marblerun coordinator.
+Sign the request with an external CA and import the resulting chain with [marblerun certificate import] before setting the manifest.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hostName := args[0]
+			return cliCertificateCSR(hostName, csrFilename, eraConfig, insecureEra)
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVarP(&csrFilename, "output", "o", "marblerunRootCA.csr", "File to save the certificate signing request to")
+
+	return cmd
+}
+
+// cliCertificateCSR gets a certificate signing request for the root key of the Marblerun coordinator and saves it to a file
+func cliCertificateCSR(host string, output string, configFilename string, insecure bool) error {
+	cert, err := verifyCoordinator(host, configFilename, insecure)
+	if err != nil {
+		return err
+	}
+
+	client, err := restClient(cert)
+	if err != nil {
+		return err
+	}
+
+	url := url.URL{Scheme: "https", Host: host, Path: "csr"}
+	resp, err := client.Get(url.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get certificate signing request: %d %s", resp.StatusCode, respBody)
+	}
+
+	var csrResp struct {
+		CSR string `json:"CSR"`
+	}
+	if err := json.Unmarshal(respBody, &csrResp); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(output, []byte(csrResp.CSR), 0644); err != nil {
+		return err
+	}
+	fmt.Println("Certificate signing request written to", output)
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+func newCertificateImport() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import <chain.pem> <IP:PORT>",
+		Short: "imports a root certificate for the Marblerun coordinator that was issued by an external CA",
+		Long: `imports a root certificate for the Marblerun coordinator that was issued by an external CA.
+The file must contain the PEM-encoded certificate for the coordinator's root key, followed by the certificates of the issuing CAs.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chainFile := args[0]
+			hostName := args[1]
+			return cliCertificateImport(chainFile, hostName, eraConfig, insecureEra)
+		},
+		SilenceUsage: true,
+	}
+
+	return cmd
+}
+
+// cliCertificateImport uploads a certificate chain for the root key of the Marblerun coordinator
+func cliCertificateImport(chainFile string, host string, configFilename string, insecure bool) error {
+	chain, err := ioutil.ReadFile(chainFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := verifyCoordinator(host, configFilename, insecure)
+	if err != nil {
+		return err
+	}
+
+	client, err := restClient(cert)
+	if err != nil {
+		return err
+	}
+
+	url := url.URL{Scheme: "https", Host: host, Path: "chain"}
+	resp, err := client.Post(url.String(), "application/x-pem-file", bytes.NewReader(chain))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unable to import certificate chain: %d %s", resp.StatusCode, respBody)
+	}
+	fmt.Println("Certificate chain successfully imported")
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+func newCertificateRotate() *cobra.Command {
+	var clientAdminCert string
+	var clientAdminKey string
+
+	cmd := &cobra.Command{
+		Use:   "rotate <IP:PORT>",
+		Short: "rotates the intermediate certificate of the Marblerun coordinator",
+		Long: `
+Rotates the intermediate certificate of the Marblerun coordinator.
+Certificates issued by the previous intermediate certificate stay trusted during the coordinator's overlap period, in which Marbles can renew their certificates.
+An admin certificate specified in the manifest is needed to authorize the rotation.
+`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hostName := args[0]
+			return cliCertificateRotate(hostName, clientAdminCert, clientAdminKey, eraConfig, insecureEra)
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVarP(&clientAdminCert, "cert", "c", "", "PEM encoded admin certificate file (required)")
+	cmd.MarkFlagRequired("cert")
+	cmd.Flags().StringVarP(&clientAdminKey, "key", "k", "", "PEM encoded admin key file (required)")
+	cmd.MarkFlagRequired("key")
+
+	return cmd
+}
+
+// cliCertificateRotate rotates the intermediate certificate of the Marblerun coordinator
+func cliCertificateRotate(host string, clCertFile string, clKeyFile string, configFilename string, insecure bool) error {
+	caCert, err := verifyCoordinator(host, configFilename, insecure)
+	if err != nil {
+		return err
+	}
+
+	client, err := adminClient(caCert, clCertFile, clKeyFile)
+	if err != nil {
+		return err
+	}
+
+	url := url.URL{Scheme: "https", Host: host, Path: "rotate"}
+	resp, err := client.Post(url.String(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		fmt.Println("Intermediate certificate successfully rotated")
+	case http.StatusUnauthorized:
+		return fmt.Errorf("unable to authorize user: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	default:
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unable to rotate intermediate certificate: %d %s", resp.StatusCode, respBody)
+	}
+
+	return nil
+}
@@ -11,7 +11,8 @@ func newManifestCmd() *cobra.Command {
 		Long: `
 Manages manifests for the Marblerun coordinator.
 Used to either set the manifest, update an already set manifest, 
-or return a signature of the currently set manifest to the user`,
+or return a signature of the currently set manifest to the user.
+Manifests can also be checked locally without a coordinator`,
 		Example: "manifest set manifest.json example.com:25555 [--era-config=config.json] [--insecure]",
 	}
 
@@ -20,6 +21,11 @@ or return a signature of the currently set manifest to the user`,
 	cmd.AddCommand(newManifestSet())
 	cmd.AddCommand(newManifestGet())
 	cmd.AddCommand(newManifestUpdate())
+	cmd.AddCommand(newManifestCheck())
+	cmd.AddCommand(newManifestSchema())
+	cmd.AddCommand(newManifestConvert())
+	cmd.AddCommand(newManifestSignature())
+	cmd.AddCommand(newManifestVerify())
 
 	return cmd
 }
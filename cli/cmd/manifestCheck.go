@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/edgelesssys/marblerun/coordinator/manifest"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func newManifestCheck() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check <manifest>",
+		Short: "Checks a manifest for errors without connecting to a coordinator",
+		Long: `Checks a manifest for errors without connecting to a coordinator.
+Validates the manifest in JSON or YAML format against its JSON schema and runs the consistency checks of the coordinator.
+All problems found are printed.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cliManifestCheck(args[0])
+		},
+		SilenceUsage: true,
+	}
+	return cmd
+}
+
+func newManifestSchema() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Prints the JSON schema of the manifest",
+		Long:  "Prints the JSON schema of the manifest, which can be used to validate manifests in editors and pipelines",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			schema, err := manifest.Schema()
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(schema))
+			return nil
+		},
+		SilenceUsage: true,
+	}
+	return cmd
+}
+
+// cliManifestCheck validates a manifest file locally
+func cliManifestCheck(manifestName string) error {
+	rawManifest, err := loadManifest(manifestName)
+	if err != nil {
+		return err
+	}
+	rawManifest, err = manifest.ToJSON(rawManifest)
+	if err != nil {
+		return err
+	}
+
+	problems := manifest.ValidateSchema(rawManifest)
+
+	// Run the consistency checks if the manifest can be decoded, ignoring unknown fields which the schema already reported
+	var mnf manifest.Manifest
+	if err := json.Unmarshal(rawManifest, &mnf); err == nil {
+		logConfig := zap.NewDevelopmentConfig()
+		logConfig.DisableStacktrace = true
+		zapLogger, err := logConfig.Build()
+		if err != nil {
+			return err
+		}
+		defer zapLogger.Sync()
+		problems = append(problems, mnf.CheckAll(context.Background(), zapLogger)...)
+	}
+
+	if len(problems) == 0 {
+		fmt.Printf("Manifest %s is valid.\n", manifestName)
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	return fmt.Errorf("manifest %s has %d problem(s)", manifestName, len(problems))
+}
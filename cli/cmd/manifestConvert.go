@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/edgelesssys/marblerun/coordinator/manifest"
+	"github.com/spf13/cobra"
+)
+
+func newManifestConvert() *cobra.Command {
+	var format string
+	var outputFilename string
+
+	cmd := &cobra.Command{
+		Use:   "convert <manifest>",
+		Short: "Converts a manifest between JSON and YAML format",
+		Long: `Converts a manifest between JSON and YAML format.
+By default, a JSON manifest is converted to YAML and a YAML manifest is converted to JSON.
+YAML manifests are converted to the canonical JSON form, which the coordinator uses to compute their signature.`,
+		Example: "manifest convert manifest.yaml -o manifest.json",
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cliManifestConvert(args[0], format, outputFilename)
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVarP(&format, "format", "f", "", "Output format, json or yaml")
+	cmd.Flags().StringVarP(&outputFilename, "output", "o", "", "File to write the converted manifest to, print to stdout if non specified")
+
+	return cmd
+}
+
+// cliManifestConvert converts a manifest file to JSON or YAML
+func cliManifestConvert(manifestName string, format string, outputFilename string) error {
+	rawManifest, err := ioutil.ReadFile(manifestName)
+	if err != nil {
+		return err
+	}
+
+	if format == "" {
+		format = manifest.FormatJSON
+		if manifest.DetectFormat(rawManifest) == manifest.FormatJSON {
+			format = manifest.FormatYAML
+		}
+	}
+
+	jsonManifest, err := manifest.ToJSON(rawManifest)
+	if err != nil {
+		return err
+	}
+
+	var converted []byte
+	switch format {
+	case manifest.FormatJSON:
+		converted = jsonManifest
+	case manifest.FormatYAML:
+		if converted, err = manifest.ToYAML(jsonManifest); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %s, expected %s or %s", format, manifest.FormatJSON, manifest.FormatYAML)
+	}
+
+	if outputFilename == "" {
+		fmt.Println(string(converted))
+		return nil
+	}
+	if err := ioutil.WriteFile(outputFilename, converted, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Manifest written to: %s.\n", outputFilename)
+	return nil
+}
@@ -15,8 +15,8 @@ func newManifestGet() *cobra.Command {
 
 	cmd := &cobra.Command{
 		Use:   "get <IP:PORT>",
-		Short: "Get the manifest signature from the Marblerun coordinator",
-		Long:  `Get the manifest signature from the Marblerun coordinator`,
+		Short: "Get the manifest signatures from the Marblerun coordinator",
+		Long:  `Get the manifest signature and the raw manifest signature from the Marblerun coordinator`,
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			hostName := args[0]
@@ -16,7 +16,7 @@ func newManifestSet() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "set <manifest.json> <IP:PORT>",
 		Short: "Sets the manifest for the Marblerun coordinator",
-		Long:  "Sets the manifest for the Marblerun coordinator",
+		Long:  "Sets the manifest for the Marblerun coordinator. The manifest can be in JSON or YAML format.",
 		Args:  cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			manifestFile := args[0]
@@ -40,7 +40,7 @@ func cliManifestSet(manifestName string, host string, configFilename string, ins
 	fmt.Println("Successfully verified coordinator, now uploading manifest")
 
 	// Load manifest
-	manifest, err := ioutil.ReadFile(manifestName)
+	manifest, err := loadManifest(manifestName)
 	if err != nil {
 		return err
 	}
@@ -51,7 +51,7 @@ func cliManifestSet(manifestName string, host string, configFilename string, ins
 	}
 
 	url := url.URL{Scheme: "https", Host: host, Path: "manifest"}
-	resp, err := client.Post(url.String(), "application/json", bytes.NewReader(manifest))
+	resp, err := client.Post(url.String(), manifestContentType(manifest), bytes.NewReader(manifest))
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/edgelesssys/marblerun/coordinator/manifest"
+	"github.com/spf13/cobra"
+)
+
+func newManifestSignature() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "signature <manifest>",
+		Short: "Prints the signatures of a local manifest",
+		Long: `Prints the signatures of a local manifest without connecting to a coordinator.
+The signature is the SHA256 hash of the manifest's canonical form. It does not depend on the format of the manifest.
+The raw signature is the SHA256 hash of the manifest file.
+Both can be compared with the ones returned by "manifest get".`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cliManifestSignature(args[0])
+		},
+		SilenceUsage: true,
+	}
+	return cmd
+}
+
+// cliManifestSignature prints the canonical and raw hash of a manifest file
+func cliManifestSignature(manifestName string) error {
+	rawManifest, err := loadManifest(manifestName)
+	if err != nil {
+		return err
+	}
+	hash, err := manifest.CanonicalHash(rawManifest)
+	if err != nil {
+		return err
+	}
+	rawHash := sha256.Sum256(rawManifest)
+	fmt.Printf("ManifestSignature: %s\n", hex.EncodeToString(hash))
+	fmt.Printf("RawManifestSignature: %s\n", hex.EncodeToString(rawHash[:]))
+	return nil
+}
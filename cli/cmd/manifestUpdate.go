@@ -2,11 +2,7 @@ package cmd
 
 import (
 	"bytes"
-	"crypto/tls"
-	"crypto/x509"
-	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 
@@ -23,6 +19,7 @@ func newManifestUpdate() *cobra.Command {
 		Long: `
 Updates the Marblerun coordinator with the specified manifest.
 An admin certificate specified in the original manifest is needed to verify the authenticity of the update manifest.
+The manifest can be in JSON or YAML format.
 `,
 		Args: cobra.ExactArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
@@ -49,41 +46,19 @@ func cliManifestUpdate(manifestName string, host string, clCertFile string, clKe
 	}
 	fmt.Println("Successfully verified coordinator, now uploading manifest")
 
-	// Set rootCA for connection to coordinator
-	certPool := x509.NewCertPool()
-	if ok := certPool.AppendCertsFromPEM([]byte(caCert[len(caCert)-1].Bytes)); !ok {
-		return errors.New("Failed to parse certificate")
-	}
-	// Add intermediate cert if applicable
-	if len(caCert) > 1 {
-		if ok := certPool.AppendCertsFromPEM([]byte(caCert[0].Bytes)); !ok {
-			return errors.New("Failed to parse certificate")
-		}
-	}
-
-	// Load client certificate and key
-	clCert, err := tls.LoadX509KeyPair(clCertFile, clKeyFile)
+	client, err := adminClient(caCert, clCertFile, clKeyFile)
 	if err != nil {
 		return err
 	}
 
-	client := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				RootCAs:      certPool,
-				Certificates: []tls.Certificate{clCert},
-			},
-		},
-	}
-
 	// Load manifest
-	manifest, err := ioutil.ReadFile(manifestName)
+	manifest, err := loadManifest(manifestName)
 	if err != nil {
 		return err
 	}
 
 	url := url.URL{Scheme: "https", Host: host, Path: "update"}
-	resp, err := client.Post(url.String(), "application/json", bytes.NewReader(manifest))
+	resp, err := client.Post(url.String(), manifestContentType(manifest), bytes.NewReader(manifest))
 	if err != nil {
 		return err
 	}
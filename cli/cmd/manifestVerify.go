@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/edgelesssys/marblerun/coordinator/manifest"
+	"github.com/spf13/cobra"
+)
+
+// diffSections lists the sections of the manifest in the order they are printed by verify
+var diffSections = []string{"Packages", "Marbles", "Secrets", "Admins"}
+
+func newManifestVerify() *cobra.Command {
+	var clientAdminCert string
+	var clientAdminKey string
+
+	cmd := &cobra.Command{
+		Use:   "verify <manifest> <IP:PORT>",
+		Short: "Verifies that the Marblerun coordinator uses the specified manifest",
+		Long: `Verifies that the Marblerun coordinator uses the specified manifest.
+The coordinator is attested and its manifest signature is compared with the one of the local manifest.
+Formatting differences are ignored.
+On a mismatch, the differences between the manifests are printed if an admin certificate is specified,
+as only admins can get the coordinator's manifest.`,
+		Example: "manifest verify manifest.json example.com:25555 [--cert=admin.crt --key=admin.key] [--era-config=config.json] [--insecure]",
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			manifestFile := args[0]
+			hostName := args[1]
+			return cliManifestVerify(manifestFile, hostName, clientAdminCert, clientAdminKey, eraConfig, insecureEra)
+		},
+		SilenceUsage: true,
+	}
+
+	cmd.Flags().StringVarP(&clientAdminCert, "cert", "c", "", "PEM encoded admin certificate file to print the differences on a mismatch")
+	cmd.Flags().StringVarP(&clientAdminKey, "key", "k", "", "PEM encoded admin key file to print the differences on a mismatch")
+
+	return cmd
+}
+
+// cliManifestVerify compares a local manifest with the coordinator's manifest
+func cliManifestVerify(manifestName string, host string, clCertFile string, clKeyFile string, configFilename string, insecure bool) error {
+	localManifest, err := loadManifest(manifestName)
+	if err != nil {
+		return err
+	}
+	localHash, err := manifest.CanonicalHash(localManifest)
+	if err != nil {
+		return err
+	}
+
+	cert, err := verifyCoordinator(host, configFilename, insecure)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Successfully verified coordinator, now requesting manifest signature")
+
+	client, err := restClient(cert)
+	if err != nil {
+		return err
+	}
+
+	respBody, err := getFromCoordinator(client, host, "manifest")
+	if err != nil {
+		return err
+	}
+	var signatures struct {
+		ManifestSignature    string
+		RawManifestSignature string
+	}
+	if err := json.Unmarshal(respBody, &signatures); err != nil {
+		return err
+	}
+	if signatures.ManifestSignature == "" {
+		return errors.New("the coordinator has no manifest set")
+	}
+
+	if signatures.ManifestSignature == hex.EncodeToString(localHash) {
+		localRawHash := sha256.Sum256(localManifest)
+		if signatures.RawManifestSignature == hex.EncodeToString(localRawHash[:]) {
+			fmt.Println("Manifest matches the coordinator's manifest.")
+		} else {
+			fmt.Println("Manifest matches the coordinator's manifest, but differs in formatting.")
+		}
+		return nil
+	}
+
+	if clCertFile == "" || clKeyFile == "" {
+		return errors.New("manifest does not match the coordinator's manifest. Specify an admin certificate to print the differences")
+	}
+
+	// Fetch the coordinator's manifest to show what differs
+	client, err = adminClient(cert, clCertFile, clKeyFile)
+	if err != nil {
+		return err
+	}
+	remoteManifest, err := getFromCoordinator(client, host, "manifest/raw")
+	if err != nil {
+		return err
+	}
+	differences, err := manifest.Diff(remoteManifest, localManifest)
+	if err != nil {
+		return err
+	}
+	printManifestDiff(differences)
+	return errors.New("manifest does not match the coordinator's manifest")
+}
+
+// getFromCoordinator sends a GET request to the coordinator's rest api and returns the response body
+func getFromCoordinator(client *http.Client, host string, path string) ([]byte, error) {
+	url := url.URL{Scheme: "https", Host: host, Path: path}
+	resp, err := client.Get(url.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error connecting to server: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// printManifestDiff prints the differences grouped by the section of the manifest
+func printManifestDiff(differences []manifest.Difference) {
+	fmt.Println("--- coordinator")
+	fmt.Println("+++ local")
+
+	bySection := make(map[string][]manifest.Difference)
+	var otherSections []string
+	for _, difference := range differences {
+		section := difference.Section()
+		if _, ok := bySection[section]; !ok && !isDiffSection(section) {
+			otherSections = append(otherSections, section)
+		}
+		bySection[section] = append(bySection[section], difference)
+	}
+
+	sections := append(append([]string{}, diffSections...), otherSections...)
+	for _, section := range sections {
+		if len(bySection[section]) == 0 {
+			continue
+		}
+		fmt.Printf("%s:\n", section)
+		for _, difference := range bySection[section] {
+			switch {
+			case difference.Old == nil:
+				fmt.Printf("  + %s: %s\n", difference.Path, difference.New)
+			case difference.New == nil:
+				fmt.Printf("  - %s: %s\n", difference.Path, difference.Old)
+			default:
+				fmt.Printf("  ~ %s: %s -> %s\n", difference.Path, difference.Old, difference.New)
+			}
+		}
+	}
+}
+
+func isDiffSection(section string) bool {
+	for _, s := range diffSections {
+		if s == section {
+			return true
+		}
+	}
+	return false
+}
@@ -2,9 +2,12 @@ package cmd
 
 import (
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -12,6 +15,7 @@ import (
 const statusDesc = `
 This command provides information about the currently running Marblerun coordinator.
 Information is obtained from the /status endpoint of the Coordinators REST API.
+With an admin certificate, the secrets, the intermediate CA rotation and the activations are obtained from the /status/details endpoint.
 
 The Coordinator will be in one of these 4 states:
   0 recovery mode: Found a sealed state of an old seal key. Waiting for user input on /recovery.
@@ -28,11 +32,48 @@ The Coordinator will be in one of these 4 states:
 `
 
 type statusResponse struct {
-	Code   int    `json:"Code"`
-	Status string `json:"Status"`
+	Code           int       `json:"Code"`
+	Status         string    `json:"Status"`
+	SimulationMode bool      `json:"SimulationMode"`
+	RootCA         caProfile `json:"RootCA"`
+	IntermediateCA caProfile `json:"IntermediateCA"`
+}
+
+type statusDetailsResponse struct {
+	IntermediateRotation *intermediateRotation      `json:"IntermediateRotation"`
+	Secrets              map[string]secretStatus    `json:"Secrets"`
+	PackageActivations   map[string]map[string]uint `json:"PackageActivations"`
+}
+
+type secretStatus struct {
+	NotAfter     time.Time `json:"NotAfter"`
+	RenewAt      time.Time `json:"RenewAt"`
+	LastRotation time.Time `json:"LastRotation"`
+}
+
+type intermediateRotation struct {
+	Time         time.Time `json:"Time"`
+	OverlapUntil time.Time `json:"OverlapUntil"`
+}
+
+type caProfile struct {
+	CommonName   string `json:"CommonName"`
+	KeyAlgorithm string `json:"KeyAlgorithm"`
+	ValidFor     uint   `json:"ValidFor"`
+}
+
+func (p caProfile) String() string {
+	validity := "does not expire"
+	if p.ValidFor != 0 {
+		validity = fmt.Sprintf("valid for %d days", p.ValidFor)
+	}
+	return fmt.Sprintf("%s (%s, %s)", p.CommonName, p.KeyAlgorithm, validity)
 }
 
 func newStatusCmd() *cobra.Command {
+	var clientAdminCert string
+	var clientAdminKey string
+
 	cmd := &cobra.Command{
 		Use:   "status <IP:PORT>",
 		Short: "Gives information about the status of the marblerun Coordinator",
@@ -40,19 +81,21 @@ func newStatusCmd() *cobra.Command {
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			hostname := args[0]
-			return cliStatus(hostname, eraConfig, insecureEra)
+			return cliStatus(hostname, clientAdminCert, clientAdminKey, eraConfig, insecureEra)
 		},
 		SilenceUsage: true,
 	}
 
 	cmd.Flags().StringVar(&eraConfig, "era-config", "", "Path to remote attestation config file in json format, if none provided the newest configuration will be loaded from github")
 	cmd.Flags().BoolVarP(&insecureEra, "insecure", "i", false, "Set to skip quote verification, needed when running in simulation mode")
+	cmd.Flags().StringVarP(&clientAdminCert, "cert", "c", "", "PEM encoded admin certificate file, shows the secrets, the intermediate CA rotation and the activations")
+	cmd.Flags().StringVarP(&clientAdminKey, "key", "k", "", "PEM encoded admin key file")
 
 	return cmd
 }
 
-// cliStatus requests the current status of the coordinator
-func cliStatus(host string, configFilename string, insecure bool) error {
+// cliStatus requests the current status of the coordinator. The details of the status are requested if an admin certificate is given.
+func cliStatus(host string, clCertFile string, clKeyFile string, configFilename string, insecure bool) error {
 	cert, err := verifyCoordinator(host, configFilename, insecure)
 	if err != nil {
 		return err
@@ -81,9 +124,82 @@ func cliStatus(host string, configFilename string, insecure bool) error {
 			return err
 		}
 		fmt.Printf("%d: %s\n", statusResp.Code, statusResp.Status)
+		if statusResp.SimulationMode {
+			fmt.Println("Warning: the Coordinator runs in simulation mode. The integrity of the mesh can not be guaranteed.")
+		}
+		fmt.Printf("Root CA: %s\n", statusResp.RootCA)
+		fmt.Printf("Intermediate CA: %s\n", statusResp.IntermediateCA)
+	default:
+		return fmt.Errorf("error connecting to server: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	if clCertFile == "" && clKeyFile == "" {
+		return nil
+	}
+	return cliStatusDetails(host, cert, clCertFile, clKeyFile)
+}
+
+// cliStatusDetails requests the details of the coordinator's status, which are only available to admins
+func cliStatusDetails(host string, cert []*pem.Block, clCertFile string, clKeyFile string) error {
+	client, err := adminClient(cert, clCertFile, clKeyFile)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Get("https://" + host + "/status/details")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		respBody, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		var details statusDetailsResponse
+		if err := json.Unmarshal(respBody, &details); err != nil {
+			return err
+		}
+		if rotation := details.IntermediateRotation; rotation != nil {
+			fmt.Printf("Intermediate CA rotated at %s, previous intermediate CA trusted until %s\n", rotation.Time.Format(time.RFC3339), rotation.OverlapUntil.Format(time.RFC3339))
+		}
+		for name, secret := range details.Secrets {
+			fmt.Printf("Secret %s: valid until %s", name, secret.NotAfter.Format(time.RFC3339))
+			if !secret.RenewAt.IsZero() {
+				fmt.Printf(", renewed at %s", secret.RenewAt.Format(time.RFC3339))
+			}
+			if !secret.LastRotation.IsZero() {
+				fmt.Printf(", last renewed at %s", secret.LastRotation.Format(time.RFC3339))
+			}
+			fmt.Println()
+		}
+		printPackageActivations(details.PackageActivations)
+	case http.StatusUnauthorized:
+		return fmt.Errorf("unable to authorize user: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
 	default:
 		return fmt.Errorf("error connecting to server: %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
 	}
 
 	return nil
 }
+
+// printPackageActivations prints the number of activations of each Marble type per matched package
+func printPackageActivations(packageActivations map[string]map[string]uint) {
+	marbleTypes := make([]string, 0, len(packageActivations))
+	for marbleType := range packageActivations {
+		marbleTypes = append(marbleTypes, marbleType)
+	}
+	sort.Strings(marbleTypes)
+	for _, marbleType := range marbleTypes {
+		pkgNames := make([]string, 0, len(packageActivations[marbleType]))
+		for pkgName := range packageActivations[marbleType] {
+			pkgNames = append(pkgNames, pkgName)
+		}
+		sort.Strings(pkgNames)
+		for _, pkgName := range pkgNames {
+			fmt.Printf("Marble %s: %d activations with package %s\n", marbleType, packageActivations[marbleType][pkgName], pkgName)
+		}
+	}
+}
@@ -1,20 +1,38 @@
 package cmd
 
 import (
+	"bytes"
+	"crypto/rand"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 
 	"github.com/edgelesssys/era/era"
+	"github.com/edgelesssys/ertgolib/ert"
+	"github.com/edgelesssys/ertgolib/erthost"
+	"github.com/edgelesssys/marblerun/coordinator/manifest"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
 )
 
 var eraConfig string
 var insecureEra bool
 
+type certQuoteResponse struct {
+	Cert           string `json:"Cert"`
+	Quote          []byte `json:"Quote"`
+	SimulationMode bool   `json:"SimulationMode"`
+}
+
 // verify the connection to the marblerun coordinator
 func verifyCoordinator(host string, configFilename string, insecure bool) ([]*pem.Block, error) {
 	// skip verification if specified
@@ -25,7 +43,7 @@ func verifyCoordinator(host string, configFilename string, insecure bool) ([]*pe
 
 	// get certificate using provided config
 	if configFilename != "" {
-		return era.GetCertificate(host, configFilename)
+		return getCertificateWithNonce(host, configFilename, erthost.VerifyRemoteReport)
 	}
 
 	// get latest config from github if none specified
@@ -46,7 +64,127 @@ func verifyCoordinator(host string, configFilename string, insecure bool) ([]*pe
 	}
 	fmt.Println("Got latest config")
 
-	return era.GetCertificate(host, "era-config.json")
+	return getCertificateWithNonce(host, "era-config.json", erthost.VerifyRemoteReport)
+}
+
+// getCertificateWithNonce gets the Coordinator's certificate chain and verifies it using a fresh quote.
+// The quote is bound to a random nonce, so a quote replayed by an old or cloned Coordinator instance is rejected.
+func getCertificateWithNonce(host string, configFilename string, verifyRemoteReport func([]byte) (ert.Report, error)) ([]*pem.Block, error) {
+	config, err := ioutil.ReadFile(configFilename)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	// The TLS connection is not trusted yet, the returned certificate is verified using the quote
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	quoteURL := url.URL{Scheme: "https", Host: host, Path: "quote", RawQuery: "nonce=" + hex.EncodeToString(nonce)}
+	resp, err := client.Get(quoteURL.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error connecting to server: %s %s", resp.Status, body)
+	}
+	var certQuote certQuoteResponse
+	if err := json.Unmarshal(body, &certQuote); err != nil {
+		return nil, err
+	}
+
+	certs, err := decodeCertChain([]byte(certQuote.Cert))
+	if err != nil {
+		return nil, err
+	}
+	if len(certQuote.Quote) == 0 {
+		return nil, errors.New("no quote received. If the Coordinator runs in simulation mode, use --insecure to skip quote verification")
+	}
+	report, err := verifyRemoteReport(certQuote.Quote)
+	if err != nil {
+		return nil, err
+	}
+
+	// The root certificate (last entry in certs) is used for attestation
+	if err := verifyCoordinatorReport(report, certs[len(certs)-1].Bytes, nonce, config); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// verifyCoordinatorReport checks that a report binds the certificate and nonce and matches the era config
+func verifyCoordinatorReport(report ert.Report, cert []byte, nonce []byte, config []byte) error {
+	if err := quote.CheckNonceReportData(report.Data, cert, nonce); err != nil {
+		return err
+	}
+
+	var cfg struct {
+		SecurityVersion uint
+		UniqueID        string
+		SignerID        string
+		ProductID       uint16
+	}
+	if err := json.Unmarshal(config, &cfg); err != nil {
+		return err
+	}
+	if cfg.SecurityVersion == 0 {
+		return errors.New("missing securityVersion in config")
+	}
+	if cfg.ProductID == 0 {
+		return errors.New("missing productID in config")
+	}
+	if cfg.UniqueID == "" && cfg.SignerID == "" {
+		fmt.Println("Warning: Configuration contains neither uniqueID nor signerID!")
+	}
+
+	if report.SecurityVersion < cfg.SecurityVersion {
+		return errors.New("invalid security version")
+	}
+	if len(report.ProductID) < 2 || binary.LittleEndian.Uint16(report.ProductID) != cfg.ProductID {
+		return errors.New("invalid product")
+	}
+	if err := verifyReportID(cfg.UniqueID, report.UniqueID, "uniqueID"); err != nil {
+		return err
+	}
+	return verifyReportID(cfg.SignerID, report.SignerID, "signerID")
+}
+
+func verifyReportID(expected string, actual []byte, name string) error {
+	if expected == "" {
+		return nil
+	}
+	expectedBytes, err := hex.DecodeString(expected)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(expectedBytes, actual) {
+		return errors.New("invalid " + name)
+	}
+	return nil
+}
+
+// decodeCertChain decodes a PEM-encoded certificate chain
+func decodeCertChain(chain []byte) ([]*pem.Block, error) {
+	var certs []*pem.Block
+	for len(bytes.TrimSpace(chain)) > 0 {
+		var block *pem.Block
+		block, chain = pem.Decode(chain)
+		if block == nil {
+			return nil, errors.New("could not parse certificate chain")
+		}
+		certs = append(certs, block)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("could not parse certificate")
+	}
+	return certs, nil
 }
 
 // restClient creates and returns a http client using a provided certificate to communicate with the Coordinator REST API
@@ -73,3 +211,40 @@ func restClient(cert []*pem.Block) (*http.Client, error) {
 
 	return client, nil
 }
+
+// adminClient creates and returns a http client that authenticates to the Coordinator REST API with an admin certificate
+func adminClient(cert []*pem.Block, clCertFile string, clKeyFile string) (*http.Client, error) {
+	client, err := restClient(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load client certificate and key
+	clCert, err := tls.LoadX509KeyPair(clCertFile, clKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	client.Transport.(*http.Transport).TLSClientConfig.Certificates = []tls.Certificate{clCert}
+
+	return client, nil
+}
+
+// loadManifest reads a manifest in JSON or YAML format and returns it unchanged, so that its raw signature matches the one of the file
+func loadManifest(filename string) ([]byte, error) {
+	rawManifest, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := manifest.ToJSON(rawManifest); err != nil {
+		return nil, err
+	}
+	return rawManifest, nil
+}
+
+// manifestContentType returns the HTTP content type of a manifest in JSON or YAML format
+func manifestContentType(rawManifest []byte) string {
+	if manifest.DetectFormat(rawManifest) == manifest.FormatYAML {
+		return "application/yaml"
+	}
+	return "application/json"
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/edgelesssys/ertgolib/ert"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testEraConfig = `{"SecurityVersion": 2, "UniqueID": "0102", "SignerID": "0304", "ProductID": 3}`
+
+// testReport returns a report that matches testEraConfig and binds the certificate and nonce
+func testReport(cert []byte, nonce []byte) ert.Report {
+	return ert.Report{
+		Data:            quote.NonceReportData(cert, nonce),
+		SecurityVersion: 2,
+		UniqueID:        []byte{1, 2},
+		SignerID:        []byte{3, 4},
+		ProductID:       []byte{3, 0},
+	}
+}
+
+func TestVerifyCoordinatorReport(t *testing.T) {
+	cert := []byte("cert")
+	nonce := []byte("nonce")
+
+	testCases := map[string]struct {
+		modify  func(*ert.Report)
+		config  string
+		wantErr bool
+	}{
+		"valid": {},
+		"newer security version": {
+			modify: func(r *ert.Report) { r.SecurityVersion = 3 },
+		},
+		"older security version": {
+			modify:  func(r *ert.Report) { r.SecurityVersion = 1 },
+			wantErr: true,
+		},
+		"other unique ID": {
+			modify:  func(r *ert.Report) { r.UniqueID = []byte{1, 3} },
+			wantErr: true,
+		},
+		"other signer ID": {
+			modify:  func(r *ert.Report) { r.SignerID = []byte{3, 5} },
+			wantErr: true,
+		},
+		"other product ID": {
+			modify:  func(r *ert.Report) { r.ProductID = []byte{4, 0} },
+			wantErr: true,
+		},
+		"truncated product ID": {
+			modify:  func(r *ert.Report) { r.ProductID = []byte{3} },
+			wantErr: true,
+		},
+		"other certificate": {
+			modify:  func(r *ert.Report) { r.Data = quote.NonceReportData([]byte("other cert"), nonce) },
+			wantErr: true,
+		},
+		"other nonce": {
+			modify:  func(r *ert.Report) { r.Data = quote.NonceReportData(cert, []byte("other nonce")) },
+			wantErr: true,
+		},
+		"no nonce": {
+			modify:  func(r *ert.Report) { r.Data = r.Data[:32] },
+			wantErr: true,
+		},
+		"IDs not configured": {
+			modify: func(r *ert.Report) { r.UniqueID, r.SignerID = nil, nil },
+			config: `{"SecurityVersion": 2, "ProductID": 3}`,
+		},
+		"security version not configured": {
+			config:  `{"UniqueID": "0102", "ProductID": 3}`,
+			wantErr: true,
+		},
+		"product ID not configured": {
+			config:  `{"SecurityVersion": 2, "UniqueID": "0102"}`,
+			wantErr: true,
+		},
+		"invalid unique ID": {
+			config:  `{"SecurityVersion": 2, "UniqueID": "xyz", "ProductID": 3}`,
+			wantErr: true,
+		},
+		"invalid config": {
+			config:  `{"SecurityVersion": "2"}`,
+			wantErr: true,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			report := testReport(cert, nonce)
+			if tc.modify != nil {
+				tc.modify(&report)
+			}
+			config := tc.config
+			if config == "" {
+				config = testEraConfig
+			}
+
+			err := verifyCoordinatorReport(report, cert, nonce, []byte(config))
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestGetCertificateWithNonce(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	intermediate, _, err := util.GenerateCert([]string{"localhost"}, nil, true)
+	require.NoError(err)
+	root, _, err := util.GenerateCert([]string{"localhost"}, nil, true)
+	require.NoError(err)
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: intermediate.Raw}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})...)
+
+	// Mocks the Coordinator's client API. The quote is the nonce it was requested with, unless it is replayed.
+	var replayedNonce []byte
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("/quote", r.URL.Path)
+		nonce, err := hex.DecodeString(r.URL.Query().Get("nonce"))
+		assert.NoError(err)
+		assert.Len(nonce, 32)
+		if replayedNonce != nil {
+			nonce = replayedNonce
+		}
+		assert.NoError(json.NewEncoder(w).Encode(certQuoteResponse{Cert: string(chain), Quote: nonce}))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(err)
+
+	configFile, err := ioutil.TempFile("", "")
+	require.NoError(err)
+	defer os.Remove(configFile.Name())
+	_, err = configFile.WriteString(testEraConfig)
+	require.NoError(err)
+	require.NoError(configFile.Close())
+
+	// the report binds the root certificate and the nonce
+	var reportCert []byte
+	verifyRemoteReport := func(reportBytes []byte) (ert.Report, error) {
+		return testReport(reportCert, reportBytes), nil
+	}
+
+	reportCert = root.Raw
+	certs, err := getCertificateWithNonce(serverURL.Host, configFile.Name(), verifyRemoteReport)
+	require.NoError(err)
+	require.Len(certs, 2)
+	assert.Equal(intermediate.Raw, certs[0].Bytes)
+	assert.Equal(root.Raw, certs[1].Bytes)
+
+	// the report must bind the root certificate
+	reportCert = intermediate.Raw
+	_, err = getCertificateWithNonce(serverURL.Host, configFile.Name(), verifyRemoteReport)
+	assert.Error(err)
+	reportCert = root.Raw
+
+	// a replayed quote is rejected
+	replayedNonce = make([]byte, 32)
+	_, err = getCertificateWithNonce(serverURL.Host, configFile.Name(), verifyRemoteReport)
+	assert.Error(err)
+	replayedNonce = nil
+
+	// the config must exist
+	_, err = getCertificateWithNonce(serverURL.Host, "not-existing.json", verifyRemoteReport)
+	assert.Error(err)
+}
@@ -9,6 +9,7 @@
 package main
 
 import (
+	"os"
 	"path/filepath"
 
 	"github.com/edgelesssys/marblerun/coordinator/config"
@@ -26,5 +27,6 @@ func main() {
 	sealDir = filepath.Join(sealDirPrefix, sealDir)
 	sealer := core.NewAESGCMSealer(sealDir)
 	recovery := recovery.NewSinglePartyRecovery()
-	run(validator, issuer, sealDir, sealer, recovery)
+	simulationMode := os.Getenv(config.SimulationMode) == "1"
+	run(validator, issuer, sealDir, sealer, recovery, simulationMode)
 }
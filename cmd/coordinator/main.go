@@ -22,5 +22,6 @@ func main() {
 	sealDir := util.MustGetenv(config.SealDir)
 	sealer := core.NewNoEnclaveSealer(sealDir)
 	recovery := recovery.NewSinglePartyRecovery()
-	run(validator, issuer, sealDir, sealer, recovery)
+	// without an enclave, the Coordinator cannot issue quotes
+	run(validator, issuer, sealDir, sealer, recovery, true)
 }
@@ -7,9 +7,16 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/edgelesssys/marblerun/coordinator/config"
 	"github.com/edgelesssys/marblerun/coordinator/core"
@@ -20,7 +27,7 @@ import (
 	"go.uber.org/zap"
 )
 
-func run(validator quote.Validator, issuer quote.Issuer, sealDir string, sealer core.Sealer, recovery recovery.Recovery) {
+func run(validator quote.Validator, issuer quote.Issuer, sealDir string, sealer core.Sealer, recovery recovery.Recovery, simulationMode bool) {
 	// Setup logging with Zap Logger
 	var zapLogger *zap.Logger
 	var err error
@@ -46,16 +53,69 @@ func run(validator quote.Validator, issuer quote.Issuer, sealDir string, sealer
 	clientServerAddr := util.MustGetenv(config.ClientAddr)
 	meshServerAddr := util.MustGetenv(config.MeshAddr)
 	promServerAddr := os.Getenv(config.PromAddr)
+	quoteCacheTTL := config.DefaultQuoteCacheTTL
+	if quoteCacheTTLString := os.Getenv(config.QuoteCacheTTL); quoteCacheTTLString != "" {
+		quoteCacheTTL, err = time.ParseDuration(quoteCacheTTLString)
+		if err != nil {
+			zapLogger.Fatal("Cannot parse the quote cache TTL.", zap.Error(err))
+		}
+	}
+
+	var intermediateRotationInterval time.Duration
+	if intervalString := os.Getenv(config.IntermediateRotationInterval); intervalString != "" {
+		intermediateRotationInterval, err = time.ParseDuration(intervalString)
+		if err != nil {
+			zapLogger.Fatal("Cannot parse the intermediate CA rotation interval.", zap.Error(err))
+		}
+	}
+	intermediateOverlap := config.DefaultIntermediateOverlap
+	if overlapString := os.Getenv(config.IntermediateOverlap); overlapString != "" {
+		intermediateOverlap, err = time.ParseDuration(overlapString)
+		if err != nil {
+			zapLogger.Fatal("Cannot parse the intermediate CA overlap period.", zap.Error(err))
+		}
+	}
+	secretRotationCheckInterval := config.DefaultSecretRotationCheckInterval
+	if intervalString := os.Getenv(config.SecretRotationCheckInterval); intervalString != "" {
+		secretRotationCheckInterval, err = time.ParseDuration(intervalString)
+		if err != nil {
+			zapLogger.Fatal("Cannot parse the secret rotation check interval.", zap.Error(err))
+		}
+	}
+	rootCAProfile, err := parseCertificateProfile(config.RootCAProfile)
+	if err != nil {
+		zapLogger.Fatal("Cannot parse the root CA profile.", zap.Error(err))
+	}
+	intermediateCAProfile, err := parseCertificateProfile(config.IntermediateCAProfile)
+	if err != nil {
+		zapLogger.Fatal("Cannot parse the intermediate CA profile.", zap.Error(err))
+	}
+
+	// registering additional attestation formats
+	validator, err = newValidatorRegistry(validator, zapLogger)
+	if err != nil {
+		zapLogger.Fatal("Cannot set up the attestation formats.", zap.Error(err))
+	}
 
 	// creating core
 	zapLogger.Info("creating the Core object")
 	if err := os.MkdirAll(sealDir, 0700); err != nil {
 		zapLogger.Fatal("Cannot create or access sealdir. Please check the permissions for the specified path.", zap.Error(err))
 	}
-	core, err := core.NewCore(dnsNames, validator, issuer, sealer, recovery, zapLogger)
+	opts := core.Options{
+		QuoteCacheTTL:                quoteCacheTTL,
+		SimulationMode:               simulationMode,
+		RootCA:                       rootCAProfile,
+		IntermediateCA:               intermediateCAProfile,
+		IntermediateRotationInterval: intermediateRotationInterval,
+		IntermediateOverlap:          intermediateOverlap,
+		SecretRotationCheckInterval:  secretRotationCheckInterval,
+	}
+	core, err := core.NewCore(dnsNames, validator, issuer, sealer, recovery, opts, zapLogger)
 	if err != nil {
-		panic(err)
+		zapLogger.Fatal("Cannot create the Core object.", zap.Error(err))
 	}
+	defer core.Stop()
 
 	// start the prometheus server
 	if promServerAddr != "" {
@@ -88,3 +148,68 @@ func run(validator quote.Validator, issuer quote.Issuer, sealDir string, sealer
 		}
 	}
 }
+
+// newValidatorRegistry wraps the platform's validator in a registry and registers the attestation formats configured by the environment
+func newValidatorRegistry(validator quote.Validator, zapLogger *zap.Logger) (quote.Validator, error) {
+	registry := quote.NewRegistry(validator)
+	registry.Register(quote.FormatERT, validator)
+
+	if rootsPEM := os.Getenv(config.SignedReportRoots); rootsPEM != "" {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(rootsPEM)) {
+			return nil, fmt.Errorf("%v does not contain a valid certificate", config.SignedReportRoots)
+		}
+		registry.Register(quote.FormatSignedReport, quote.NewSignedReportValidator(roots))
+		zapLogger.Info("accepting signed-report quotes")
+	}
+
+	if keysPEM := os.Getenv(config.AttestationKeys); keysPEM != "" {
+		keys, err := parseAttestationKeys([]byte(keysPEM))
+		if err != nil {
+			return nil, err
+		}
+		registry.Register(quote.FormatAttestationKey, quote.NewAttestationKeyValidator(keys...))
+		zapLogger.Warn("accepting attestation-key quotes, which are not bound to any hardware. Use this for testing only!")
+	}
+
+	return registry, nil
+}
+
+func parseAttestationKeys(keysPEM []byte) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for {
+		var block *pem.Block
+		block, keysPEM = pem.Decode(keysPEM)
+		if block == nil {
+			break
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return nil, errors.New("attestation keys must be Ed25519 public keys")
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%v does not contain a valid public key", config.AttestationKeys)
+	}
+	return keys, nil
+}
+
+// parseCertificateProfile parses the JSON-encoded certificate profile in the given environment variable. Unknown fields are rejected.
+func parseCertificateProfile(name string) (core.CertificateProfile, error) {
+	var profile core.CertificateProfile
+	rawProfile := os.Getenv(name)
+	if rawProfile == "" {
+		return profile, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(rawProfile))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&profile); err != nil {
+		return profile, fmt.Errorf("%v: %v", name, err)
+	}
+	return profile, nil
+}
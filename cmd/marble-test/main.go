@@ -13,7 +13,7 @@ import (
 	"net/url"
 	"os"
 
-	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/marble/tlsconfig"
 	"github.com/edgelesssys/marblerun/util"
 )
 
@@ -29,8 +29,8 @@ func main() {
 }
 
 func runServer(addr string) {
-	// Retrieve server TLS config from ertgolib
-	tlsConfig, err := marble.GetTLSConfig(true)
+	// Retrieve server TLS config accepting any Marble of the mesh
+	tlsConfig, err := tlsconfig.GetServerConfig()
 	if err != nil {
 		panic(err)
 	}
@@ -52,8 +52,8 @@ func runServer(addr string) {
 }
 
 func runClient(addr string) error {
-	// Retrieve client TLS config from ertgolib
-	tlsConfig, err := marble.GetTLSConfig(false)
+	// Retrieve client TLS config accepting any Marble of the mesh
+	tlsConfig, err := tlsconfig.GetClientConfig()
 	if err != nil {
 		panic(err)
 	}
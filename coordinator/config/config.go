@@ -7,6 +7,8 @@
 // Package config defines the environment variables expected by the Coordinator for configuration settings.
 package config
 
+import "time"
+
 // MeshAddr is the coordinator's address for the gRPC server to listen on
 const MeshAddr = "EDG_COORDINATOR_MESH_ADDR"
 
@@ -24,3 +26,42 @@ const SealDir = "EDG_COORDINATOR_SEAL_DIR"
 
 // DevMode enables more verbose logging
 const DevMode = "EDG_COORDINATOR_DEV_MODE"
+
+// SignedReportRoots are the PEM-encoded root certificates trusted to certify signers of signed-report quotes (optional)
+const SignedReportRoots = "EDG_COORDINATOR_SIGNED_REPORT_ROOTS"
+
+// AttestationKeys are the PEM-encoded Ed25519 public keys trusted to sign attestation-key quotes (optional, for testing only)
+const AttestationKeys = "EDG_COORDINATOR_ATTESTATION_KEYS"
+
+// QuoteCacheTTL is the duration for which the infrastructure a platform's quotes matched is cached, e.g., "10m" (optional, "0" disables the cache).
+// Quotes are still validated, but only against the cached infrastructure if the validator cannot report the infrastructure properties.
+const QuoteCacheTTL = "EDG_COORDINATOR_QUOTE_CACHE_TTL"
+
+// DefaultQuoteCacheTTL is the default duration for which the infrastructure a platform's quotes matched is cached
+const DefaultQuoteCacheTTL = 10 * time.Minute
+
+// SimulationMode enables simulation mode if set to "1". The Coordinator then runs without a quote and accepts Marbles without verifying their quotes.
+// EdgelessRT's erthost uses the same variable to enable SGX simulation.
+const SimulationMode = "OE_SIMULATION"
+
+// IntermediateRotationInterval is the maximum age of the Coordinator's intermediate CA before it is rotated automatically, e.g., "720h" (optional)
+// If it is set, Marble certificates expire after the overlap period of the next rotation, so Marbles must renew them, e.g., with tlsconfig.RenewPeriodically.
+const IntermediateRotationInterval = "EDG_COORDINATOR_INTERMEDIATE_ROTATION_INTERVAL"
+
+// IntermediateOverlap is the duration for which certificates issued by the previous intermediate CA are trusted after a rotation, e.g., "24h" (optional)
+const IntermediateOverlap = "EDG_COORDINATOR_INTERMEDIATE_OVERLAP"
+
+// DefaultIntermediateOverlap is the default duration for which certificates issued by the previous intermediate CA are trusted after a rotation
+const DefaultIntermediateOverlap = 24 * time.Hour
+
+// SecretRotationCheckInterval is the interval in which the Coordinator checks shared certificate secrets for expiry, e.g., "1h" (optional)
+const SecretRotationCheckInterval = "EDG_COORDINATOR_SECRET_ROTATION_CHECK_INTERVAL"
+
+// DefaultSecretRotationCheckInterval is the default interval in which the Coordinator checks shared certificate secrets for expiry
+const DefaultSecretRotationCheckInterval = time.Hour
+
+// RootCAProfile is the JSON-encoded certificate profile of the Coordinator's root CA, e.g., {"KeyAlgorithm": "ecdsa-p384", "ValidFor": 3650} (optional)
+const RootCAProfile = "EDG_COORDINATOR_ROOT_CA_PROFILE"
+
+// IntermediateCAProfile is the JSON-encoded certificate profile of the Coordinator's intermediate CA (optional)
+const IntermediateCAProfile = "EDG_COORDINATOR_INTERMEDIATE_CA_PROFILE"
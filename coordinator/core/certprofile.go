@@ -0,0 +1,303 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"time"
+)
+
+// Key algorithms supported for the Coordinator's CAs
+const (
+	KeyAlgorithmECDSAP256 = "ecdsa-p256"
+	KeyAlgorithmECDSAP384 = "ecdsa-p384"
+	KeyAlgorithmRSA2048   = "rsa-2048"
+	KeyAlgorithmRSA3072   = "rsa-3072"
+	KeyAlgorithmRSA4096   = "rsa-4096"
+	KeyAlgorithmEd25519   = "ed25519"
+)
+
+// CertificateProfile defines how the Coordinator creates the certificate of its root or intermediate CA.
+// The profile only applies to newly generated certificates; certificates loaded from a sealed state are kept.
+type CertificateProfile struct {
+	// KeyAlgorithm is the algorithm of the CA's private key. Defaults to ecdsa-p256.
+	KeyAlgorithm string `json:",omitempty"`
+	// ValidFor is the lifetime of the certificate in days. Zero means the certificate does not expire.
+	ValidFor uint `json:",omitempty"`
+	// Subject fields of the certificate. CommonName defaults to the Coordinator's name.
+	CommonName         string   `json:",omitempty"`
+	Organization       []string `json:",omitempty"`
+	OrganizationalUnit []string `json:",omitempty"`
+	Country            []string `json:",omitempty"`
+	Province           []string `json:",omitempty"`
+	Locality           []string `json:",omitempty"`
+	// Name constraints restrict the names the CA may certify. IP ranges are given in CIDR notation.
+	PermittedDNSDomains []string `json:",omitempty"`
+	ExcludedDNSDomains  []string `json:",omitempty"`
+	PermittedIPRanges   []string `json:",omitempty"`
+	ExcludedIPRanges    []string `json:",omitempty"`
+	// MaxPathLen limits the number of intermediate CAs below this CA. Nil means no limit.
+	MaxPathLen *int `json:",omitempty"`
+}
+
+// Check checks if the profile is valid.
+func (p CertificateProfile) Check() error {
+	switch p.KeyAlgorithm {
+	case "", KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384, KeyAlgorithmRSA2048, KeyAlgorithmRSA3072, KeyAlgorithmRSA4096, KeyAlgorithmEd25519:
+	default:
+		return fmt.Errorf("unsupported key algorithm: %v", p.KeyAlgorithm)
+	}
+	for _, ipRange := range append(append([]string{}, p.PermittedIPRanges...), p.ExcludedIPRanges...) {
+		if _, _, err := net.ParseCIDR(ipRange); err != nil {
+			return fmt.Errorf("invalid IP range: %v", ipRange)
+		}
+	}
+	if p.MaxPathLen != nil && *p.MaxPathLen < 0 {
+		return errors.New("MaxPathLen must not be negative")
+	}
+	return nil
+}
+
+// checkCAProfiles checks if the profiles are valid and if the root profile allows the intermediate CA.
+func checkCAProfiles(root CertificateProfile, intermediate CertificateProfile) error {
+	if err := root.Check(); err != nil {
+		return fmt.Errorf("invalid root CA profile: %v", err)
+	}
+	if err := intermediate.Check(); err != nil {
+		return fmt.Errorf("invalid intermediate CA profile: %v", err)
+	}
+	if root.MaxPathLen != nil && *root.MaxPathLen == 0 {
+		return errors.New("invalid root CA profile: MaxPathLen must allow the intermediate CA")
+	}
+	return nil
+}
+
+// withDefaults returns a copy of the profile with unset fields set to their defaults.
+func (p CertificateProfile) withDefaults(commonName string) CertificateProfile {
+	if p.KeyAlgorithm == "" {
+		p.KeyAlgorithm = KeyAlgorithmECDSAP256
+	}
+	if p.CommonName == "" {
+		p.CommonName = commonName
+	}
+	return p
+}
+
+func (p CertificateProfile) generateKey() (crypto.Signer, error) {
+	switch p.KeyAlgorithm {
+	case KeyAlgorithmECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyAlgorithmRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyAlgorithmRSA3072:
+		return rsa.GenerateKey(rand.Reader, 3072)
+	case KeyAlgorithmRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	case KeyAlgorithmEd25519:
+		_, privk, err := ed25519.GenerateKey(rand.Reader)
+		return privk, err
+	}
+	return nil, fmt.Errorf("unsupported key algorithm: %v", p.KeyAlgorithm)
+}
+
+// apply sets the profile's subject, validity and constraints on the certificate template.
+func (p CertificateProfile) apply(template *x509.Certificate) error {
+	template.Subject = pkix.Name{
+		CommonName:         p.CommonName,
+		Organization:       p.Organization,
+		OrganizationalUnit: p.OrganizationalUnit,
+		Country:            p.Country,
+		Province:           p.Province,
+		Locality:           p.Locality,
+	}
+
+	if p.ValidFor == 0 {
+		template.NotAfter = template.NotBefore.Add(math.MaxInt64)
+	} else {
+		template.NotAfter = template.NotBefore.AddDate(0, 0, int(p.ValidFor))
+	}
+
+	template.PermittedDNSDomains = p.PermittedDNSDomains
+	template.ExcludedDNSDomains = p.ExcludedDNSDomains
+	var err error
+	if template.PermittedIPRanges, err = parseIPRanges(p.PermittedIPRanges); err != nil {
+		return err
+	}
+	if template.ExcludedIPRanges, err = parseIPRanges(p.ExcludedIPRanges); err != nil {
+		return err
+	}
+	// RFC 5280 requires the name constraints extension to be critical
+	template.PermittedDNSDomainsCritical = len(p.PermittedDNSDomains) > 0 || len(p.ExcludedDNSDomains) > 0 || len(p.PermittedIPRanges) > 0 || len(p.ExcludedIPRanges) > 0
+
+	template.MaxPathLen = -1
+	if p.MaxPathLen != nil {
+		template.MaxPathLen = *p.MaxPathLen
+		template.MaxPathLenZero = *p.MaxPathLen == 0
+	}
+	return nil
+}
+
+// profileFromCertificate returns the effective profile of a CA certificate.
+func profileFromCertificate(cert *x509.Certificate) CertificateProfile {
+	profile := CertificateProfile{
+		KeyAlgorithm:        keyAlgorithm(cert.PublicKey),
+		CommonName:          cert.Subject.CommonName,
+		Organization:        cert.Subject.Organization,
+		OrganizationalUnit:  cert.Subject.OrganizationalUnit,
+		Country:             cert.Subject.Country,
+		Province:            cert.Subject.Province,
+		Locality:            cert.Subject.Locality,
+		PermittedDNSDomains: cert.PermittedDNSDomains,
+		ExcludedDNSDomains:  cert.ExcludedDNSDomains,
+		PermittedIPRanges:   formatIPRanges(cert.PermittedIPRanges),
+		ExcludedIPRanges:    formatIPRanges(cert.ExcludedIPRanges),
+	}
+
+	// Certificates without expiry are valid for math.MaxInt64 nanoseconds, minus the precision lost in encoding
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity < math.MaxInt64-time.Hour {
+		profile.ValidFor = uint(math.Round(validity.Hours() / 24))
+	}
+
+	if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+		maxPathLen := cert.MaxPathLen
+		profile.MaxPathLen = &maxPathLen
+	}
+	return profile
+}
+
+func keyAlgorithm(pubk crypto.PublicKey) string {
+	switch key := pubk.(type) {
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return KeyAlgorithmECDSAP256
+		case elliptic.P384():
+			return KeyAlgorithmECDSAP384
+		}
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa-%d", key.N.BitLen())
+	case ed25519.PublicKey:
+		return KeyAlgorithmEd25519
+	}
+	return "unknown"
+}
+
+// privateKeySeed returns the secret value of a CA's private key, which is used to derive unique Marble secrets.
+func privateKeySeed(privk crypto.Signer) ([]byte, error) {
+	switch key := privk.(type) {
+	case *ecdsa.PrivateKey:
+		return key.D.Bytes(), nil
+	case *rsa.PrivateKey:
+		return key.D.Bytes(), nil
+	case ed25519.PrivateKey:
+		return key.Seed(), nil
+	}
+	return nil, errors.New("unsupported private key type")
+}
+
+// parsePrivateKey parses a sealed CA private key. Older states store the key as SEC 1 EC key, newer ones as PKCS #8.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return x509.ParseECPrivateKey(der)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+func parseIPRanges(ipRanges []string) ([]*net.IPNet, error) {
+	var ipNets []*net.IPNet
+	for _, ipRange := range ipRanges {
+		_, ipNet, err := net.ParseCIDR(ipRange)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP range: %v", ipRange)
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+func formatIPRanges(ipNets []*net.IPNet) []string {
+	var ipRanges []string
+	for _, ipNet := range ipNets {
+		ipRanges = append(ipRanges, ipNet.String())
+	}
+	return ipRanges
+}
+
+// parseCertificateChain parses a chain of PEM-encoded certificates.
+func parseCertificateChain(rawChain []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, rawChain = pem.Decode(rawChain)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block of type %v", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no PEM-encoded certificate found")
+	}
+	return chain, nil
+}
+
+// checkRootCertificateChain checks that the first certificate of the chain is a CA certificate for the given key
+// and that each certificate is signed by the next one.
+func checkRootCertificateChain(chain []*x509.Certificate, rootPrivK crypto.Signer) error {
+	rootPubK, err := x509.MarshalPKIXPublicKey(rootPrivK.Public())
+	if err != nil {
+		return err
+	}
+	certPubK, err := x509.MarshalPKIXPublicKey(chain[0].PublicKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(rootPubK, certPubK) {
+		return errors.New("the certificate does not match the Coordinator's root key")
+	}
+	if !chain[0].BasicConstraintsValid || !chain[0].IsCA || chain[0].KeyUsage&x509.KeyUsageCertSign == 0 {
+		return errors.New("the certificate does not allow the Coordinator to act as CA")
+	}
+	if chain[0].MaxPathLenZero {
+		return errors.New("the certificate does not allow the Coordinator's intermediate CA")
+	}
+	if len(chain) < 2 {
+		return errors.New("the chain must contain the certificate of the issuing CA")
+	}
+	for i := 0; i < len(chain)-1; i++ {
+		if err := chain[i].CheckSignatureFrom(chain[i+1]); err != nil {
+			return fmt.Errorf("certificate %d is not signed by certificate %d: %v", i, i+1, err)
+		}
+	}
+	return nil
+}
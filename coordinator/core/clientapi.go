@@ -8,13 +8,15 @@ package core
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"crypto/x509"
-	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 
 	"github.com/edgelesssys/marblerun/coordinator/manifest"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
@@ -22,45 +24,61 @@ import (
 // ClientCore provides the core functionality for the client. It can be used by e.g. a http server
 type ClientCore interface {
 	SetManifest(ctx context.Context, rawManifest []byte) (recoverySecretMap map[string][]byte, err error)
-	GetCertQuote(ctx context.Context) (cert string, certQuote []byte, err error)
+	GetCertQuote(ctx context.Context, nonce []byte) (cert string, certQuote []byte, err error)
 	GetManifestSignature(ctx context.Context) (manifestSignature []byte)
+	GetRawManifestSignature(ctx context.Context) (rawSignature []byte)
+	GetManifest(ctx context.Context) (rawManifest []byte, err error)
 	GetStatus(ctx context.Context) (statusCode int, status string, err error)
+	GetCAProfiles(ctx context.Context) (root CertificateProfile, intermediate CertificateProfile)
+	GetRootCSR(ctx context.Context) (csr []byte, err error)
+	SetRootCertificateChain(ctx context.Context, rawChain []byte) error
+	GetRootChain(ctx context.Context) (rootChain string)
+	RotateIntermediate(ctx context.Context) error
+	GetIntermediateRotation(ctx context.Context) *IntermediateRotation
+	GetSecretStatus(ctx context.Context) map[string]SecretStatus
+	GetPackageActivations(ctx context.Context) map[string]map[string]uint
 	Recover(ctx context.Context, encryptionKey []byte) (int, error)
 	VerifyAdmin(ctx context.Context, clientCerts []*x509.Certificate) bool
 	UpdateManifest(ctx context.Context, rawUpdateManifest []byte) error
+	InSimulationMode(ctx context.Context) bool
 }
 
 // SetManifest sets the manifest, once and for all
 //
-// rawManifest is the manifest of type Manifest in JSON format.
+// rawManifest is the manifest of type Manifest in JSON or YAML format. It is stored as submitted.
+// The manifest signature is the hash of its canonical form, so it does not depend on the format.
 func (c *Core) SetManifest(ctx context.Context, rawManifest []byte) (map[string][]byte, error) {
 	defer c.mux.Unlock()
 	if err := c.requireState(stateAcceptingManifest, stateRecovery); err != nil {
 		return nil, err
 	}
 
-	var manifest manifest.Manifest
-	if err := json.Unmarshal(rawManifest, &manifest); err != nil {
+	jsonManifest, err := manifest.ToJSON(rawManifest)
+	if err != nil {
+		return nil, err
+	}
+	var newManifest manifest.Manifest
+	if err := manifest.Unmarshal(jsonManifest, &newManifest); err != nil {
 		return nil, err
 	}
-	if err := manifest.Check(ctx, c.zaplogger); err != nil {
+	if err := newManifest.Check(ctx, c.zaplogger); err != nil {
 		return nil, err
 	}
 
 	// Generate shared secrets specified in manifest
-	secrets, err := c.generateSecrets(ctx, manifest.Secrets, uuid.Nil, c.intermediateCert, c.intermediatePrivK)
+	secrets, err := c.generateSecrets(ctx, newManifest.Secrets, uuid.Nil, c.intermediateCert, c.intermediatePrivK)
 	if err != nil {
 		c.zaplogger.Error("Could not generate specified secrets for the given manifest.", zap.Error(err))
 		return nil, err
 	}
 
 	// Set encryption key & generate recovery data
-	encryptionKey, err := c.recovery.GenerateEncryptionKey(manifest.RecoveryKeys)
+	encryptionKey, err := c.recovery.GenerateEncryptionKey(newManifest.RecoveryKeys)
 	if err != nil {
 		c.zaplogger.Error("could not set up encryption key for sealing the state", zap.Error(err))
 		return nil, err
 	}
-	recoverySecretMap, recoveryData, err := c.recovery.GenerateRecoveryData(manifest.RecoveryKeys)
+	recoverySecretMap, recoveryData, err := c.recovery.GenerateRecoveryData(newManifest.RecoveryKeys)
 	if err != nil {
 		c.zaplogger.Error("could not generate recovery data", zap.Error(err))
 		return nil, err
@@ -68,14 +86,14 @@ func (c *Core) SetManifest(ctx context.Context, rawManifest []byte) (map[string]
 	c.sealer.SetEncryptionKey(encryptionKey)
 
 	// Parse X.509 admin certificates from manifest
-	adminCerts, err := generateAdminCertsFromManifest(manifest.Admins)
+	adminCerts, err := generateAdminCertsFromManifest(newManifest.Admins)
 	if err != nil {
 		c.zaplogger.Error("Could not parse specified admin client certificate from supplied manifest", zap.Error(err))
 		return nil, err
 	}
 
-	c.manifest = manifest
-	c.rawManifest = rawManifest
+	c.manifest = newManifest
+	c.rawManifest = append([]byte(nil), rawManifest...)
 	c.secrets = secrets
 	c.adminCerts = adminCerts
 
@@ -90,41 +108,102 @@ func (c *Core) SetManifest(ctx context.Context, rawManifest []byte) (map[string]
 // GetCertQuote gets the Coordinators certificate and corresponding quote (containing the cert)
 //
 // Returns the a remote attestation quote of its own certificate alongside this certificate that allows to verify the Coordinator's integrity and authentication for use of the ClientAPI.
-func (c *Core) GetCertQuote(ctx context.Context) (string, []byte, error) {
+// If a nonce is given, a fresh quote is issued whose report data binds both the root certificate and the nonce (see quote.NonceReportData).
+// Otherwise, the quote generated at startup is returned.
+// The certificate chain starts with the intermediate and root certificate, followed by the external CAs that issued the root certificate, if any.
+func (c *Core) GetCertQuote(ctx context.Context, nonce []byte) (string, []byte, error) {
+	if len(nonce) > quote.MaxNonceSize {
+		return "", nil, fmt.Errorf("nonce exceeds %d bytes", quote.MaxNonceSize)
+	}
+
+	strCert, rawRootCert, certQuote, err := c.getCertQuote()
+	if err != nil || len(nonce) == 0 || c.simulationMode {
+		return strCert, certQuote, err
+	}
+
+	// Issue the fresh quote without holding the lock
+	nonceIssuer, ok := c.qi.(quote.NonceIssuer)
+	if !ok {
+		return "", nil, errors.New("quote issuer does not support nonces")
+	}
+	certQuote, err = nonceIssuer.IssueWithNonce(rawRootCert, nonce)
+	if err != nil {
+		c.zaplogger.Error("Failed to issue a quote with a nonce.", zap.Error(err))
+		return "", nil, err
+	}
+	return strCert, certQuote, nil
+}
+
+// getCertQuote returns the PEM-encoded certificate chain, the raw root certificate, and the quote generated at startup
+func (c *Core) getCertQuote() (string, []byte, []byte, error) {
 	defer c.mux.Unlock()
 	if err := c.requireState(stateAcceptingManifest, stateAcceptingMarbles); err != nil {
-		return "", nil, err
+		return "", nil, nil, err
 	}
 
 	pemCertRoot := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.rootCert.Raw})
 	if len(pemCertRoot) <= 0 {
-		return "", nil, errors.New("pem.EncodeToMemory failed for root certificate")
+		return "", nil, nil, errors.New("pem.EncodeToMemory failed for root certificate")
 	}
 
 	// Include intermediate certificate if a manifest has been set
 	pemCertIntermediate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.intermediateCert.Raw})
 	if len(pemCertIntermediate) <= 0 {
-		return "", nil, errors.New("pem.EncodeToMemory failed for intermediate certificate")
+		return "", nil, nil, errors.New("pem.EncodeToMemory failed for intermediate certificate")
 	}
 
+	// The external CAs that issued the root certificate are returned by GetRootChain, as clients take the last certificate as the root
 	strCert := string(pemCertIntermediate) + string(pemCertRoot)
-	return strCert, c.quote, nil
+	return strCert, c.rootCert.Raw, c.quote, nil
 }
 
 // GetManifestSignature returns the hash of the manifest
 //
-// Returns a SHA256 hash of the active manifest.
+// Returns a SHA256 hash of the active manifest's canonical form (see manifest.Canonicalize).
+// It does not depend on the formatting of the manifest, so equivalent JSON and YAML manifests have the same signature.
 func (c *Core) GetManifestSignature(ctx context.Context) []byte {
 	c.mux.Lock()
-	rawManifest := c.rawManifest
-	c.mux.Unlock()
-	if rawManifest == nil {
+	defer c.mux.Unlock()
+	return c.manifestHash()
+}
+
+// GetRawManifestSignature returns the hash of the manifest as it was submitted
+//
+// Returns a SHA256 hash of the bytes of the active manifest, which can be compared with the hash of the file that was submitted.
+func (c *Core) GetRawManifestSignature(ctx context.Context) []byte {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.rawManifest == nil {
 		return nil
 	}
-	hash := sha256.Sum256(rawManifest)
+	hash := sha256.Sum256(c.rawManifest)
 	return hash[:]
 }
 
+// GetManifest returns the active manifest as it was submitted, in JSON or YAML format
+func (c *Core) GetManifest(ctx context.Context) ([]byte, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.rawManifest == nil {
+		return nil, errors.New("no manifest has been set")
+	}
+	return append([]byte(nil), c.rawManifest...), nil
+}
+
+// manifestHash returns the SHA256 hash of the active manifest's canonical form. The caller must hold c.mux.
+func (c *Core) manifestHash() []byte {
+	if c.rawManifest == nil {
+		return nil
+	}
+	hash, err := manifest.CanonicalHash(c.rawManifest)
+	if err != nil {
+		// can't happen, as the manifest was canonicalized when it was set
+		c.zaplogger.Error("Could not compute the canonical hash of the manifest.", zap.Error(err))
+		return nil
+	}
+	return hash
+}
+
 // Recover sets an encryption key (ideally decrypted from the recovery data) and tries to unseal and load a saved state again.
 func (c *Core) Recover(ctx context.Context, secret []byte) (int, error) {
 	defer c.mux.Unlock()
@@ -154,6 +233,94 @@ func (c *Core) GetStatus(ctx context.Context) (statusCode int, status string, er
 	return c.getStatus(ctx)
 }
 
+// GetCAProfiles returns the effective profiles of the Coordinator's root and intermediate CA.
+func (c *Core) GetCAProfiles(ctx context.Context) (root CertificateProfile, intermediate CertificateProfile) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return profileFromCertificate(c.rootCert), profileFromCertificate(c.intermediateCert)
+}
+
+// GetRootCSR returns a DER-encoded certificate signing request for the Coordinator's root key
+//
+// An external CA can sign the request to make the Coordinator a subordinate CA. The signed certificate is imported with SetRootCertificateChain.
+func (c *Core) GetRootCSR(ctx context.Context) ([]byte, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingManifest); err != nil {
+		return nil, err
+	}
+
+	template := x509.CertificateRequest{
+		Subject:     c.rootCert.Subject,
+		DNSNames:    c.rootCert.DNSNames,
+		IPAddresses: c.rootCert.IPAddresses,
+	}
+	return x509.CreateCertificateRequest(rand.Reader, &template, c.rootPrivK)
+}
+
+// SetRootCertificateChain replaces the Coordinator's self-signed root certificate with one issued by an external CA
+//
+// rawChain contains the PEM-encoded certificate of the Coordinator's root key, followed by the certificates of the issuing CAs.
+// The intermediate certificate is reissued under the new root certificate and a new quote is generated.
+// The chain can only be set once and before the manifest, as certificates issued earlier would not chain to it.
+func (c *Core) SetRootCertificateChain(ctx context.Context, rawChain []byte) error {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingManifest); err != nil {
+		return err
+	}
+	if len(c.rootChain) > 0 {
+		return errors.New("a root certificate chain was already imported")
+	}
+
+	chain, err := parseCertificateChain(rawChain)
+	if err != nil {
+		return err
+	}
+	if err := checkRootCertificateChain(chain, c.rootPrivK); err != nil {
+		return err
+	}
+
+	intermediateCert, intermediatePrivK, err := generateCert(c.rootCert.DNSNames, c.intermediateProfile, chain[0], c.rootPrivK)
+	if err != nil {
+		c.zaplogger.Error("Could not generate a new intermediate CA for the imported root certificate.", zap.Error(err))
+		return err
+	}
+
+	// The quote is bound to the root certificate
+	rootCert := c.rootCert
+	c.rootCert = chain[0]
+	certQuote, err := c.generateQuote()
+	if err != nil {
+		c.rootCert = rootCert
+		return err
+	}
+
+	c.rootChain = chain[1:]
+	c.intermediateCert = intermediateCert
+	c.intermediatePrivK = intermediatePrivK
+	c.quote = certQuote
+
+	// Seal the root key with the imported chain, so that the Coordinator keeps its identity after a restart
+	recoveryData, err := c.recovery.GetRecoveryData()
+	if err != nil {
+		c.zaplogger.Error("Could not retrieve the current recovery data from the recovery module.", zap.Error(err))
+		return err
+	}
+	if err := c.sealState(recoveryData); err != nil {
+		c.zaplogger.Error("sealState failed", zap.Error(err))
+	}
+
+	c.zaplogger.Info("Imported a root certificate issued by an external CA.", zap.String("issuer", c.rootCert.Issuer.String()))
+	return nil
+}
+
+// GetRootChain returns the PEM-encoded certificates of the external CAs that issued the Coordinator's root certificate.
+// It returns an empty string if the root certificate is self-signed.
+func (c *Core) GetRootChain(ctx context.Context) string {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.rootChainPEM()
+}
+
 // VerifyAdmin checks if a given client certificate matches the admin certificates specified in the manifest
 func (c *Core) VerifyAdmin(ctx context.Context, clientCerts []*x509.Certificate) bool {
 	// Check if a supplied client cert matches the supplied ones from the manifest stored in the core
@@ -169,7 +336,7 @@ func (c *Core) VerifyAdmin(ctx context.Context, clientCerts []*x509.Certificate)
 	return false
 }
 
-// UpdateManifest allows to update certain package parameters, supplied via a JSON manifest
+// UpdateManifest allows to update certain package parameters, supplied via a JSON or YAML manifest
 func (c *Core) UpdateManifest(ctx context.Context, rawUpdateManifest []byte) error {
 	defer c.mux.Unlock()
 
@@ -179,8 +346,12 @@ func (c *Core) UpdateManifest(ctx context.Context, rawUpdateManifest []byte) err
 	}
 
 	// Unmarshal & check update manifest
+	rawUpdateManifest, err := manifest.ToJSON(rawUpdateManifest)
+	if err != nil {
+		return err
+	}
 	var updateManifest manifest.Manifest
-	if err := json.Unmarshal(rawUpdateManifest, &updateManifest); err != nil {
+	if err := manifest.Unmarshal(rawUpdateManifest, &updateManifest); err != nil {
 		return err
 	}
 	if err := updateManifest.CheckUpdate(ctx, c.manifest.Packages, c.updateManifest.Packages); err != nil {
@@ -188,7 +359,7 @@ func (c *Core) UpdateManifest(ctx context.Context, rawUpdateManifest []byte) err
 	}
 
 	// Generate new intermediate CA for Marble gRPC authentication
-	intermediateCert, intermediatePrivK, err := generateCert(c.rootCert.DNSNames, coordinatorIntermediateName, c.rootCert, c.rootPrivK)
+	intermediateCert, intermediatePrivK, err := generateCert(c.rootCert.DNSNames, c.intermediateProfile, c.rootCert, c.rootPrivK)
 	if err != nil {
 		c.zaplogger.Error("Could not generate a new intermediate CA for Marble authentication.", zap.Error(err))
 		return err
@@ -220,6 +391,9 @@ func (c *Core) UpdateManifest(ctx context.Context, rawUpdateManifest []byte) err
 	c.rawUpdateManifest = rawUpdateManifest
 	c.intermediateCert = intermediateCert
 	c.intermediatePrivK = intermediatePrivK
+	// Marbles must be restarted to enforce the update, so the previous intermediate is not trusted anymore
+	c.previousIntermediateCert = nil
+	c.intermediateRotation = nil
 
 	// Overwrite regenerated secrets in core
 	for name, secret := range regeneratedSecrets {
@@ -232,6 +406,52 @@ func (c *Core) UpdateManifest(ctx context.Context, rawUpdateManifest []byte) err
 	return c.sealState(currentRecoveryData)
 }
 
+// RotateIntermediate replaces the intermediate CA without changing the manifest or shared secrets
+//
+// Certificates issued by the previous intermediate CA stay trusted during the configured overlap period.
+// In this period, Marbles can fetch a new certificate using the Renew RPC, e.g., with tlsconfig.Renew.
+// The Coordinator stops trusting the previous intermediate CA after the overlap period, but peers keep trusting it until they renew.
+// Only with automatic rotation, the certificates of the previous intermediate CA expire after the overlap period of its scheduled rotation.
+func (c *Core) RotateIntermediate(ctx context.Context) error {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return err
+	}
+	return c.rotateIntermediate()
+}
+
+// GetIntermediateRotation returns information about the last rotation of the intermediate CA, or nil if it has not been rotated.
+func (c *Core) GetIntermediateRotation(ctx context.Context) *IntermediateRotation {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.intermediateRotation == nil {
+		return nil
+	}
+	rotation := *c.intermediateRotation
+	return &rotation
+}
+
+// GetSecretStatus returns the expiry and rotation status of the shared certificate secrets.
+func (c *Core) GetSecretStatus(ctx context.Context) map[string]SecretStatus {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.getSecretStatus()
+}
+
+// GetPackageActivations returns the number of activations of each Marble type per matched package.
+func (c *Core) GetPackageActivations(ctx context.Context) map[string]map[string]uint {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	packageActivations := make(map[string]map[string]uint, len(c.packageActivations))
+	for marbleType, activations := range c.packageActivations {
+		packageActivations[marbleType] = make(map[string]uint, len(activations))
+		for pkgName, count := range activations {
+			packageActivations[marbleType][pkgName] = count
+		}
+	}
+	return packageActivations
+}
+
 func (c *Core) performRecovery(encryptionKey []byte) error {
 	if err := c.sealer.SetEncryptionKey(encryptionKey); err != nil {
 		return err
@@ -247,7 +467,6 @@ func (c *Core) performRecovery(encryptionKey []byte) error {
 	c.intermediateCert = intermediateCert
 	c.intermediatePrivK = intermediatePrivK
 
-	c.quote = c.generateQuote()
-
-	return nil
+	c.quote, err = c.generateQuote()
+	return err
 }
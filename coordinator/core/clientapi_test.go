@@ -7,14 +7,18 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/edgelesssys/marblerun/coordinator/manifest"
 	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
 	"github.com/edgelesssys/marblerun/test"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -38,8 +42,99 @@ func TestGetManifestSignature(t *testing.T) {
 	assert.NoError(err)
 
 	sig := c.GetManifestSignature(context.TODO())
-	expectedHash := sha256.Sum256([]byte(test.ManifestJSON))
-	assert.Equal(expectedHash[:], sig)
+	expectedHash, err := manifest.CanonicalHash([]byte(test.ManifestJSON))
+	assert.NoError(err)
+	assert.Equal(expectedHash, sig)
+
+	rawSig := c.GetRawManifestSignature(context.TODO())
+	expectedRawHash := sha256.Sum256([]byte(test.ManifestJSON))
+	assert.Equal(expectedRawHash[:], rawSig)
+}
+
+func TestGetManifestSignatureFormats(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Reformat the manifest: indentation and key order differ, but the content is the same
+	var indented bytes.Buffer
+	require.NoError(json.Indent(&indented, []byte(test.ManifestJSON), "", "\t"))
+	yamlManifest, err := manifest.ToYAML([]byte(test.ManifestJSON))
+	require.NoError(err)
+	reordered, err := manifest.ToJSON(yamlManifest)
+	require.NoError(err)
+
+	expectedHash, err := manifest.CanonicalHash([]byte(test.ManifestJSON))
+	require.NoError(err)
+	for _, rawManifest := range [][]byte{[]byte(test.ManifestJSON), indented.Bytes(), reordered, yamlManifest} {
+		c, _ := mustSetup()
+		assert.Nil(c.GetManifestSignature(context.TODO()))
+		assert.Nil(c.GetRawManifestSignature(context.TODO()))
+		_, err := c.SetManifest(context.TODO(), rawManifest)
+		require.NoError(err)
+
+		// The signature does not depend on the format, the raw signature is the hash of the submitted manifest
+		assert.Equal(expectedHash, c.GetManifestSignature(context.TODO()))
+		expectedRawHash := sha256.Sum256(rawManifest)
+		assert.Equal(expectedRawHash[:], c.GetRawManifestSignature(context.TODO()))
+	}
+
+	canonical, err := manifest.Canonicalize([]byte(`{ "b": [1e3, 1.50, -0, "<\u0026>"], "a": {"d": null, "c": true} }`))
+	require.NoError(err)
+	assert.Equal(`{"a":{"c":true,"d":null},"b":[1000,1.5,0,"<&>"]}`, string(canonical))
+}
+
+func TestGetManifest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, _ := mustSetup()
+	_, err := c.GetManifest(context.TODO())
+	assert.Error(err)
+
+	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+	require.NoError(err)
+	rawManifest, err := c.GetManifest(context.TODO())
+	require.NoError(err)
+	assert.Equal(test.ManifestJSON, string(rawManifest))
+}
+
+func TestManifestDiff(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// Formatting is ignored
+	yamlManifest, err := manifest.ToYAML([]byte(test.ManifestJSON))
+	require.NoError(err)
+	differences, err := manifest.Diff([]byte(test.ManifestJSON), yamlManifest)
+	require.NoError(err)
+	assert.Empty(differences)
+
+	var mnf manifest.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &mnf))
+	oldManifest, err := json.Marshal(mnf)
+	require.NoError(err)
+	backend := mnf.Packages["backend"]
+	backend.Debug = true
+	mnf.Packages["backend"] = backend
+	delete(mnf.Marbles, "frontend")
+	mnf.Admins = map[string]string{"admin": "cert"}
+	newManifest, err := json.Marshal(mnf)
+	require.NoError(err)
+
+	differences, err = manifest.Diff(oldManifest, newManifest)
+	require.NoError(err)
+	require.Len(differences, 3)
+	assert.Equal("/Admins", differences[0].Path)
+	assert.Equal("Admins", differences[0].Section())
+	assert.Equal("null", string(differences[0].Old))
+	assert.Equal(`{"admin":"cert"}`, string(differences[0].New))
+	assert.Equal("/Marbles/frontend", differences[1].Path)
+	assert.Equal("Marbles", differences[1].Section())
+	assert.NotNil(differences[1].Old)
+	assert.Nil(differences[1].New)
+	assert.Equal("/Packages/backend/Debug", differences[2].Path)
+	assert.Equal("false", string(differences[2].Old))
+	assert.Equal("true", string(differences[2].New))
 }
 
 func TestSetManifest(t *testing.T) {
@@ -157,21 +252,325 @@ func TestSetManifestInvalid(t *testing.T) {
 	c = testManifestInvalidDebugCase(c, manifest, backendPackage, assert, require)
 }
 
+func TestSetManifestInvalidTemplates(t *testing.T) {
+	testCases := map[string]struct {
+		template string
+		wantErr  string
+	}{
+		"valid":                       {template: `{{ pem .Secrets.cert_shared.Cert }}{{ hex .Marblerun.SealKey }}{{ .Secrets.cert_shared.Cert.Subject.CommonName }}`},
+		"valid with":                  {template: `{{ with .Secrets.cert_shared }}{{ pem .Private }}{{ end }}`},
+		"valid root variable":         {template: `{{ range $i, $e := .Secrets }}{{ pem $.Secrets.cert_shared.Cert }}{{ end }}`},
+		"syntax error":                {template: `{{ pem .Secrets.cert_shared.Cert }`, wantErr: "unexpected"},
+		"unknown function":            {template: `{{ pme .Secrets.cert_shared.Cert }}`, wantErr: `function "pme" not defined`},
+		"undeclared secret":           {template: `{{ pem .Secrets.foo.Cert }}`, wantErr: "secret foo is not declared"},
+		"unknown field":               {template: `{{ pem .Secrets.cert_shared.Certificate }}`, wantErr: "Secret has no field Certificate"},
+		"unknown nested field":        {template: `{{ .Secrets.cert_shared.Cert.Subjekt }}`, wantErr: "Certificate has no field Subjekt"},
+		"unknown reserved":            {template: `{{ pem .Marblerun.IntermediateCA.Cert }}`, wantErr: "unknown reserved secret IntermediateCA"},
+		"unknown root":                {template: `{{ pem .Secret.cert_shared.Cert }}`, wantErr: "unknown field Secret"},
+		"undeclared in root variable": {template: `{{ with .Secrets.cert_shared }}{{ pem $.Secrets.foo.Cert }}{{ end }}`, wantErr: "secret foo is not declared"},
+		"encoded binary":              {template: `{{ base64 (pkcs12 .Secrets.cert_shared "password") }}{{ der .Secrets.cert_shared.Cert | hex }}`},
+		"binary":                      {template: `{{ pkcs12 .Secrets.cert_shared "password" }}`, wantErr: "pkcs12 produces binary data"},
+		"binary in parentheses":       {template: `{{ (jks .Secrets.cert_shared "password") }}`, wantErr: "jks produces binary data"},
+		"binary in with":              {template: `{{ with der .Secrets.cert_shared.Cert }}{{ . }}{{ end }}`, wantErr: "der produces binary data"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			c, manifest := mustSetup()
+			marble := manifest.Marbles["backend_first"]
+			marble.Parameters.Files["/tmp/test"] = tc.template
+			manifest.Marbles["backend_first"] = marble
+			rawManifest, err := json.Marshal(manifest)
+			require.NoError(err)
+
+			_, err = c.SetManifest(context.TODO(), rawManifest)
+			if tc.wantErr == "" {
+				assert.NoError(err)
+				return
+			}
+			require.Error(err)
+			assert.Contains(err.Error(), tc.wantErr)
+			assert.Contains(err.Error(), "backend_first")
+			assert.Contains(err.Error(), "/tmp/test")
+		})
+	}
+}
+
+func TestSetManifestStructuredFiles(t *testing.T) {
+	testCases := map[string]struct {
+		file    string
+		wantErr string
+	}{
+		"valid":             {file: `{"Content": "{{ base64 .Secrets.cert_shared.Cert }}", "Encoding": "base64", "Mode": "0644", "Owner": "1000:1000"}`},
+		"directory":         {file: `{"Directory": true, "Mode": "0750"}`},
+		"invalid mode":      {file: `{"Content": "data", "Mode": "0999"}`, wantErr: "octal"},
+		"mode out of range": {file: `{"Content": "data", "Mode": "17777"}`, wantErr: "invalid mode"},
+		"invalid encoding":  {file: `{"Content": "data", "Encoding": "base32"}`, wantErr: "unsupported encoding"},
+		"invalid owner":     {file: `{"Content": "data", "Owner": "root"}`, wantErr: "invalid owner"},
+		"directory content": {file: `{"Directory": true, "Content": "data"}`, wantErr: "must not have content"},
+		"invalid template":  {file: `{"Content": "{{ pem .Secrets.foo.Cert }}"}`, wantErr: "secret foo is not declared"},
+		"binary":            {file: `{"Content": "{{ pkcs12 .Secrets.cert_shared \"password\" }}"}`},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			c, mnf := mustSetup()
+			var file rpc.File
+			err := json.Unmarshal([]byte(tc.file), &file)
+			if err == nil {
+				marble := mnf.Marbles["backend_first"]
+				marble.Parameters.StructuredFiles = map[string]*rpc.File{"/tmp/file": &file}
+				mnf.Marbles["backend_first"] = marble
+				var rawManifest []byte
+				rawManifest, err = json.Marshal(mnf)
+				require.NoError(err)
+				_, err = c.SetManifest(context.TODO(), rawManifest)
+			}
+
+			if tc.wantErr == "" {
+				assert.NoError(err)
+				return
+			}
+			require.Error(err)
+			assert.Contains(err.Error(), tc.wantErr)
+		})
+	}
+
+	// A path must not be specified twice
+	c, mnf := mustSetup()
+	marble := mnf.Marbles["backend_first"]
+	marble.Parameters.StructuredFiles = map[string]*rpc.File{"/tmp/defg.txt": {Content: []byte("data")}}
+	mnf.Marbles["backend_first"] = marble
+	rawManifest, err := json.Marshal(mnf)
+	require.NoError(t, err)
+	_, err = c.SetManifest(context.TODO(), rawManifest)
+	assert.Error(t, err)
+}
+
+func TestSetManifestHostAllowlist(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	setAllowlist := func(allowlist *rpc.HostAllowlist) error {
+		c, mnf := mustSetup()
+		marble := mnf.Marbles["backend_first"]
+		marble.Parameters.HostAllowlist = allowlist
+		mnf.Marbles["backend_first"] = marble
+		rawManifest, err := json.Marshal(mnf)
+		require.NoError(err)
+		_, err = c.SetManifest(context.TODO(), rawManifest)
+		return err
+	}
+
+	assert.NoError(setAllowlist(&rpc.HostAllowlist{Env: map[string]string{"PORT": "[0-9]+", "LANG": ""}, Args: []string{"--verbose"}}))
+	assert.NoError(setAllowlist(&rpc.HostAllowlist{}))
+	assert.Error(setAllowlist(&rpc.HostAllowlist{Env: map[string]string{"PORT": "[0-9"}}))
+	assert.Error(setAllowlist(&rpc.HostAllowlist{Env: map[string]string{"A=B": ""}}))
+	assert.Error(setAllowlist(&rpc.HostAllowlist{Args: []string{"(--verbose"}}))
+}
+
+func TestSetManifestSecretSize(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	setSecret := func(secret manifest.Secret) error {
+		c, mnf := mustSetup()
+		mnf.Secrets["cert_private"] = secret
+		rawManifest, err := json.Marshal(mnf)
+		require.NoError(err)
+		_, err = c.SetManifest(context.TODO(), rawManifest)
+		return err
+	}
+
+	assert.NoError(setSecret(manifest.Secret{Type: "cert-rsa", Size: 1024}))
+	assert.NoError(setSecret(manifest.Secret{Type: "rsa", Size: 2048}))
+	assert.NoError(setSecret(manifest.Secret{Type: "cert-ecdsa", Size: 384}))
+	assert.NoError(setSecret(manifest.Secret{Type: "ed25519"}))
+
+	// unique secrets are only generated on activation, so the manifest check must catch invalid sizes
+	assert.Error(setSecret(manifest.Secret{Type: "cert-rsa"}))
+	assert.Error(setSecret(manifest.Secret{Type: "cert-rsa", Size: 3}))
+	assert.Error(setSecret(manifest.Secret{Type: "rsa", Size: 1024}))
+	assert.Error(setSecret(manifest.Secret{Type: "cert-ecdsa", Size: 512}))
+	assert.Error(setSecret(manifest.Secret{Type: "cert-ed25519", Size: 256}))
+	assert.Error(setSecret(manifest.Secret{Type: "symmetric-key", Size: 12}))
+	assert.Error(setSecret(manifest.Secret{Type: "foo"}))
+}
+
+func TestReservedSecretNames(t *testing.T) {
+	// The names checked in manifest templates must match the reserved secrets provided to marbles
+	var names []string
+	typ := reflect.TypeOf(reservedSecrets{})
+	for i := 0; i < typ.NumField(); i++ {
+		names = append(names, typ.Field(i).Name)
+	}
+	assert.ElementsMatch(t, names, manifest.ReservedSecretNames)
+}
+
+func TestSetManifestUnknownFields(t *testing.T) {
+	assert := assert.New(t)
+
+	c, _ := mustSetup()
+	rawManifest := strings.Replace(test.ManifestJSON, `"MaxActivations"`, `"MaxActivation"`, 1)
+	_, err := c.SetManifest(context.TODO(), []byte(rawManifest))
+	assert.Error(err)
+
+	c, _ = mustSetup()
+	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON+"{}"))
+	assert.Error(err)
+
+	c, mnf := mustSetup()
+	marble := mnf.Marbles["backend_first"]
+	marble.Parameters.StructuredFiles = map[string]*rpc.File{"/tmp/file": {Content: []byte("content")}}
+	mnf.Marbles["backend_first"] = marble
+	jsonManifest, err := json.Marshal(mnf)
+	assert.NoError(err)
+	_, err = c.SetManifest(context.TODO(), []byte(strings.Replace(string(jsonManifest), `"Content"`, `"Contents"`, 1)))
+	assert.Error(err)
+
+	// the fields of certificate templates are checked, too
+	rawManifest = strings.Replace(test.ManifestJSON, `"SerialNumber": 42,`, `"SerialNumber": 42, "Foo": 1,`, 1)
+	c, _ = mustSetup()
+	_, err = c.SetManifest(context.TODO(), []byte(rawManifest))
+	assert.Error(err)
+
+	// sealed manifests are decoded leniently, so they can still be loaded if a field is removed
+	var sealedManifest manifest.Manifest
+	assert.NoError(json.Unmarshal([]byte(rawManifest), &sealedManifest))
+}
+
+func TestManifestSchema(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rawSchema, err := manifest.Schema()
+	require.NoError(err)
+	var schema map[string]interface{}
+	require.NoError(json.Unmarshal(rawSchema, &schema))
+	assert.Equal("http://json-schema.org/draft-07/schema#", schema["$schema"])
+	assert.Contains(schema["properties"], "Marbles")
+
+	_, mnf := mustSetup()
+	rawManifest, err := json.Marshal(mnf)
+	require.NoError(err)
+	for _, valid := range []string{test.ManifestJSON, test.ManifestJSONWithRecoveryKey, test.IntegrationManifestJSON, test.UpdateManifest, string(rawManifest)} {
+		assert.Empty(manifest.ValidateSchema([]byte(valid)))
+	}
+
+	invalid := `{
+		"Packages": {"frontend": {"Debug": "yes", "SecurityVersion": -1, "uniqueID": 42}},
+		"Marbles": {"frontend": {"Package": "frontend", "MaxActivation": 1}},
+		"Secrets": {"cert": {"Type": "cert-ecdsa", "Cert": {"NotAfter": "tomorrow"}}}
+	}`
+	var problems []string
+	for _, problem := range manifest.ValidateSchema([]byte(invalid)) {
+		problems = append(problems, problem.Error())
+	}
+	assert.Equal([]string{
+		"/Marbles/frontend/MaxActivation: unknown field",
+		"/Packages/frontend/Debug: expected boolean, got string",
+		"/Packages/frontend/SecurityVersion: -1 is less than the minimum 0",
+		"/Packages/frontend/uniqueID: expected string, got number",
+		"/Secrets/cert/Cert/NotAfter: \"tomorrow\" is not an RFC 3339 date-time",
+	}, problems)
+}
+
+func TestSetManifestYAML(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	yamlManifest, err := manifest.ToYAML([]byte(test.ManifestJSON))
+	require.NoError(err)
+	canonicalManifest, err := manifest.ToJSON(yamlManifest)
+	require.NoError(err)
+	assert.Equal(manifest.FormatYAML, manifest.DetectFormat(yamlManifest))
+	assert.Equal(manifest.FormatJSON, manifest.DetectFormat(canonicalManifest))
+
+	// YAML and JSON describe the same manifest
+	var expected, actual manifest.Manifest
+	require.NoError(manifest.Unmarshal([]byte(test.ManifestJSON), &expected))
+	require.NoError(manifest.Unmarshal(canonicalManifest, &actual))
+	assert.Equal(expected, actual)
+
+	// The YAML manifest is stored as submitted and has the same signature as the JSON manifest
+	c, _ := mustSetup()
+	_, err = c.SetManifest(context.TODO(), yamlManifest)
+	require.NoError(err)
+	expectedHash, err := manifest.CanonicalHash([]byte(test.ManifestJSON))
+	require.NoError(err)
+	assert.Equal(expectedHash, c.GetManifestSignature(context.TODO()))
+	assert.Equal(expected, c.manifest)
+	storedManifest, err := c.GetManifest(context.TODO())
+	require.NoError(err)
+	assert.Equal(yamlManifest, storedManifest)
+
+	// Reformatting the YAML manifest does not change the signature
+	reformatted := []byte("# comment\n" + strings.Replace(string(yamlManifest), "Packages:", "Packages:   ", 1))
+	c, _ = mustSetup()
+	_, err = c.SetManifest(context.TODO(), reformatted)
+	require.NoError(err)
+	assert.Equal(expectedHash, c.GetManifestSignature(context.TODO()))
+
+	// Updates can be in YAML format, too
+	yamlUpdate, err := manifest.ToYAML([]byte(test.UpdateManifest))
+	require.NoError(err)
+	assert.NoError(c.UpdateManifest(context.TODO(), yamlUpdate))
+
+	c, _ = mustSetup()
+	_, err = c.SetManifest(context.TODO(), []byte("Packages: [unclosed"))
+	assert.Error(err)
+}
+
 func TestGetCertQuote(t *testing.T) {
 	assert := assert.New(t)
 
 	c, _ := mustSetup()
 
-	cert, _, err := c.GetCertQuote(context.TODO())
+	cert, _, err := c.GetCertQuote(context.TODO(), nil)
 	assert.NoError(err, "GetCertQuote should not fail (without manifest)")
 	assert.Contains(cert, "-----BEGIN CERTIFICATE-----", "simple format check")
 
 	c.SetManifest(context.TODO(), []byte(test.ManifestJSON))
-	_, _, err = c.GetCertQuote(context.TODO())
+	_, _, err = c.GetCertQuote(context.TODO(), nil)
 	assert.NoError(err, "GetCertQuote should not fail (with manifest)")
 	//todo check quote
 }
 
+func TestGetCertQuoteWithNonce(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c, _ := mustSetup()
+	issuer := quote.NewMockIssuer()
+
+	_, startupQuote, err := c.GetCertQuote(context.TODO(), nil)
+	require.NoError(err)
+
+	// the quote binds the root certificate and the nonce
+	nonce := []byte("nonce")
+	_, nonceQuote, err := c.GetCertQuote(context.TODO(), nonce)
+	require.NoError(err)
+	expectedQuote, err := issuer.IssueWithNonce(c.rootCert.Raw, nonce)
+	require.NoError(err)
+	assert.Equal(expectedQuote, nonceQuote)
+	assert.NotEqual(startupQuote, nonceQuote)
+
+	// a different nonce yields a different quote
+	_, otherQuote, err := c.GetCertQuote(context.TODO(), []byte("other nonce"))
+	require.NoError(err)
+	assert.NotEqual(nonceQuote, otherQuote)
+
+	// nonces are limited in size
+	_, _, err = c.GetCertQuote(context.TODO(), make([]byte, quote.MaxNonceSize+1))
+	assert.Error(err)
+}
+
 func TestGetStatus(t *testing.T) {
 	assert := assert.New(t)
 	c, _ := mustSetup()
@@ -10,19 +10,14 @@ package core
 import (
 	"context"
 	"crypto"
-	"crypto/ecdsa"
-	"crypto/ed25519"
-	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
-	"crypto/x509/pkix"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
-	"math"
+	"strings"
 	"sync"
 	"time"
 
@@ -38,25 +33,41 @@ import (
 
 // Core implements the core logic of the Coordinator
 type Core struct {
-	rootCert          *x509.Certificate
-	intermediateCert  *x509.Certificate
-	adminCerts        []*x509.Certificate
-	quote             []byte
-	rootPrivK         *ecdsa.PrivateKey
-	intermediatePrivK *ecdsa.PrivateKey
-	sealer            Sealer
-	recovery          recovery.Recovery
-	manifest          manifest.Manifest
-	rawManifest       []byte
-	updateManifest    manifest.Manifest
-	rawUpdateManifest []byte
-	secrets           map[string]manifest.Secret
-	state             state
-	qv                quote.Validator
-	qi                quote.Issuer
-	activations       map[string]uint
-	mux               sync.Mutex
-	zaplogger         *zap.Logger
+	rootCert            *x509.Certificate
+	intermediateCert    *x509.Certificate
+	adminCerts          []*x509.Certificate
+	quote               []byte
+	rootChain           []*x509.Certificate
+	rootPrivK           crypto.Signer
+	intermediatePrivK   crypto.Signer
+	rootProfile         CertificateProfile
+	intermediateProfile CertificateProfile
+	// previousIntermediateCert is still trusted during the overlap period after a rotation
+	previousIntermediateCert     *x509.Certificate
+	intermediateRotation         *IntermediateRotation
+	intermediateOverlap          time.Duration
+	intermediateRotationInterval time.Duration
+	secretRotations              map[string]time.Time
+	sealer                       Sealer
+	recovery                     recovery.Recovery
+	manifest                     manifest.Manifest
+	rawManifest                  []byte
+	updateManifest               manifest.Manifest
+	rawUpdateManifest            []byte
+	secrets                      map[string]manifest.Secret
+	state                        state
+	qv                           quote.Validator
+	qi                           quote.Issuer
+	activations                  map[string]uint
+	packageActivations           map[string]map[string]uint
+	// marbleKeys holds the PKCS #8 encoded keys of unique asymmetric secrets by marble UUID and secret name
+	marbleKeys     map[string]map[string][]byte
+	quoteCache     *quoteCache
+	stop           chan struct{}
+	stopOnce       sync.Once
+	simulationMode bool
+	mux            sync.Mutex
+	zaplogger      *zap.Logger
 }
 
 // The sequence of states a Coordinator may be in
@@ -72,15 +83,21 @@ const (
 
 // sealedState represents the state information, required for persistence, that gets sealed to the filesystem
 type sealedState struct {
-	RootPrivK           []byte
-	IntermediatePrivK   []byte
-	RawManifest         []byte
-	RawUpdateManifest   []byte
-	RawRootCert         []byte
-	RawIntermediateCert []byte
-	Secrets             map[string]manifest.Secret
-	State               state
-	Activations         map[string]uint
+	RootPrivK                   []byte
+	IntermediatePrivK           []byte
+	RawManifest                 []byte
+	RawUpdateManifest           []byte
+	RawRootCert                 []byte
+	RawIntermediateCert         []byte
+	RawRootChain                [][]byte
+	RawPreviousIntermediateCert []byte
+	IntermediateRotation        *IntermediateRotation
+	SecretRotations             map[string]time.Time
+	Secrets                     map[string]manifest.Secret
+	State                       state
+	Activations                 map[string]uint
+	PackageActivations          map[string]map[string]uint
+	MarbleKeys                  map[string]map[string][]byte
 }
 
 // coordinatorName is the name of the Coordinator. It is used as CN of the root certificate.
@@ -107,16 +124,49 @@ func (c *Core) advanceState(newState state) {
 	c.state = newState
 }
 
+// Options contains optional settings of the Core
+type Options struct {
+	// QuoteCacheTTL is the duration for which the infrastructure a platform's quotes matched is cached. Zero disables the cache.
+	QuoteCacheTTL time.Duration
+	// SimulationMode disables quote generation and the verification of Marble quotes. It must only be enabled for testing.
+	SimulationMode bool
+	// RootCA is the profile used to create the Coordinator's root certificate.
+	RootCA CertificateProfile
+	// IntermediateCA is the profile used to create the Coordinator's intermediate certificate.
+	IntermediateCA CertificateProfile
+	// IntermediateRotationInterval is the maximum age of the intermediate CA before it is rotated. Zero disables automatic rotation.
+	// If it is set, Marble certificates expire at the end of the overlap period after the scheduled rotation of their intermediate CA.
+	// Otherwise, they do not expire, so peers keep accepting certificates of a rotated intermediate CA until they renew their trusted intermediate CAs.
+	IntermediateRotationInterval time.Duration
+	// IntermediateOverlap is the duration for which certificates issued by the previous intermediate CA are trusted after a rotation.
+	IntermediateOverlap time.Duration
+	// SecretRotationCheckInterval is the interval in which shared certificate secrets are checked for expiry and renewed according to their rotation policy. Zero disables the checks.
+	SecretRotationCheckInterval time.Duration
+}
+
 // NewCore creates and initializes a new Core object
-func NewCore(dnsNames []string, qv quote.Validator, qi quote.Issuer, sealer Sealer, recovery recovery.Recovery, zapLogger *zap.Logger) (*Core, error) {
+func NewCore(dnsNames []string, qv quote.Validator, qi quote.Issuer, sealer Sealer, recovery recovery.Recovery, opts Options, zapLogger *zap.Logger) (*Core, error) {
+	if err := checkCAProfiles(opts.RootCA, opts.IntermediateCA); err != nil {
+		return nil, err
+	}
+
 	c := &Core{
-		state:       stateUninitialized,
-		activations: make(map[string]uint),
-		qv:          qv,
-		qi:          qi,
-		sealer:      sealer,
-		recovery:    recovery,
-		zaplogger:   zapLogger,
+		state:                        stateUninitialized,
+		activations:                  make(map[string]uint),
+		packageActivations:           make(map[string]map[string]uint),
+		marbleKeys:                   make(map[string]map[string][]byte),
+		quoteCache:                   newQuoteCache(opts.QuoteCacheTTL),
+		stop:                         make(chan struct{}),
+		simulationMode:               opts.SimulationMode,
+		rootProfile:                  opts.RootCA.withDefaults(coordinatorName),
+		intermediateProfile:          opts.IntermediateCA.withDefaults(coordinatorIntermediateName),
+		intermediateOverlap:          opts.IntermediateOverlap,
+		intermediateRotationInterval: opts.IntermediateRotationInterval,
+		qv:                           qv,
+		qi:                           qi,
+		sealer:                       sealer,
+		recovery:                     recovery,
+		zaplogger:                    zapLogger,
 	}
 
 	zapLogger.Info("loading state")
@@ -126,22 +176,22 @@ func NewCore(dnsNames []string, qv quote.Validator, qi quote.Issuer, sealer Seal
 			return nil, err
 		}
 		c.zaplogger.Error("Failed to decrypt sealed state. Processing with a new state. Use the /recover API endpoint to load an old state, or submit a new manifest to overwrite the old state. Look up the documentation for more information on how to proceed.")
-		rootCert, rootPrivK, err = generateCert(dnsNames, coordinatorName, nil, nil)
+		rootCert, rootPrivK, err = generateCert(dnsNames, c.rootProfile, nil, nil)
 		if err != nil {
 			return nil, err
 		}
-		intermediateCert, intermediatePrivK, err = generateCert(dnsNames, coordinatorIntermediateName, rootCert, rootPrivK)
+		intermediateCert, intermediatePrivK, err = generateCert(dnsNames, c.intermediateProfile, rootCert, rootPrivK)
 		if err != nil {
 			return nil, err
 		}
 		c.advanceState(stateRecovery)
 	} else if rootCert == nil {
 		c.zaplogger.Info("No sealed state found. Proceeding with new state.")
-		rootCert, rootPrivK, err = generateCert(dnsNames, coordinatorName, nil, nil)
+		rootCert, rootPrivK, err = generateCert(dnsNames, c.rootProfile, nil, nil)
 		if err != nil {
 			return nil, err
 		}
-		intermediateCert, intermediatePrivK, err = generateCert(dnsNames, coordinatorIntermediateName, rootCert, rootPrivK)
+		intermediateCert, intermediatePrivK, err = generateCert(dnsNames, c.intermediateProfile, rootCert, rootPrivK)
 		if err != nil {
 			return nil, err
 		}
@@ -152,11 +202,26 @@ func NewCore(dnsNames []string, qv quote.Validator, qi quote.Issuer, sealer Seal
 	c.rootPrivK = rootPrivK
 	c.intermediateCert = intermediateCert
 	c.intermediatePrivK = intermediatePrivK
-	c.quote = c.generateQuote()
+	c.quote, err = c.generateQuote()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.IntermediateRotationInterval > 0 {
+		go c.rotateIntermediatePeriodically(opts.IntermediateRotationInterval)
+	}
+	if opts.SecretRotationCheckInterval > 0 {
+		go c.rotateSecretsPeriodically(opts.SecretRotationCheckInterval)
+	}
 
 	return c, nil
 }
 
+// Stop ends the background rotations of the intermediate CA and the shared secrets. It may be called multiple times.
+func (c *Core) Stop() {
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
 // NewCoreWithMocks creates a new core object with quote and seal mocks for testing.
 func NewCoreWithMocks() *Core {
 	zapLogger, err := zap.NewDevelopment()
@@ -168,16 +233,16 @@ func NewCoreWithMocks() *Core {
 	issuer := quote.NewMockIssuer()
 	sealer := &MockSealer{}
 	recovery := recovery.NewSinglePartyRecovery()
-	core, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, zapLogger)
+	core, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
 	if err != nil {
 		panic(err)
 	}
 	return core
 }
 
-// inSimulationMode returns true if we operate in OE_SIMULATION mode
-func (c *Core) inSimulationMode() bool {
-	return len(c.quote) == 0
+// InSimulationMode returns true if the Coordinator was configured to run in simulation mode
+func (c *Core) InSimulationMode(ctx context.Context) bool {
+	return c.simulationMode
 }
 
 // GetTLSConfig gets the core's TLS configuration
@@ -190,21 +255,55 @@ func (c *Core) GetTLSConfig() (*tls.Config, error) {
 
 // GetTLSRootCertificate creates a TLS certificate for the Coordinators self-signed x509 certificate
 func (c *Core) GetTLSRootCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	if c.state == stateUninitialized {
 		return nil, errors.New("don't have a cert yet")
 	}
-	return util.TLSCertFromDER(c.rootCert.Raw, c.rootPrivK), nil
+	cert := util.TLSCertFromDER(c.rootCert.Raw, c.rootPrivK)
+	for _, issuer := range c.rootChain {
+		cert.Certificate = append(cert.Certificate, issuer.Raw)
+	}
+	return cert, nil
 }
 
 // GetTLSIntermediateCertificate creates a TLS certificate for the Coordinator's x509 intermediate certificate based on the self-signed x509 root certificate
 func (c *Core) GetTLSIntermediateCertificate(clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
 	if c.state == stateUninitialized {
 		return nil, errors.New("don't have a cert yet")
 	}
-	return util.TLSCertFromDER(c.intermediateCert.Raw, c.intermediatePrivK), nil
+	cert := util.TLSCertFromDER(c.intermediateCert.Raw, c.intermediatePrivK)
+	// Clients that trust an external CA need the complete chain
+	if len(c.rootChain) > 0 {
+		cert.Certificate = append(cert.Certificate, c.rootCert.Raw)
+		for _, issuer := range c.rootChain {
+			cert.Certificate = append(cert.Certificate, issuer.Raw)
+		}
+	}
+	return cert, nil
+}
+
+// externalChainPEM returns the PEM-encoded root certificate followed by the certificates of the external CAs that issued it.
+// It returns an empty string if the root certificate is self-signed.
+func (c *Core) externalChainPEM() string {
+	if len(c.rootChain) == 0 {
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.rootCert.Raw})) + c.rootChainPEM()
 }
 
-func (c *Core) loadState() (*x509.Certificate, *ecdsa.PrivateKey, *x509.Certificate, *ecdsa.PrivateKey, error) {
+// rootChainPEM returns the PEM-encoded certificates of the external CAs that issued the root certificate
+func (c *Core) rootChainPEM() string {
+	var chain []byte
+	for _, issuer := range c.rootChain {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.Raw})...)
+	}
+	return string(chain)
+}
+
+func (c *Core) loadState() (*x509.Certificate, crypto.Signer, *x509.Certificate, crypto.Signer, error) {
 	encodedRecoveryData, stateRaw, unsealErr := c.sealer.Unseal()
 
 	// Retrieve and set recovery data from state
@@ -232,7 +331,7 @@ func (c *Core) loadState() (*x509.Certificate, *ecdsa.PrivateKey, *x509.Certific
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
-	rootPrivk, err := x509.ParseECPrivateKey(loadedState.RootPrivK)
+	rootPrivk, err := parsePrivateKey(loadedState.RootPrivK)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
@@ -240,15 +339,36 @@ func (c *Core) loadState() (*x509.Certificate, *ecdsa.PrivateKey, *x509.Certific
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
-	intermediatePrivK, err := x509.ParseECPrivateKey(loadedState.IntermediatePrivK)
+	intermediatePrivK, err := parsePrivateKey(loadedState.IntermediatePrivK)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
+	var rootChain []*x509.Certificate
+	for _, rawIssuer := range loadedState.RawRootChain {
+		issuer, err := x509.ParseCertificate(rawIssuer)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		rootChain = append(rootChain, issuer)
+	}
+	var previousIntermediateCert *x509.Certificate
+	if loadedState.RawPreviousIntermediateCert != nil {
+		if previousIntermediateCert, err = x509.ParseCertificate(loadedState.RawPreviousIntermediateCert); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
 
-	if err := json.Unmarshal(loadedState.RawManifest, &c.manifest); err != nil {
-		return nil, nil, nil, nil, err
+	// The manifest is stored as it was submitted, which may be YAML. It is missing if the state was sealed after importing a root certificate chain.
+	if len(loadedState.RawManifest) > 0 {
+		jsonManifest, err := manifest.ToJSON(loadedState.RawManifest)
+		if err != nil {
+			return nil, nil, nil, nil, err
+		}
+		if err := json.Unmarshal(jsonManifest, &c.manifest); err != nil {
+			return nil, nil, nil, nil, err
+		}
+		c.rawManifest = loadedState.RawManifest
 	}
-	c.rawManifest = loadedState.RawManifest
 
 	// Generate and load admin certs from manifest
 	adminCerts, err := generateAdminCertsFromManifest(c.manifest.Admins)
@@ -267,36 +387,65 @@ func (c *Core) loadState() (*x509.Certificate, *ecdsa.PrivateKey, *x509.Certific
 
 	c.state = loadedState.State
 	c.activations = loadedState.Activations
+	c.packageActivations = loadedState.PackageActivations
+	if c.packageActivations == nil {
+		// state sealed by a version without the registry
+		c.packageActivations = make(map[string]map[string]uint)
+	}
+	c.marbleKeys = loadedState.MarbleKeys
+	if c.marbleKeys == nil {
+		c.marbleKeys = make(map[string]map[string][]byte)
+	}
 	c.secrets = loadedState.Secrets
 	c.adminCerts = adminCerts
+	c.rootChain = rootChain
+	c.previousIntermediateCert = previousIntermediateCert
+	c.intermediateRotation = loadedState.IntermediateRotation
+	c.secretRotations = loadedState.SecretRotations
 
 	return rootCert, rootPrivk, intermediateCert, intermediatePrivK, err
 }
 
 func (c *Core) sealState(recoveryData []byte) error {
 	// marshal root CA private key
-	rootPrivKEncoded, err := x509.MarshalECPrivateKey(c.rootPrivK)
+	rootPrivKEncoded, err := x509.MarshalPKCS8PrivateKey(c.rootPrivK)
 	if err != nil {
 		return err
 	}
 
 	// marshal intermediate CA private key
-	intermediatePrivKEncoded, err := x509.MarshalECPrivateKey(c.intermediatePrivK)
+	intermediatePrivKEncoded, err := x509.MarshalPKCS8PrivateKey(c.intermediatePrivK)
 	if err != nil {
 		return err
 	}
 
+	var rawRootChain [][]byte
+	for _, issuer := range c.rootChain {
+		rawRootChain = append(rawRootChain, issuer.Raw)
+	}
+
+	var rawPreviousIntermediateCert []byte
+	if c.previousIntermediateCert != nil {
+		rawPreviousIntermediateCert = c.previousIntermediateCert.Raw
+	}
+
 	// seal with manifest set
 	state := sealedState{
-		RootPrivK:           rootPrivKEncoded,
-		IntermediatePrivK:   intermediatePrivKEncoded,
-		RawManifest:         c.rawManifest,
-		RawUpdateManifest:   c.rawUpdateManifest,
-		RawRootCert:         c.rootCert.Raw,
-		RawIntermediateCert: c.intermediateCert.Raw,
-		State:               c.state,
-		Secrets:             c.secrets,
-		Activations:         c.activations,
+		RootPrivK:                   rootPrivKEncoded,
+		IntermediatePrivK:           intermediatePrivKEncoded,
+		RawManifest:                 c.rawManifest,
+		RawUpdateManifest:           c.rawUpdateManifest,
+		RawRootCert:                 c.rootCert.Raw,
+		RawIntermediateCert:         c.intermediateCert.Raw,
+		RawRootChain:                rawRootChain,
+		RawPreviousIntermediateCert: rawPreviousIntermediateCert,
+		IntermediateRotation:        c.intermediateRotation,
+		SecretRotations:             c.secretRotations,
+		State:                       c.state,
+		Secrets:                     c.secrets,
+		Activations:                 c.activations,
+		PackageActivations:          c.packageActivations,
+		MarbleKeys:                  c.marbleKeys,
 	}
 	stateRaw, err := json.Marshal(state)
 	if err != nil {
@@ -305,45 +454,41 @@ func (c *Core) sealState(recoveryData []byte) error {
 	return c.sealer.Seal(recoveryData, stateRaw)
 }
 
-func generateCert(dnsNames []string, commonName string, parentCertificate *x509.Certificate, parentPrivateKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+func generateCert(dnsNames []string, profile CertificateProfile, parentCertificate *x509.Certificate, parentPrivateKey crypto.Signer) (*x509.Certificate, crypto.Signer, error) {
 	// Generate private key
-	privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	privk, err := profile.generateKey()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// Certifcate parameter
-	notBefore := time.Now()
-	notAfter := notBefore.Add(math.MaxInt64)
-
 	serialNumber, err := util.GenerateCertificateSerialNumber()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	// TODO: what else do we need to set here?
-	// Do we need x509.KeyUsageKeyEncipherment?
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
-		Subject: pkix.Name{
-			CommonName: commonName,
-		},
-		DNSNames:    dnsNames,
-		IPAddresses: util.DefaultCertificateIPAddresses,
-		NotBefore:   notBefore,
-		NotAfter:    notAfter,
+		DNSNames:     dnsNames,
+		IPAddresses:  util.DefaultCertificateIPAddresses,
+		NotBefore:    time.Now(),
 
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 	}
+	if err := profile.apply(&template); err != nil {
+		return nil, nil, err
+	}
 
 	if parentCertificate == nil {
 		parentCertificate = &template
 		parentPrivateKey = privk
+	} else if template.NotAfter.After(parentCertificate.NotAfter) {
+		// A CA must not outlive its issuer
+		template.NotAfter = parentCertificate.NotAfter
 	}
-	certRaw, err := x509.CreateCertificate(rand.Reader, &template, parentCertificate, &privk.PublicKey, parentPrivateKey)
+	certRaw, err := x509.CreateCertificate(rand.Reader, &template, parentCertificate, privk.Public(), parentPrivateKey)
 
 	if err != nil {
 		return nil, nil, err
@@ -356,17 +501,20 @@ func generateCert(dnsNames []string, commonName string, parentCertificate *x509.
 	return cert, privk, nil
 }
 
-func (c *Core) generateQuote() []byte {
+func (c *Core) generateQuote() ([]byte, error) {
+	if c.simulationMode {
+		// We store an empty quote that will make it transparent to the client that the integrity of the mesh can not be guaranteed.
+		c.zaplogger.Warn("Running in simulation mode. The integrity of the mesh can not be guaranteed.")
+		return []byte{}, nil
+	}
+
 	c.zaplogger.Info("generating quote")
 	quote, err := c.qi.Issue(c.rootCert.Raw)
 	if err != nil {
-		c.zaplogger.Warn("Failed to get quote. Proceeding in simulation mode.")
-		// If we run in SimulationMode we get an error here
-		// For testing purpose we do not want to just fail here
-		// Instead we store an empty quote that will make it transparent to the client that the integrity of the mesh can not be guaranteed.
-		return []byte{}
+		c.zaplogger.Error("Failed to get quote.", zap.Error(err))
+		return nil, fmt.Errorf("generating quote failed: %v", err)
 	}
-	return quote
+	return quote, nil
 }
 
 func getClientTLSCert(ctx context.Context) *x509.Certificate {
@@ -399,7 +547,7 @@ func (c *Core) getStatus(ctx context.Context) (int, string, error) {
 	return int(c.state), status, nil
 }
 
-func (c *Core) generateSecrets(ctx context.Context, secrets map[string]manifest.Secret, id uuid.UUID, parentCertificate *x509.Certificate, parentPrivKey *ecdsa.PrivateKey) (map[string]manifest.Secret, error) {
+func (c *Core) generateSecrets(ctx context.Context, secrets map[string]manifest.Secret, id uuid.UUID, parentCertificate *x509.Certificate, parentPrivKey crypto.Signer) (map[string]manifest.Secret, error) {
 	// Create a new map so we do not overwrite the entries in the manifest
 	newSecrets := make(map[string]manifest.Secret)
 
@@ -430,8 +578,10 @@ func (c *Core) generateSecrets(ctx context.Context, secrets map[string]manifest.
 				}
 			} else {
 				salt := id.String() + name
-				secretKeyDerive := c.rootPrivK.D.Bytes()
-				var err error
+				secretKeyDerive, err := privateKeySeed(c.rootPrivK)
+				if err != nil {
+					return nil, err
+				}
 				generatedValue, err = util.DeriveKey(secretKeyDerive, []byte(salt), secret.Size/8)
 				if err != nil {
 					return nil, err
@@ -444,64 +594,35 @@ func (c *Core) generateSecrets(ctx context.Context, secrets map[string]manifest.
 
 			newSecrets[name] = secret
 
-		case "cert-rsa":
-			// Generate keys
-			privKey, err := rsa.GenerateKey(rand.Reader, int(secret.Size))
-			if err != nil {
-				c.zaplogger.Error("Failed to generate RSA key", zap.Error(err))
-				return nil, err
-			}
-
-			// Generate certificate
-			newSecrets[name], err = c.generateCertificateForSecret(secret, parentCertificate, parentPrivKey, privKey, &privKey.PublicKey)
-			if err != nil {
-				return nil, err
-			}
-
-		case "cert-ed25519":
-			if secret.Size != 0 {
-				return nil, fmt.Errorf("invalid secret size for cert-ed25519, none is expected. given: %v", name)
+		case "cert-rsa", "cert-ed25519", "cert-ecdsa", "rsa", "ed25519", "ecdsa":
+			// cert-rsa secrets of existing manifests may be smaller, so the minimum size only applies to the rsa type
+			if secret.Type == "rsa" && secret.Size < 2048 {
+				return nil, fmt.Errorf("invalid secret %v: RSA keys must have a size of at least 2048 bits, given: %d", name, secret.Size)
 			}
 
 			// Generate keys
-			pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
-			if err != nil {
-				c.zaplogger.Error("Failed to generate ed25519 key", zap.Error(err))
-				return nil, err
+			// If a secret is shared, we generate a completely random key. If a secret is constrained to a marble, we keep the key of its first activation,
+			// so that a restarted marble gets the same key again.
+			algorithm := strings.TrimPrefix(secret.Type, "cert-")
+			var privKey crypto.PrivateKey
+			var pubKey crypto.PublicKey
+			var err error
+			if secret.Shared {
+				privKey, pubKey, err = generateKeyPair(algorithm, secret.Size)
+			} else {
+				privKey, pubKey, err = c.marbleKeyPair(id, name, algorithm, secret.Size)
 			}
-
-			// Generate certificate
-			newSecrets[name], err = c.generateCertificateForSecret(secret, parentCertificate, parentPrivKey, privKey, pubKey)
 			if err != nil {
-				return nil, err
+				c.zaplogger.Error("Failed to generate key", zap.String("name", name), zap.String("type", secret.Type), zap.Uint("size", secret.Size), zap.Error(err))
+				return nil, fmt.Errorf("invalid secret %v: %v", name, err)
 			}
 
-		case "cert-ecdsa":
-			var curve elliptic.Curve
-
-			switch secret.Size {
-			case 224:
-				curve = elliptic.P224()
-			case 256:
-				curve = elliptic.P256()
-			case 384:
-				curve = elliptic.P384()
-			case 521:
-				curve = elliptic.P521()
-			default:
-				c.zaplogger.Error("ECDSA secrets only support P224, P256, P384 and P521 as curve. Check the supplied size.", zap.String("name", name), zap.String("type", secret.Type), zap.Uint("size", secret.Size))
-				return nil, fmt.Errorf("unsupported size %d: does not map to a supported curve", secret.Size)
-			}
-
-			// Generate keys
-			privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
-			if err != nil {
-				c.zaplogger.Error("Failed to generate ECSDA key", zap.Error(err))
-				return nil, err
+			// Generate certificate, or only set the keys for key secrets
+			if strings.HasPrefix(secret.Type, "cert-") {
+				newSecrets[name], err = c.generateCertificateForSecret(secret, parentCertificate, parentPrivKey, privKey, pubKey)
+			} else {
+				newSecrets[name], err = setKeyPair(secret, privKey, pubKey)
 			}
-
-			// Generate certificate
-			newSecrets[name], err = c.generateCertificateForSecret(secret, parentCertificate, parentPrivKey, privKey, &privKey.PublicKey)
 			if err != nil {
 				return nil, err
 			}
@@ -514,7 +635,7 @@ func (c *Core) generateSecrets(ctx context.Context, secrets map[string]manifest.
 	return newSecrets, nil
 }
 
-func (c *Core) generateCertificateForSecret(secret manifest.Secret, parentCertificate *x509.Certificate, parentPrivKey *ecdsa.PrivateKey, privKey crypto.PrivateKey, pubKey crypto.PublicKey) (manifest.Secret, error) {
+func (c *Core) generateCertificateForSecret(secret manifest.Secret, parentCertificate *x509.Certificate, parentPrivKey crypto.Signer, privKey crypto.PrivateKey, pubKey crypto.PublicKey) (manifest.Secret, error) {
 	// Load given information from manifest as template
 	template := x509.Certificate(secret.Cert)
 
@@ -551,7 +672,7 @@ func (c *Core) generateCertificateForSecret(secret manifest.Secret, parentCertif
 	if template.NotAfter.IsZero() {
 		// User can specify a duration in days, otherwise it's one year by default
 		if secret.ValidFor == 0 {
-			secret.ValidFor = 365
+			secret.ValidFor = manifest.DefaultValidFor
 		}
 
 		template.NotAfter = time.Now().AddDate(0, 0, int(secret.ValidFor))
@@ -575,17 +696,20 @@ func (c *Core) generateCertificateForSecret(secret manifest.Secret, parentCertif
 
 	// Assemble secret object
 	secret.Cert = manifest.Certificate(*cert)
+	return setKeyPair(secret, privKey, pubKey)
+}
+
+// setKeyPair sets the PKCS #8 encoded private key and the PKIX encoded public key of the secret.
+func setKeyPair(secret manifest.Secret, privKey crypto.PrivateKey, pubKey crypto.PublicKey) (manifest.Secret, error) {
+	var err error
 	secret.Private, err = x509.MarshalPKCS8PrivateKey(privKey)
 	if err != nil {
-		c.zaplogger.Error("Failed to marshal private key to secret object", zap.Error(err))
-		return manifest.Secret{}, err
+		return manifest.Secret{}, fmt.Errorf("failed to marshal private key to secret object: %v", err)
 	}
 	secret.Public, err = x509.MarshalPKIXPublicKey(pubKey)
 	if err != nil {
-		c.zaplogger.Error("Failed to marshal public key to secret object", zap.Error(err))
-		return manifest.Secret{}, err
+		return manifest.Secret{}, fmt.Errorf("failed to marshal public key to secret object: %v", err)
 	}
-
 	return secret, nil
 }
 
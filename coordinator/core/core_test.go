@@ -8,7 +8,17 @@ package core
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
 	"testing"
+	"time"
 
 	"github.com/edgelesssys/marblerun/coordinator/manifest"
 	"github.com/edgelesssys/marblerun/coordinator/quote"
@@ -47,6 +57,232 @@ func TestCore(t *testing.T) {
 	assert.Error(err)
 }
 
+func TestSimulationMode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	validator := quote.NewMockValidator()
+	issuer := quote.NewFailIssuer()
+	recovery := recovery.NewSinglePartyRecovery()
+
+	// quote failures are fatal in production mode
+	_, err = NewCore([]string{"localhost"}, validator, issuer, &MockSealer{}, recovery, Options{}, zapLogger)
+	assert.Error(err)
+
+	// simulation mode runs without a quote
+	c, err := NewCore([]string{"localhost"}, validator, issuer, &MockSealer{}, recovery, Options{SimulationMode: true}, zapLogger)
+	require.NoError(err)
+	assert.True(c.InSimulationMode(context.TODO()))
+	_, certQuote, err := c.GetCertQuote(context.TODO(), nil)
+	require.NoError(err)
+	assert.Empty(certQuote)
+
+	assert.False(NewCoreWithMocks().InSimulationMode(context.TODO()))
+}
+
+func TestCAProfiles(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	validator := quote.NewMockValidator()
+	issuer := quote.NewMockIssuer()
+	sealer := &MockSealer{}
+	recovery := recovery.NewSinglePartyRecovery()
+
+	maxPathLen := 0
+	opts := Options{
+		RootCA: CertificateProfile{
+			KeyAlgorithm: KeyAlgorithmRSA2048,
+			ValidFor:     3650,
+			Organization: []string{"Example Corp"},
+		},
+		IntermediateCA: CertificateProfile{
+			KeyAlgorithm:        KeyAlgorithmEd25519,
+			ValidFor:            365,
+			CommonName:          "Example Intermediate",
+			PermittedDNSDomains: []string{"localhost", "example.com"},
+			PermittedIPRanges:   []string{"127.0.0.0/8"},
+			MaxPathLen:          &maxPathLen,
+		},
+	}
+
+	// invalid profiles are rejected
+	_, err = NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{RootCA: CertificateProfile{KeyAlgorithm: "dsa"}}, zapLogger)
+	assert.Error(err)
+	_, err = NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{IntermediateCA: CertificateProfile{PermittedIPRanges: []string{"127.0.0.1"}}}, zapLogger)
+	assert.Error(err)
+	_, err = NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{RootCA: CertificateProfile{MaxPathLen: &maxPathLen}}, zapLogger)
+	assert.Error(err)
+
+	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, opts, zapLogger)
+	require.NoError(err)
+
+	root, intermediate := c.GetCAProfiles(context.TODO())
+	assert.Equal(KeyAlgorithmRSA2048, root.KeyAlgorithm)
+	assert.EqualValues(3650, root.ValidFor)
+	assert.Equal(coordinatorName, root.CommonName)
+	assert.Equal([]string{"Example Corp"}, root.Organization)
+	assert.Nil(root.MaxPathLen)
+	assert.Equal(KeyAlgorithmEd25519, intermediate.KeyAlgorithm)
+	assert.EqualValues(365, intermediate.ValidFor)
+	assert.Equal("Example Intermediate", intermediate.CommonName)
+	assert.Equal([]string{"localhost", "example.com"}, intermediate.PermittedDNSDomains)
+	assert.Equal([]string{"127.0.0.0/8"}, intermediate.PermittedIPRanges)
+	require.NotNil(intermediate.MaxPathLen)
+	assert.Equal(0, *intermediate.MaxPathLen)
+
+	// the intermediate certificate chains to the root certificate
+	roots := x509.NewCertPool()
+	roots.AddCert(c.rootCert)
+	_, err = c.intermediateCert.Verify(x509.VerifyOptions{Roots: roots, DNSName: "localhost"})
+	assert.NoError(err)
+
+	// shared secrets can be issued by the intermediate CA
+	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+	require.NoError(err)
+
+	// the sealed CA keys and certificates are kept regardless of the configured profiles
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
+	require.NoError(err)
+	assert.Equal(c.rootCert.Raw, c2.rootCert.Raw)
+	assert.Equal(c.rootPrivK, c2.rootPrivK)
+	assert.Equal(c.intermediatePrivK, c2.intermediatePrivK)
+
+	// keys sealed by older versions are SEC 1 encoded
+	legacyCore := NewCoreWithMocks()
+	legacyKey, err := x509.MarshalECPrivateKey(legacyCore.rootPrivK.(*ecdsa.PrivateKey))
+	require.NoError(err)
+	parsedKey, err := parsePrivateKey(legacyKey)
+	require.NoError(err)
+	assert.Equal(legacyCore.rootPrivK, parsedKey)
+
+	// the default profile
+	root, intermediate = legacyCore.GetCAProfiles(context.TODO())
+	assert.Equal(KeyAlgorithmECDSAP256, root.KeyAlgorithm)
+	assert.Zero(root.ValidFor)
+	assert.Equal(coordinatorIntermediateName, intermediate.CommonName)
+}
+
+func TestRootCertificateChain(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	validator := quote.NewMockValidator()
+	issuer := quote.NewMockIssuer()
+	sealer := &MockSealer{}
+	recovery := recovery.NewSinglePartyRecovery()
+
+	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
+	require.NoError(err)
+
+	// the external CA signs the Coordinator's CSR
+	externalKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	externalTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Enterprise Root CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	externalRaw, err := x509.CreateCertificate(rand.Reader, externalTemplate, externalTemplate, &externalKey.PublicKey, externalKey)
+	require.NoError(err)
+	externalCert, err := x509.ParseCertificate(externalRaw)
+	require.NoError(err)
+
+	rawCSR, err := c.GetRootCSR(context.TODO())
+	require.NoError(err)
+	csr, err := x509.ParseCertificateRequest(rawCSR)
+	require.NoError(err)
+	require.NoError(csr.CheckSignature())
+	assert.Equal(coordinatorName, csr.Subject.CommonName)
+
+	signCSR := func(isCA bool) []byte {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(2),
+			Subject:               csr.Subject,
+			DNSNames:              csr.DNSNames,
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+			BasicConstraintsValid: true,
+			IsCA:                  isCA,
+		}
+		rawCert, err := x509.CreateCertificate(rand.Reader, template, externalCert, csr.PublicKey, externalKey)
+		require.NoError(err)
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rawCert})
+	}
+	externalPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: externalRaw})
+
+	// invalid chains are rejected
+	assert.Error(c.SetRootCertificateChain(context.TODO(), []byte("no certificate")))
+	assert.Error(c.SetRootCertificateChain(context.TODO(), signCSR(true)))
+	assert.Error(c.SetRootCertificateChain(context.TODO(), append(signCSR(false), externalPEM...)))
+	assert.Error(c.SetRootCertificateChain(context.TODO(), append(externalPEM, externalPEM...)))
+
+	chain := append(signCSR(true), externalPEM...)
+	require.NoError(c.SetRootCertificateChain(context.TODO(), chain))
+
+	// the chain can only be imported once
+	assert.Error(c.SetRootCertificateChain(context.TODO(), chain))
+
+	// the root certificate is still the last certificate of the chain returned with the quote, the external CA is returned separately
+	certChain, _, err := c.GetCertQuote(context.TODO(), nil)
+	require.NoError(err)
+	certs, err := parseCertificateChain([]byte(certChain))
+	require.NoError(err)
+	require.Len(certs, 2)
+	assert.Equal(c.intermediateCert.Raw, certs[0].Raw)
+	assert.Equal(c.rootCert.Raw, certs[1].Raw)
+	assert.Equal(string(externalPEM), c.GetRootChain(context.TODO()))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(externalCert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(c.rootCert)
+	_, err = c.intermediateCert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	assert.NoError(err)
+
+	tlsCert, err := c.GetTLSIntermediateCertificate(nil)
+	require.NoError(err)
+	assert.Len(tlsCert.Certificate, 3)
+
+	// the imported chain is sealed, so it survives a restart before the manifest is set
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
+	require.NoError(err)
+	assert.Equal(stateAcceptingManifest, c2.state)
+	assert.Equal(c.rootCert.Raw, c2.rootCert.Raw)
+	assert.Equal(c.rootChain, c2.rootChain)
+	assert.Error(c2.SetRootCertificateChain(context.TODO(), chain))
+
+	// the chain is sealed with the manifest and cannot be changed afterwards
+	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+	require.NoError(err)
+	_, err = c.GetRootCSR(context.TODO())
+	assert.Error(err)
+	assert.Error(c.SetRootCertificateChain(context.TODO(), chain))
+
+	c2, err = NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
+	require.NoError(err)
+	assert.Equal(c.rootCert.Raw, c2.rootCert.Raw)
+	assert.Equal(c.rootChain, c2.rootChain)
+}
+
 func TestSeal(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -61,7 +297,7 @@ func TestSeal(t *testing.T) {
 	sealer := &MockSealer{}
 	recovery := recovery.NewSinglePartyRecovery()
 
-	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, zapLogger)
+	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
 	require.NoError(err)
 
 	// Set manifest. This will seal the state.
@@ -74,7 +310,7 @@ func TestSeal(t *testing.T) {
 	signature := c.GetManifestSignature(context.TODO())
 
 	// Check sealing with a new core initialized with the sealed state.
-	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, zapLogger)
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
 	require.NoError(err)
 	assert.Equal(stateAcceptingMarbles, c2.state)
 
@@ -90,6 +326,21 @@ func TestSeal(t *testing.T) {
 
 	signature2 := c2.GetManifestSignature(context.TODO())
 	assert.Equal(signature, signature2, "manifest signature differs after restart")
+
+	// YAML manifests are sealed as submitted
+	yamlManifest, err := manifest.ToYAML([]byte(test.ManifestJSON))
+	require.NoError(err)
+	sealer = &MockSealer{}
+	c, err = NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), yamlManifest)
+	require.NoError(err)
+	c2, err = NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
+	require.NoError(err)
+	assert.Equal(stateAcceptingMarbles, c2.state)
+	assert.Equal(c.manifest, c2.manifest)
+	assert.Equal(signature, c2.GetManifestSignature(context.TODO()))
+	assert.Equal(c.GetRawManifestSignature(context.TODO()), c2.GetRawManifestSignature(context.TODO()))
 }
 
 func TestRecover(t *testing.T) {
@@ -106,7 +357,7 @@ func TestRecover(t *testing.T) {
 	sealer := &MockSealer{}
 	recovery := recovery.NewSinglePartyRecovery()
 
-	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, zapLogger)
+	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
 	require.NoError(err)
 
 	// new core does not allow recover
@@ -124,7 +375,7 @@ func TestRecover(t *testing.T) {
 
 	// Initialize new core and let unseal fail
 	sealer.unsealError = ErrEncryptionKey
-	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, zapLogger)
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
 	sealer.unsealError = nil
 	require.NoError(err)
 	require.Equal(stateRecovery, c2.state)
@@ -150,6 +401,10 @@ func TestGenerateSecrets(t *testing.T) {
 		"cert-ecdsa384-test":      {Type: "cert-ecdsa", Size: 384, ValidFor: 14, Shared: true},
 		"cert-ecdsa521-test":      {Type: "cert-ecdsa", Size: 521, ValidFor: 14, Shared: true},
 		"cert-rsa-specified-test": {Type: "cert-rsa", Size: 2048, Cert: manifest.Certificate{}, Shared: true},
+		"cert-rsa-small-test":     {Type: "cert-rsa", Size: 1024, ValidFor: 14, Shared: true},
+		"rsa-test":                {Type: "rsa", Size: 2048, Shared: true},
+		"ed25519-test":            {Type: "ed25519", Shared: true},
+		"ecdsa256-test":           {Type: "ecdsa", Size: 256, Shared: true},
 	}
 
 	secretsNoSize := map[string]manifest.Secret{
@@ -168,6 +423,10 @@ func TestGenerateSecrets(t *testing.T) {
 		"cert-ecdsa-invalidsize": {Type: "cert-ecdsa", Size: 512, Shared: true},
 	}
 
+	secretsRSAWrongKeySize := map[string]manifest.Secret{
+		"rsa-invalidsize": {Type: "rsa", Size: 1024, Shared: true},
+	}
+
 	secretsEmptyMap := map[string]manifest.Secret{}
 
 	c := NewCoreWithMocks()
@@ -185,6 +444,34 @@ func TestGenerateSecrets(t *testing.T) {
 	assert.NotNil(generatedSecrets["cert-ecdsa384-test"].Cert.Raw)
 	assert.NotNil(generatedSecrets["cert-ecdsa521-test"].Cert.Raw)
 	assert.NotNil(generatedSecrets["cert-rsa-specified-test"].Cert.Raw)
+	assert.NotNil(generatedSecrets["cert-rsa-small-test"].Cert.Raw)
+
+	// Key secrets have keys, but no certificate
+	for _, name := range []string{"rsa-test", "ed25519-test", "ecdsa256-test"} {
+		secret := generatedSecrets[name]
+		assert.Nil(secret.Cert.Raw)
+		privKey, err := x509.ParsePKCS8PrivateKey(secret.Private)
+		require.NoError(err)
+		pubKey, err := x509.ParsePKIXPublicKey(secret.Public)
+		require.NoError(err)
+		assert.Equal(pubKey, privKey.(crypto.Signer).Public())
+
+		// Keys can be encoded as JWK
+		jwkJSON, err := manifest.EncodeSecretDataToJWK(secret.Private)
+		require.NoError(err)
+		var jwk map[string]string
+		require.NoError(json.Unmarshal([]byte(jwkJSON), &jwk))
+		assert.NotEmpty(jwk["kty"])
+		assert.NotEmpty(jwk["d"])
+		jwkJSON, err = manifest.EncodeSecretDataToJWK(secret.Public)
+		require.NoError(err)
+		jwk = nil
+		require.NoError(json.Unmarshal([]byte(jwkJSON), &jwk))
+		assert.Empty(jwk["d"])
+	}
+	jwkJSON, err := manifest.EncodeSecretDataToJWK(generatedSecrets["ecdsa256-test"].Public)
+	require.NoError(err)
+	assert.Contains(jwkJSON, `"crv":"P-256"`)
 
 	// Check if we get an empty secret map as output for an empty map as input
 	generatedSecrets, err = c.generateSecrets(context.TODO(), secretsEmptyMap, uuid.Nil, c.rootCert, c.rootPrivK)
@@ -211,4 +498,169 @@ func TestGenerateSecrets(t *testing.T) {
 	// However, for ECDSA we fail as we can have multiple curves
 	_, err = c.generateSecrets(context.TODO(), secretsECDSAWrongKeySize, uuid.Nil, c.rootCert, c.rootPrivK)
 	assert.Error(err)
+
+	// RSA keys must not be too short
+	_, err = c.generateSecrets(context.TODO(), secretsRSAWrongKeySize, uuid.Nil, c.rootCert, c.rootPrivK)
+	assert.Error(err)
+}
+
+func TestSecretRotation(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	validator := quote.NewMockValidator()
+	issuer := quote.NewMockIssuer()
+	sealer := &MockSealer{}
+	recovery := recovery.NewSinglePartyRecovery()
+	c, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
+	require.NoError(err)
+
+	var mnf manifest.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &mnf))
+
+	// a rotation policy requires a shared certificate
+	secret := mnf.Secrets["symmetric_key_shared"]
+	secret.Rotation = &manifest.RotationPolicy{RenewBefore: 1}
+	mnf.Secrets["symmetric_key_shared"] = secret
+	rawManifest, err := json.Marshal(mnf)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest)
+	assert.Error(err)
+	secret.Rotation = nil
+	mnf.Secrets["symmetric_key_shared"] = secret
+
+	// RenewBefore must be shorter than the validity
+	secret = mnf.Secrets["cert_shared"]
+	secret.Rotation = &manifest.RotationPolicy{RenewBefore: 7}
+	mnf.Secrets["cert_shared"] = secret
+	rawManifest, err = json.Marshal(mnf)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest)
+	assert.Error(err)
+
+	secret.Rotation.RenewBefore = 2
+	mnf.Secrets["cert_shared"] = secret
+	rawManifest, err = json.Marshal(mnf)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), rawManifest)
+	require.NoError(err)
+
+	oldCert := c.secrets["cert_shared"].Cert
+	status := c.GetSecretStatus(context.TODO())
+	require.Contains(status, "cert_shared")
+	assert.Equal(oldCert.NotAfter.AddDate(0, 0, -2), status["cert_shared"].RenewAt)
+	assert.True(status["cert_shared"].LastRotation.IsZero())
+
+	// nothing is due yet
+	c.mux.Lock()
+	require.NoError(c.rotateExpiringSecrets(time.Now()))
+	c.mux.Unlock()
+	assert.Equal(oldCert.Raw, c.secrets["cert_shared"].Cert.Raw)
+
+	// the certificate is renewed once the renewal time is reached
+	now := oldCert.NotAfter.AddDate(0, 0, -1)
+	c.mux.Lock()
+	require.NoError(c.rotateExpiringSecrets(now))
+	c.mux.Unlock()
+	newSecret := c.secrets["cert_shared"]
+	assert.NotEqual(oldCert.Raw, newSecret.Cert.Raw)
+	assert.NotEqual(oldCert.PublicKey, newSecret.Cert.PublicKey)
+	assert.Equal(oldCert.Subject.CommonName, newSecret.Cert.Subject.CommonName)
+	assert.NoError((*x509.Certificate)(&newSecret.Cert).CheckSignatureFrom(c.intermediateCert))
+	assert.Equal(now, c.GetSecretStatus(context.TODO())["cert_shared"].LastRotation)
+
+	// the renewed secret is sealed
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
+	require.NoError(err)
+	assert.Equal(newSecret.Cert.Raw, c2.secrets["cert_shared"].Cert.Raw)
+	assert.True(now.Equal(c2.secretRotations["cert_shared"]))
+}
+
+func TestGenerateSecretsKeepsUniqueKeys(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	secretsToGenerate := map[string]manifest.Secret{
+		"rsa":          {Type: "rsa", Size: 2048},
+		"ed25519":      {Type: "ed25519"},
+		"ecdsa224":     {Type: "ecdsa", Size: 224},
+		"ecdsa521":     {Type: "ecdsa", Size: 521},
+		"cert-rsa":     {Type: "cert-rsa", Size: 1024},
+		"cert-ecdsa":   {Type: "cert-ecdsa", Size: 256},
+		"cert-ed25519": {Type: "cert-ed25519"},
+	}
+
+	c := NewCoreWithMocks()
+	marbleUUID := uuid.New()
+
+	// a restarted marble gets the same keys
+	secrets, err := c.generateSecrets(context.TODO(), secretsToGenerate, marbleUUID, c.intermediateCert, c.intermediatePrivK)
+	require.NoError(err)
+	restartSecrets, err := c.generateSecrets(context.TODO(), secretsToGenerate, marbleUUID, c.intermediateCert, c.intermediatePrivK)
+	require.NoError(err)
+	otherSecrets, err := c.generateSecrets(context.TODO(), secretsToGenerate, uuid.New(), c.intermediateCert, c.intermediatePrivK)
+	require.NoError(err)
+
+	for name := range secretsToGenerate {
+		require.Contains(secrets, name)
+		assert.Equal(secrets[name].Private, restartSecrets[name].Private, name)
+		assert.Equal(secrets[name].Public, restartSecrets[name].Public, name)
+		assert.NotEqual(secrets[name].Private, otherSecrets[name].Private, name)
+
+		// the kept keys are valid
+		privKey, err := x509.ParsePKCS8PrivateKey(secrets[name].Private)
+		require.NoError(err, name)
+		pubKey, err := x509.ParsePKIXPublicKey(secrets[name].Public)
+		require.NoError(err, name)
+		assert.Equal(pubKey, privKey.(crypto.Signer).Public(), name)
+	}
+
+	// the certificates are issued for the kept keys
+	cert := x509.Certificate(secrets["cert-ecdsa"].Cert)
+	pubKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	require.NoError(err)
+	assert.EqualValues(restartSecrets["cert-ecdsa"].Public, pubKey)
+
+	// invalid sizes are rejected instead of generating a key
+	_, err = c.generateSecrets(context.TODO(), map[string]manifest.Secret{"small": {Type: "cert-rsa", Size: 3}}, marbleUUID, c.intermediateCert, c.intermediatePrivK)
+	assert.Error(err)
+	_, err = c.generateSecrets(context.TODO(), map[string]manifest.Secret{"none": {Type: "cert-rsa"}}, marbleUUID, c.intermediateCert, c.intermediatePrivK)
+	assert.Error(err)
+}
+
+func TestRotateIntermediatePeriodically(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	c, err := NewCore([]string{"localhost"}, quote.NewMockValidator(), quote.NewMockIssuer(), &MockSealer{}, recovery.NewSinglePartyRecovery(), Options{IntermediateRotationInterval: 10 * time.Millisecond}, zapLogger)
+	require.NoError(err)
+	_, err = c.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+	require.NoError(err)
+
+	// the TLS certificate can be fetched while the intermediate CA is rotated
+	require.Eventually(func() bool {
+		_, err := c.GetTLSIntermediateCertificate(nil)
+		require.NoError(err)
+		return c.GetIntermediateRotation(context.TODO()) != nil
+	}, 5*time.Second, time.Millisecond)
+
+	// no rotations happen after the Core was stopped
+	c.Stop()
+	c.Stop()
+	time.Sleep(20 * time.Millisecond)
+	c.mux.Lock()
+	intermediateCert := c.intermediateCert
+	c.mux.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	c.mux.Lock()
+	assert.Equal(intermediateCert, c.intermediateCert)
+	c.mux.Unlock()
 }
@@ -0,0 +1,97 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// minRSAKeySize is the minimum size of generated RSA keys in bits
+const minRSAKeySize = 1024
+
+// generateKeyPair generates a random key pair of the given algorithm ("rsa", "ed25519" or "ecdsa"). The size is the RSA key size or the ECDSA curve size in bits.
+func generateKeyPair(algorithm string, size uint) (crypto.PrivateKey, crypto.PublicKey, error) {
+	switch algorithm {
+	case "rsa":
+		if size < minRSAKeySize {
+			return nil, nil, fmt.Errorf("RSA keys must have a size of at least %d bits, given: %d", minRSAKeySize, size)
+		}
+		privKey, err := rsa.GenerateKey(rand.Reader, int(size))
+		if err != nil {
+			return nil, nil, err
+		}
+		return privKey, &privKey.PublicKey, nil
+
+	case "ed25519":
+		if size != 0 {
+			return nil, nil, fmt.Errorf("ed25519 keys have a fixed size, none is expected. given: %d", size)
+		}
+		pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+		return privKey, pubKey, err
+
+	case "ecdsa":
+		var curve elliptic.Curve
+		switch size {
+		case 224:
+			curve = elliptic.P224()
+		case 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, nil, fmt.Errorf("ECDSA keys only support P224, P256, P384 and P521 as curve, given size: %d", size)
+		}
+		privKey, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return privKey, &privKey.PublicKey, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported key algorithm %s", algorithm)
+}
+
+// marbleKeyPair returns the key pair of the unique secret name of the marble with the given UUID.
+// The first key pair is generated randomly and kept in the state, so that a restarted marble gets the same key pair again.
+// The caller must seal the state if the marble had no key pairs before.
+func (c *Core) marbleKeyPair(id uuid.UUID, name string, algorithm string, size uint) (crypto.PrivateKey, crypto.PublicKey, error) {
+	if rawKey, ok := c.marbleKeys[id.String()][name]; ok {
+		privKey, err := x509.ParsePKCS8PrivateKey(rawKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		signer, ok := privKey.(crypto.Signer)
+		if !ok {
+			return nil, nil, fmt.Errorf("unsupported key type %T", privKey)
+		}
+		return privKey, signer.Public(), nil
+	}
+
+	privKey, pubKey, err := generateKeyPair(algorithm, size)
+	if err != nil {
+		return nil, nil, err
+	}
+	rawKey, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.marbleKeys[id.String()] == nil {
+		c.marbleKeys[id.String()] = make(map[string][]byte)
+	}
+	c.marbleKeys[id.String()][name] = rawKey
+	return privKey, pubKey, nil
+}
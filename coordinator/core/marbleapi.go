@@ -9,11 +9,19 @@ package core
 import (
 	"bytes"
 	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"math"
+	"net/url"
+	"strings"
 	"text/template"
 	"time"
 
@@ -60,7 +68,8 @@ func (c *Core) Activate(ctx context.Context, req *rpc.ActivationReq) (*rpc.Activ
 	if tlsCert == nil {
 		return nil, status.Error(codes.Unauthenticated, "couldn't get marble TLS certificate")
 	}
-	if err := c.verifyManifestRequirement(tlsCert, req.GetQuote(), req.GetMarbleType()); err != nil {
+	pkgName, err := c.verifyManifestRequirement(tlsCert, req.GetQuote(), req.GetMarbleType())
+	if err != nil {
 		return nil, err
 	}
 
@@ -70,87 +79,232 @@ func (c *Core) Activate(ctx context.Context, req *rpc.ActivationReq) (*rpc.Activ
 	}
 
 	// Generate marble authentication secrets
-	authSecrets, err := c.generateMarbleAuthSecrets(req, marbleUUID)
+	authSecrets, err := c.generateMarbleAuthSecrets(req, marbleUUID, pkgName)
 	if err != nil {
 		return nil, err
 	}
 
 	// Generate user-defined unique (= per marble) secrets
+	_, knownMarble := c.marbleKeys[marbleUUID.String()]
 	secrets, err := c.generateSecrets(ctx, c.manifest.Secrets, marbleUUID, c.intermediateCert, c.intermediatePrivK)
 	if err != nil {
 		c.zaplogger.Error("Could not generate specified secrets for the given manifest.", zap.Error(err))
 		return nil, err
 	}
 
+	// Seal the keys of the marble's unique asymmetric secrets, so that it gets them again after a restart of the Coordinator
+	if _, hasKeys := c.marbleKeys[marbleUUID.String()]; hasKeys && !knownMarble {
+		recoveryData, err := c.recovery.GetRecoveryData()
+		if err != nil {
+			c.zaplogger.Error("Could not retrieve the current recovery data from the recovery module.", zap.Error(err))
+			return nil, err
+		}
+		if err := c.sealState(recoveryData); err != nil {
+			c.zaplogger.Error("sealState failed", zap.Error(err))
+		}
+	}
+
 	// Union user-defined unique secrets with user-defined shared secrets
 	for k, v := range c.secrets {
 		secrets[k] = v
 	}
 
 	marble := c.manifest.Marbles[req.GetMarbleType()] // existence has been checked in verifyManifestRequirement
-	params, err := customizeParameters(marble.Parameters, authSecrets, secrets)
+	params, err := customizeParameters(marble.Parameters, authSecrets, secrets, c.trustedIntermediatesPEM(), c.externalChainPEM())
 	if err != nil {
 		c.zaplogger.Error("Could not customize parameters.", zap.Error(err))
 		return nil, err
 	}
 
+	// hand the marble the Coordinator's root certificate, so it can verify the Coordinator when renewing its certificate
+	params.Env[util.MarbleEnvironmentCoordinatorRootCA] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.rootCert.Raw}))
+
+	// hand the marble the peers the manifest allows it to communicate with
+	if peers := c.manifest.MarblePeers(req.GetMarbleType()); peers != nil {
+		rawPeers, err := json.Marshal(peers)
+		if err != nil {
+			return nil, err
+		}
+		params.Env[util.MarbleEnvironmentPeers] = string(rawPeers)
+	}
+
 	// write response
 	resp := &rpc.ActivationResp{
 		Parameters: params,
 	}
 
-	c.zaplogger.Info("Successfully activated new Marble", zap.String("MarbleType", req.MarbleType), zap.String("UUID", marbleUUID.String()))
+	c.zaplogger.Info("Successfully activated new Marble", zap.String("MarbleType", req.MarbleType), zap.String("UUID", marbleUUID.String()), zap.String("Package", pkgName))
 	c.activations[req.GetMarbleType()]++
+	if c.packageActivations[req.GetMarbleType()] == nil {
+		c.packageActivations[req.GetMarbleType()] = make(map[string]uint)
+	}
+	c.packageActivations[req.GetMarbleType()][pkgName]++
 	return resp, nil
 }
 
+// Renew implements the MarbleAPI function to renew the certificate of an activated marble (implements the MarbleServer interface)
+//
+// The marble authenticates with its current certificate, which must have been issued by a trusted intermediate CA, and a quote over this certificate.
+// The new certificate is issued for the public key and names of the CSR, keeping the marble's type and UUID.
+//
+// Returns the new certificate chain and all intermediate CAs that are currently trusted.
+func (c *Core) Renew(ctx context.Context, req *rpc.RenewReq) (*rpc.RenewResp, error) {
+	defer c.mux.Unlock()
+	if err := c.requireState(stateAcceptingMarbles); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, "cannot renew certificates in current state")
+	}
+
+	tlsCert := getClientTLSCert(ctx)
+	if tlsCert == nil {
+		return nil, status.Error(codes.Unauthenticated, "couldn't get marble TLS certificate")
+	}
+	if err := c.verifyMarbleCertificate(tlsCert); err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "marble certificate is not trusted: %v", err)
+	}
+	identity, err := util.MarbleIdentityFromCert(tlsCert)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	marble, ok := c.manifest.Marbles[identity.Type]
+	if !ok {
+		return nil, status.Error(codes.PermissionDenied, "unknown marble type")
+	}
+
+	// The marble must still run a package the manifest allows, e.g., after an update manifest raised its security version
+	pkgName, err := c.matchPackage(tlsCert, req.GetQuote(), marble, identity.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := x509.ParseCertificateRequest(req.GetCSR())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "failed to parse CSR")
+	}
+	certRaw, err := c.generateCertFromCSR(req.GetCSR(), csr.PublicKey, identity.Type, identity.UUID, pkgName)
+	if err != nil {
+		return nil, err
+	}
+
+	certChain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certRaw})
+	certChain = append(certChain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.intermediateCert.Raw})...)
+
+	c.zaplogger.Info("Renewed Marble certificate", zap.String("MarbleType", identity.Type), zap.String("UUID", identity.UUID))
+	return &rpc.RenewResp{
+		CertificateChain: string(certChain) + c.externalChainPEM(),
+		IntermediateCA:   c.trustedIntermediatesPEM(),
+	}, nil
+}
+
 // verifyManifestRequirement verifies marble attempting to register with respect to manifest
-func (c *Core) verifyManifestRequirement(tlsCert *x509.Certificate, certQuote []byte, marbleType string) error {
+//
+// Returns the name of the first of the marble's allowed packages the quote matches.
+func (c *Core) verifyManifestRequirement(tlsCert *x509.Certificate, certQuote []byte, marbleType string) (string, error) {
 	marble, ok := c.manifest.Marbles[marbleType]
 	if !ok {
-		return status.Error(codes.InvalidArgument, "unknown marble type requested")
+		return "", status.Error(codes.InvalidArgument, "unknown marble type requested")
 	}
 
-	pkg, ok := c.manifest.Packages[marble.Package]
-	if !ok {
+	pkgName, err := c.matchPackage(tlsCert, certQuote, marble, marbleType)
+	if err != nil {
+		return "", err
+	}
+
+	// check activation budget (MaxActivations == 0 means infinite budget)
+	activations := c.activations[marbleType]
+	if marble.MaxActivations > 0 && activations >= marble.MaxActivations {
+		return "", status.Error(codes.ResourceExhausted, "reached max activations count for marble type")
+	}
+	return pkgName, nil
+}
+
+// matchPackage returns the name of the first of the marble's allowed packages the quote matches.
+// If the quote matches none of them, the error lists why it was rejected for each package.
+func (c *Core) matchPackage(tlsCert *x509.Certificate, certQuote []byte, marble manifest.Marble, marbleType string) (string, error) {
+	allowedPackages := marble.AllowedPackages()
+	if len(allowedPackages) == 0 {
 		// can't happen
-		return status.Error(codes.Internal, "undefined package")
+		return "", status.Error(codes.Internal, "undefined package")
+	}
+	if c.simulationMode {
+		return allowedPackages[0], nil
+	}
+
+	var problems []string
+	for _, pkgName := range allowedPackages {
+		pkg, ok := c.manifest.Packages[pkgName]
+		if !ok {
+			// can't happen
+			return "", status.Error(codes.Internal, "undefined package")
+		}
+
+		// In case the administrator has updated a package, apply the updated security version
+		if updpkg, ok := c.updateManifest.Packages[pkgName]; ok {
+			pkg.SecurityVersion = updpkg.SecurityVersion
+		}
+
+		err := c.verifyQuote(certQuote, tlsCert.Raw, pkg, marbleType)
+		if err == nil {
+			return pkgName, nil
+		}
+		if len(allowedPackages) == 1 {
+			return "", err
+		}
+		problems = append(problems, fmt.Sprintf("package %s: %s", pkgName, status.Convert(err).Message()))
 	}
+	return "", status.Errorf(codes.Unauthenticated, "quote does not match any allowed package: %s", strings.Join(problems, "; "))
+}
 
-	// In case the administrator has updated a package, apply the updated security version
-	if updpkg, ok := c.updateManifest.Packages[marble.Package]; ok {
-		pkg.SecurityVersion = updpkg.SecurityVersion
+// verifyQuote verifies a Marble's quote against its package and the manifest's infrastructures
+func (c *Core) verifyQuote(certQuote []byte, cert []byte, pkg quote.PackageProperties, marbleType string) error {
+	if len(c.manifest.Infrastructures) == 0 {
+		if err := c.qv.Validate(certQuote, cert, pkg, quote.InfrastructureProperties{}); err != nil {
+			return status.Errorf(codes.Unauthenticated, "invalid quote: %v", err)
+		}
+		return nil
 	}
 
-	if !c.inSimulationMode() {
-		if len(c.manifest.Infrastructures) == 0 {
-			if err := c.qv.Validate(certQuote, tlsCert.Raw, pkg, quote.InfrastructureProperties{}); err != nil {
+	// If the validator reports the infrastructure properties, verify the quote once and match them locally
+	if reporter, ok := c.qv.(quote.InfrastructureReporter); ok {
+		infra, err := reporter.ValidateAndReport(certQuote, cert, pkg)
+		if !errors.Is(err, quote.ErrInfrastructureNotReported) {
+			if err != nil {
 				return status.Errorf(codes.Unauthenticated, "invalid quote: %v", err)
 			}
-		} else {
-			infraMatch := false
-			for _, infra := range c.manifest.Infrastructures {
-				if c.qv.Validate(certQuote, tlsCert.Raw, pkg, infra) == nil {
-					infraMatch = true
-					break
+			for _, required := range c.manifest.Infrastructures {
+				if required.IsCompliant(infra) {
+					return nil
 				}
 			}
-			if !infraMatch {
-				return status.Error(codes.Unauthenticated, "invalid quote")
-			}
+			return status.Error(codes.Unauthenticated, "invalid quote")
 		}
 	}
 
-	// check activation budget (MaxActivations == 0 means infinite budget)
-	activations := c.activations[marbleType]
-	if marble.MaxActivations > 0 && activations >= marble.MaxActivations {
-		return status.Error(codes.ResourceExhausted, "reached max activations count for marble type")
+	// Otherwise validate the quote against each infrastructure, starting with the one the Marble's platform matched before
+	var platformID []byte
+	if identifier, ok := c.qv.(quote.PlatformIdentifier); ok {
+		platformID, _ = identifier.PlatformID(certQuote)
+	}
+	if platformID != nil {
+		if name, ok := c.quoteCache.get(platformID); ok {
+			if infra, ok := c.manifest.Infrastructures[name]; ok && c.qv.Validate(certQuote, cert, pkg, infra) == nil {
+				c.zaplogger.Debug("Quote matched the cached infrastructure of its platform", zap.String("MarbleType", marbleType), zap.String("Infrastructure", name))
+				return nil
+			}
+		}
+	}
+	for name, infra := range c.manifest.Infrastructures {
+		if c.qv.Validate(certQuote, cert, pkg, infra) == nil {
+			if platformID != nil {
+				c.quoteCache.put(platformID, name)
+			}
+			return nil
+		}
 	}
-	return nil
+	return status.Error(codes.Unauthenticated, "invalid quote")
 }
 
 // generateCertFromCSR signs the CSR from marble attempting to register
-func (c *Core) generateCertFromCSR(csrReq []byte, pubk ecdsa.PublicKey, marbleType string, marbleUUID string) ([]byte, error) {
+func (c *Core) generateCertFromCSR(csrReq []byte, pubk crypto.PublicKey, marbleType string, marbleUUID string, pkgName string) ([]byte, error) {
 	// parse and verify CSR
 	csr, err := x509.ParseCertificateRequest(csrReq)
 	if err != nil {
@@ -160,17 +314,32 @@ func (c *Core) generateCertFromCSR(csrReq []byte, pubk ecdsa.PublicKey, marbleTy
 		return nil, status.Error(codes.InvalidArgument, "signature over CSR is invalid")
 	}
 
+	// restrict the requested names to those allowed for the marble type
+	dnsNames, ipAddrs := csr.DNSNames, csr.IPAddresses
+	if policy := c.manifest.Marbles[marbleType].CSRPolicy; policy != nil {
+		if dnsNames, ipAddrs, err = policy.Apply(csr.DNSNames, csr.IPAddresses); err != nil {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+	}
+
 	serialNumber, err := util.GenerateCertificateSerialNumber()
 	if err != nil {
 		return nil, status.Error(codes.Internal, "failed to generate serial")
 	}
 
+	// identify the Marble, so peers can authorize it by its certificate
+	identity := util.MarbleIdentity{
+		Type:         marbleType,
+		UUID:         marbleUUID,
+		Package:      pkgName,
+		ManifestHash: hex.EncodeToString(c.manifestHash()),
+	}
+
 	// create certificate
 	csr.Subject.CommonName = marbleUUID
 	csr.Subject.Organization = c.intermediateCert.Issuer.Organization
 	notBefore := time.Now()
-	// TODO: produce shorter lived certificates
-	notAfter := notBefore.Add(math.MaxInt64)
+	notAfter := c.marbleCertificateExpiry(notBefore)
 	template := x509.Certificate{
 		SerialNumber: serialNumber,
 		Subject:      csr.Subject,
@@ -181,11 +350,12 @@ func (c *Core) generateCertFromCSR(csrReq []byte, pubk ecdsa.PublicKey, marbleTy
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
 		BasicConstraintsValid: true,
 		IsCA:                  false,
-		DNSNames:              csr.DNSNames,
-		IPAddresses:           csr.IPAddresses,
+		DNSNames:              dnsNames,
+		IPAddresses:           ipAddrs,
+		URIs:                  []*url.URL{identity.URI()},
 	}
 
-	certRaw, err := x509.CreateCertificate(rand.Reader, &template, c.intermediateCert, &pubk, c.intermediatePrivK)
+	certRaw, err := x509.CreateCertificate(rand.Reader, &template, c.intermediateCert, pubk, c.intermediatePrivK)
 	if err != nil {
 		return nil, status.Error(codes.Internal, "failed to issue certificate")
 	}
@@ -193,12 +363,35 @@ func (c *Core) generateCertFromCSR(csrReq []byte, pubk ecdsa.PublicKey, marbleTy
 	return certRaw, nil
 }
 
+// marbleCertificateExpiry returns the expiry of a Marble certificate issued at notBefore.
+//
+// Peers keep trusting the intermediate CAs they received until they renew, so certificates of a rotated intermediate CA must expire on their own.
+// With automatic rotation, they expire when the overlap period after the scheduled rotation of the current intermediate CA ends.
+// Without it, they do not expire, as the time of the next rotation is unknown.
+func (c *Core) marbleCertificateExpiry(notBefore time.Time) time.Time {
+	if c.intermediateRotationInterval <= 0 {
+		// TODO: produce shorter lived certificates
+		return notBefore.Add(math.MaxInt64)
+	}
+	rotation := c.intermediateCert.NotBefore.Add(c.intermediateRotationInterval)
+	if rotation.Before(notBefore) {
+		// the rotation is overdue, e.g., because it failed and will be retried
+		rotation = notBefore
+	}
+	return rotation.Add(c.intermediateOverlap)
+}
+
 // customizeParameters replaces the placeholders in the manifest's parameters with the actual values
-func customizeParameters(params *rpc.Parameters, specialSecrets reservedSecrets, userSecrets map[string]manifest.Secret) (*rpc.Parameters, error) {
+//
+// trustedIntermediatesPEM contains all intermediate CAs whose Marble certificates are trusted, which differs from specialSecrets.RootCA during the overlap period of a rotation.
+// externalChainPEM is appended to the Marble's certificate chain if the Coordinator's root is issued by an external CA.
+func customizeParameters(params *rpc.Parameters, specialSecrets reservedSecrets, userSecrets map[string]manifest.Secret, trustedIntermediatesPEM string, externalChainPEM string) (*rpc.Parameters, error) {
 	customParams := rpc.Parameters{
-		Argv:  params.Argv,
-		Files: make(map[string]string),
-		Env:   make(map[string]string),
+		Argv:            params.Argv,
+		Files:           make(map[string]string),
+		Env:             make(map[string]string),
+		StructuredFiles: make(map[string]*rpc.File),
+		HostAllowlist:   params.HostAllowlist,
 	}
 
 	// Wrap the authentication secrets to have the "Marblerun" prefix in front of them when mentioned in a manifest
@@ -211,16 +404,32 @@ func customizeParameters(params *rpc.Parameters, specialSecrets reservedSecrets,
 	for path, data := range params.Files {
 		newValue, err := parseSecrets(data, secretsWrapped)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to set file %v: %v", path, err)
 		}
 
 		customParams.Files[path] = newValue
 	}
 
+	// replace placeholders in the content of structured files, the attributes are passed as is
+	for path, file := range params.StructuredFiles {
+		newValue, err := parseSecrets(string(file.Content), secretsWrapped)
+		if err != nil {
+			return nil, fmt.Errorf("failed to set file %v: %v", path, err)
+		}
+
+		customParams.StructuredFiles[path] = &rpc.File{
+			Content:   []byte(newValue),
+			Encoding:  file.Encoding,
+			Mode:      file.Mode,
+			Owner:     file.Owner,
+			Directory: file.Directory,
+		}
+	}
+
 	for name, data := range params.Env {
 		newValue, err := parseSecrets(data, secretsWrapped)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to set environment variable %v: %v", name, err)
 		}
 
 		customParams.Env[name] = newValue
@@ -240,8 +449,8 @@ func customizeParameters(params *rpc.Parameters, specialSecrets reservedSecrets,
 		return nil, err
 	}
 
-	customParams.Env[marble.MarbleEnvironmentIntermediateCA] = intermediateCaPem
-	customParams.Env[marble.MarbleEnvironmentCertificateChain] = marbleCertPem + intermediateCaPem
+	customParams.Env[marble.MarbleEnvironmentIntermediateCA] = trustedIntermediatesPEM
+	customParams.Env[marble.MarbleEnvironmentCertificateChain] = marbleCertPem + intermediateCaPem + externalChainPEM
 	customParams.Env[marble.MarbleEnvironmentPrivateKey] = encodedPrivKey
 
 	return &customParams, nil
@@ -262,7 +471,7 @@ func parseSecrets(data string, secretsWrapped secretsWrapper) (string, error) {
 	return templateResult.String(), nil
 }
 
-func (c *Core) generateMarbleAuthSecrets(req *rpc.ActivationReq, marbleUUID uuid.UUID) (reservedSecrets, error) {
+func (c *Core) generateMarbleAuthSecrets(req *rpc.ActivationReq, marbleUUID uuid.UUID, pkgName string) (reservedSecrets, error) {
 	// generate key-pair for marble
 	privk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
@@ -282,12 +491,16 @@ func (c *Core) generateMarbleAuthSecrets(req *rpc.ActivationReq, marbleUUID uuid
 	if err != nil {
 		return reservedSecrets{}, err
 	}
-	sealKey, err := util.DeriveKey(c.rootPrivK.D.Bytes(), uuidBytes, 32)
+	rootKeySeed, err := privateKeySeed(c.rootPrivK)
+	if err != nil {
+		return reservedSecrets{}, err
+	}
+	sealKey, err := util.DeriveKey(rootKeySeed, uuidBytes, 32)
 	if err != nil {
 		return reservedSecrets{}, err
 	}
 
-	certRaw, err := c.generateCertFromCSR(req.GetCSR(), privk.PublicKey, req.GetMarbleType(), marbleUUID.String())
+	certRaw, err := c.generateCertFromCSR(req.GetCSR(), &privk.PublicKey, req.GetMarbleType(), marbleUUID.String(), pkgName)
 	if err != nil {
 		return reservedSecrets{}, err
 	}
@@ -12,10 +12,13 @@ import (
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
 	"math/big"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -31,8 +34,10 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 func TestActivate(t *testing.T) {
@@ -53,7 +58,7 @@ func TestActivate(t *testing.T) {
 	issuer := quote.NewMockIssuer()
 	sealer := &MockSealer{}
 	recovery := recovery.NewSinglePartyRecovery()
-	coreServer, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, zapLogger)
+	coreServer, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
 	require.NoError(err)
 	require.NotNil(coreServer)
 
@@ -163,6 +168,15 @@ func (ms *marbleSpawner) newMarble(marbleType string, infraName string, shouldSu
 	if marble.Parameters.Files != nil {
 		ms.assert.Equal(marble.Parameters.Files, params.Files)
 	}
+	// Validate StructuredFiles
+	for path, file := range marble.Parameters.StructuredFiles {
+		ms.assert.Contains(params.StructuredFiles, path)
+		ms.assert.Equal(file.Directory, params.StructuredFiles[path].Directory)
+		ms.assert.Equal(file.Mode, params.StructuredFiles[path].Mode)
+		ms.assert.Equal(file.Encoding, params.StructuredFiles[path].Encoding)
+		_, err := params.StructuredFiles[path].DecodeContent()
+		ms.assert.NoError(err)
+	}
 	// Validate Argv
 	if marble.Parameters.Argv != nil {
 		ms.assert.Equal(marble.Parameters.Argv, params.Argv)
@@ -203,6 +217,13 @@ func (ms *marbleSpawner) newMarble(marbleType string, infraName string, shouldSu
 	// Check DNSNames for leaf certificate
 	ms.assert.Equal(cert.DNSNames, newLeafCert.DNSNames)
 	ms.assert.Equal(cert.IPAddresses, newLeafCert.IPAddresses)
+	// Check Marble identity for leaf certificate
+	identity, err := util.MarbleIdentityFromCert(newLeafCert)
+	ms.assert.NoError(err)
+	ms.assert.Equal(marbleType, identity.Type)
+	ms.assert.Equal(newLeafCert.Subject.CommonName, identity.UUID)
+	ms.assert.Equal(marble.Package, identity.Package)
+	ms.assert.Equal(hex.EncodeToString(ms.coreServer.GetManifestSignature(context.TODO())), identity.ManifestHash)
 
 	// Check Signature for both, intermediate certificate and leaf certificate
 	ms.assert.NoError(ms.coreServer.rootCert.CheckSignature(newIntermediateCert.SignatureAlgorithm, newIntermediateCert.RawTBSCertificate, newIntermediateCert.Signature))
@@ -226,6 +247,11 @@ func (ms *marbleSpawner) newMarble(marbleType string, infraName string, shouldSu
 	_, err = newLeafCert.Verify(opts)
 	ms.assert.NoError(err, "failed to verify new certificate: %v", err)
 
+	// the Coordinator's root certificate is passed for renewal
+	rootBlock, _ := pem.Decode([]byte(params.Env[util.MarbleEnvironmentCoordinatorRootCA]))
+	ms.require.NotNil(rootBlock)
+	ms.assert.Equal(ms.coreServer.rootCert.Raw, rootBlock.Bytes)
+
 	// Shared & non-shared secret checks
 	if marbleType == "backend_first" {
 		// Validate generated shared secret certificate
@@ -383,6 +409,50 @@ func TestParseSecrets(t *testing.T) {
 	// We should get an error if we try to get a non-existing secret
 	_, err = parseSecrets("{{ hex .Secrets.idontexist }}", testWrappedSecrets)
 	assert.Error(err)
+
+	// Errors name the secret and the field
+	_, err = parseSecrets("{{ pem .Secrets.mysecret.Cert }}", testWrappedSecrets)
+	require.Error(err)
+	assert.Contains(err.Error(), ".Secrets.mysecret.Cert")
+	assert.Contains(err.Error(), "no certificate")
+
+	// Test the other formats
+	parsedSecret, err = parseSecrets("{{ der .Secrets.testcertificate.Cert }}", testWrappedSecrets)
+	require.NoError(err)
+	assert.EqualValues(testCertRaw, []byte(parsedSecret))
+
+	parsedSecret, err = parseSecrets("{{ openssh .Secrets.testcertificate }}", testWrappedSecrets)
+	require.NoError(err)
+	assert.True(strings.HasPrefix(parsedSecret, "ssh-rsa "))
+
+	parsedSecret, err = parseSecrets("{{ pembundle .Secrets.testcertificate }}", testWrappedSecrets)
+	require.NoError(err)
+	p, rest := pem.Decode([]byte(parsedSecret))
+	require.NotNil(p)
+	assert.Equal("CERTIFICATE", p.Type)
+	p, _ = pem.Decode(rest)
+	require.NotNil(p)
+	assert.Equal("PRIVATE KEY", p.Type)
+
+	parsedSecret, err = parseSecrets(`{{ jwk .Secrets.testcertificate.Private }}`, testWrappedSecrets)
+	require.NoError(err)
+	assert.Contains(parsedSecret, `"kty":"RSA"`)
+
+	parsedSecret, err = parseSecrets(`{{ base64 (pkcs12 .Secrets.testcertificate "password") }}`, testWrappedSecrets)
+	require.NoError(err)
+	pfx, err := base64.StdEncoding.DecodeString(parsedSecret)
+	require.NoError(err)
+	var pfxSeq asn1.RawValue
+	_, err = asn1.Unmarshal(pfx, &pfxSeq)
+	assert.NoError(err)
+
+	parsedSecret, err = parseSecrets(`{{ jks .Secrets.testcertificate "changeit" }}`, testWrappedSecrets)
+	require.NoError(err)
+	assert.True(strings.HasPrefix(parsedSecret, "\xfe\xed\xfe\xed"))
+
+	// Bundles need a certificate
+	_, err = parseSecrets(`{{ pkcs12 .Secrets.mysecret "password" }}`, testWrappedSecrets)
+	assert.Error(err)
 }
 
 func TestSecurityLevelUpdate(t *testing.T) {
@@ -403,7 +473,7 @@ func TestSecurityLevelUpdate(t *testing.T) {
 	issuer := quote.NewMockIssuer()
 	sealer := &MockSealer{}
 	recovery := recovery.NewSinglePartyRecovery()
-	coreServer, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, zapLogger)
+	coreServer, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
 	require.NoError(err)
 	require.NotNil(coreServer)
 
@@ -430,7 +500,7 @@ func TestSecurityLevelUpdate(t *testing.T) {
 	spawner.newMarble("frontend", "Azure", false)
 
 	// Use a new core and test if updated manifest persisted after restart
-	coreServer2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, zapLogger)
+	coreServer2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
 	require.NoError(err)
 	assert.Equal(stateAcceptingMarbles, coreServer2.state)
 	assert.EqualValues(5, *coreServer2.updateManifest.Packages["frontend"].SecurityVersion)
@@ -439,3 +509,487 @@ func TestSecurityLevelUpdate(t *testing.T) {
 	spawner.coreServer = coreServer2
 	spawner.newMarble("frontend", "Azure", false)
 }
+
+// countingValidator counts the quote verifications of the wrapped MockValidator. It does not report the infrastructure properties.
+type countingValidator struct {
+	mock        *quote.MockValidator
+	validations int
+}
+
+func (v *countingValidator) Validate(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) error {
+	v.validations++
+	return v.mock.Validate(givenQuote, cert, pp, ip)
+}
+
+func (v *countingValidator) PlatformID(givenQuote []byte) ([]byte, error) {
+	return v.mock.PlatformID(givenQuote)
+}
+
+func TestActivateWithQuoteCache(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var manifest manifest.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &manifest))
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	validator := &countingValidator{mock: quote.NewMockValidator()}
+	issuer := quote.NewMockIssuer()
+	coreServer, err := NewCore([]string{"localhost"}, validator, issuer, &MockSealer{}, recovery.NewSinglePartyRecovery(), Options{QuoteCacheTTL: time.Hour}, zapLogger)
+	require.NoError(err)
+	_, err = coreServer.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+	require.NoError(err)
+
+	// activate a new instance of a Marble running on the second infrastructure
+	activate := func() error {
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		marbleQuote, err := issuer.Issue(cert.Raw)
+		require.NoError(err)
+		validator.mock.AddValidQuote(marbleQuote, cert.Raw, manifest.Packages["frontend"], manifest.Infrastructures["Alibaba"])
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		_, err = coreServer.Activate(ctx, &rpc.ActivationReq{
+			CSR:        csr,
+			MarbleType: "frontend",
+			Quote:      marbleQuote,
+			UUID:       uuid.New().String(),
+		})
+		return err
+	}
+	platformID, err := json.Marshal(manifest.Infrastructures["Alibaba"])
+	require.NoError(err)
+
+	// the infrastructure of the platform is cached
+	require.NoError(activate())
+	cached, ok := coreServer.quoteCache.get(platformID)
+	require.True(ok)
+	assert.Equal("Alibaba", cached)
+
+	// new quotes of the platform are validated against the cached infrastructure only
+	validations := validator.validations
+	require.NoError(activate())
+	assert.Equal(validations+1, validator.validations)
+
+	// expired entries are resolved again
+	coreServer.quoteCache.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, ok = coreServer.quoteCache.get(platformID)
+	assert.False(ok)
+	coreServer.quoteCache.now = time.Now
+	require.NoError(activate())
+	cached, ok = coreServer.quoteCache.get(platformID)
+	require.True(ok)
+	assert.Equal("Alibaba", cached)
+
+	// the quotes are still validated, so the outdated frontend is rejected after the manifest update
+	require.NoError(coreServer.UpdateManifest(context.TODO(), []byte(test.UpdateManifest)))
+	assert.Error(activate())
+
+	// a disabled cache does not store entries
+	disabledCache := newQuoteCache(0)
+	disabledCache.put(platformID, "Alibaba")
+	_, ok = disabledCache.get(platformID)
+	assert.False(ok)
+}
+
+func TestActivateWithCSRPolicy(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// activate returns the leaf certificate of a Marble requesting the default test names
+	activate := func(manifest manifest.Manifest) (*x509.Certificate, error) {
+		rawManifest, err := json.Marshal(manifest)
+		require.NoError(err)
+		coreServer := NewCoreWithMocks()
+		if _, err := coreServer.SetManifest(context.TODO(), rawManifest); err != nil {
+			return nil, err
+		}
+
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		marbleQuote, err := coreServer.qi.Issue(cert.Raw)
+		require.NoError(err)
+		coreServer.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, manifest.Packages["backend"], manifest.Infrastructures["Azure"])
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		resp, err := coreServer.Activate(ctx, &rpc.ActivationReq{
+			CSR:        csr,
+			MarbleType: "backend_other",
+			Quote:      marbleQuote,
+			UUID:       uuid.New().String(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode([]byte(resp.Parameters.Env[libMarble.MarbleEnvironmentCertificateChain]))
+		require.NotNil(block)
+		return x509.ParseCertificate(block.Bytes)
+	}
+
+	var testManifest manifest.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &testManifest))
+	setPolicy := func(policy *manifest.CSRPolicy) {
+		marble := testManifest.Marbles["backend_other"]
+		marble.CSRPolicy = policy
+		testManifest.Marbles["backend_other"] = marble
+	}
+
+	// all requested names are allowed
+	setPolicy(&manifest.CSRPolicy{DNSNames: []string{"localhost", "*.foobar.net", "*.example.org"}})
+	leaf, err := activate(testManifest)
+	require.NoError(err)
+	assert.Equal([]string{"localhost", "*.foobar.net", "*.example.org"}, leaf.DNSNames)
+	assert.Len(leaf.IPAddresses, 2)
+
+	// a requested name is not allowed
+	setPolicy(&manifest.CSRPolicy{DNSNames: []string{"localhost", "*.foobar.net", "service.example.org"}})
+	_, err = activate(testManifest)
+	assert.Error(err)
+
+	// disallowed names are trimmed
+	testManifest.Marbles["backend_other"].CSRPolicy.Trim = true
+	leaf, err = activate(testManifest)
+	require.NoError(err)
+	assert.Equal([]string{"localhost", "*.foobar.net"}, leaf.DNSNames)
+
+	// an invalid policy is rejected by Manifest.Check
+	setPolicy(&manifest.CSRPolicy{IPAddresses: []string{"10.0.0.0/33"}})
+	_, err = activate(testManifest)
+	assert.Error(err)
+	setPolicy(&manifest.CSRPolicy{DNSNames: []string{"service.*.example.org"}})
+	_, err = activate(testManifest)
+	assert.Error(err)
+}
+
+func TestActivateWithMultiplePackages(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var testManifest manifest.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &testManifest))
+	testManifest.Packages["backend_v2"] = quote.PackageProperties{UniqueID: "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"}
+	marble := testManifest.Marbles["backend_other"]
+	marble.Packages = []string{"backend_v2"}
+	testManifest.Marbles["backend_other"] = marble
+	rawManifest, err := json.Marshal(testManifest)
+	require.NoError(err)
+
+	coreServer := NewCoreWithMocks()
+	_, err = coreServer.SetManifest(context.TODO(), rawManifest)
+	require.NoError(err)
+
+	// activate activates a Marble whose quote matches the given package and returns the package recorded in its certificate
+	activate := func(pkg quote.PackageProperties) (string, error) {
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		marbleQuote, err := coreServer.qi.Issue(cert.Raw)
+		require.NoError(err)
+		coreServer.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, pkg, testManifest.Infrastructures["Azure"])
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		resp, err := coreServer.Activate(ctx, &rpc.ActivationReq{
+			CSR:        csr,
+			MarbleType: "backend_other",
+			Quote:      marbleQuote,
+			UUID:       uuid.New().String(),
+		})
+		if err != nil {
+			return "", err
+		}
+		block, _ := pem.Decode([]byte(resp.Parameters.Env[libMarble.MarbleEnvironmentCertificateChain]))
+		require.NotNil(block)
+		leaf, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(err)
+		identity, err := util.MarbleIdentityFromCert(leaf)
+		require.NoError(err)
+		return identity.Package, nil
+	}
+
+	pkgName, err := activate(testManifest.Packages["backend"])
+	require.NoError(err)
+	assert.Equal("backend", pkgName)
+	pkgName, err = activate(testManifest.Packages["backend_v2"])
+	require.NoError(err)
+	assert.Equal("backend_v2", pkgName)
+	pkgName, err = activate(testManifest.Packages["backend_v2"])
+	require.NoError(err)
+	assert.Equal("backend_v2", pkgName)
+	_, err = activate(testManifest.Packages["frontend"])
+	require.Error(err)
+	// the error tells why the quote was rejected for each package
+	assert.Equal(codes.Unauthenticated, status.Code(err))
+	assert.Contains(err.Error(), "package backend: invalid quote")
+	assert.Contains(err.Error(), "package backend_v2: invalid quote")
+
+	assert.Equal(uint(3), coreServer.activations["backend_other"])
+	assert.Equal(map[string]uint{"backend": 1, "backend_v2": 2}, coreServer.packageActivations["backend_other"])
+	assert.Equal(map[string]uint{"backend": 1, "backend_v2": 2}, coreServer.GetPackageActivations(context.TODO())["backend_other"])
+
+	// all referenced packages must exist
+	marble.Packages = []string{"backend_v3"}
+	testManifest.Marbles["backend_other"] = marble
+	rawManifest, err = json.Marshal(testManifest)
+	require.NoError(err)
+	_, err = NewCoreWithMocks().SetManifest(context.TODO(), rawManifest)
+	assert.Error(err)
+}
+
+func TestActivateWithConnections(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// activate returns the peers handed to a backend_other Marble
+	activate := func(testManifest manifest.Manifest) (string, error) {
+		rawManifest, err := json.Marshal(testManifest)
+		require.NoError(err)
+		coreServer := NewCoreWithMocks()
+		if _, err := coreServer.SetManifest(context.TODO(), rawManifest); err != nil {
+			return "", err
+		}
+
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		marbleQuote, err := coreServer.qi.Issue(cert.Raw)
+		require.NoError(err)
+		coreServer.qv.(*quote.MockValidator).AddValidQuote(marbleQuote, cert.Raw, testManifest.Packages["backend"], testManifest.Infrastructures["Azure"])
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		resp, err := coreServer.Activate(ctx, &rpc.ActivationReq{
+			CSR:        csr,
+			MarbleType: "backend_other",
+			Quote:      marbleQuote,
+			UUID:       uuid.New().String(),
+		})
+		require.NoError(err)
+		return resp.Parameters.Env[util.MarbleEnvironmentPeers], nil
+	}
+
+	var testManifest manifest.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &testManifest))
+
+	// no connections are declared
+	peers, err := activate(testManifest)
+	require.NoError(err)
+	assert.Empty(peers)
+
+	testManifest.Connections = []manifest.Connection{
+		{From: "frontend", To: "backend_other", Ports: []uint16{8080}},
+		{From: "frontend", To: "backend_other", Ports: []uint16{8443}},
+		{From: "backend_first", To: "backend_other"},
+		{From: "backend_other", To: "backend_first", Ports: []uint16{5432}},
+		{From: "frontend", To: "backend_first"},
+	}
+	peers, err = activate(testManifest)
+	require.NoError(err)
+	assert.JSONEq(`{"Clients":{"frontend":[8080,8443],"backend_first":[]},"Servers":{"backend_first":[5432]}}`, peers)
+
+	// connections must reference existing Marble types and valid ports
+	testManifest.Connections = []manifest.Connection{{From: "frontend", To: "database"}}
+	_, err = activate(testManifest)
+	assert.Error(err)
+	testManifest.Connections = []manifest.Connection{{From: "frontend", To: "backend_other", Ports: []uint16{0}}}
+	_, err = activate(testManifest)
+	assert.Error(err)
+}
+
+func TestActivateSealsUniqueKeys(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	validator := quote.NewMockValidator()
+	issuer := quote.NewMockIssuer()
+	sealer := &MockSealer{}
+	recovery := recovery.NewSinglePartyRecovery()
+
+	var testManifest manifest.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &testManifest))
+
+	coreServer, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
+	require.NoError(err)
+	_, err = coreServer.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+	require.NoError(err)
+
+	cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+	marbleQuote, err := issuer.Issue(cert.Raw)
+	require.NoError(err)
+	validator.AddValidQuote(marbleQuote, cert.Raw, testManifest.Packages["backend"], testManifest.Infrastructures["Azure"])
+	ctx := peer.NewContext(context.TODO(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+	})
+	marbleUUID := uuid.New()
+	_, err = coreServer.Activate(ctx, &rpc.ActivationReq{
+		CSR:        csr,
+		MarbleType: "backend_first",
+		Quote:      marbleQuote,
+		UUID:       marbleUUID.String(),
+	})
+	require.NoError(err)
+	require.Contains(coreServer.marbleKeys, marbleUUID.String())
+	privKey := coreServer.marbleKeys[marbleUUID.String()]["cert_private"]
+	require.NotNil(privKey)
+
+	// a restarted Coordinator hands out the same keys
+	restartedCore, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{}, zapLogger)
+	require.NoError(err)
+	restartedCore.mux.Lock()
+	secrets, err := restartedCore.generateSecrets(context.TODO(), restartedCore.manifest.Secrets, marbleUUID, restartedCore.intermediateCert, restartedCore.intermediatePrivK)
+	restartedCore.mux.Unlock()
+	require.NoError(err)
+	assert.Equal(privKey, []byte(secrets["cert_private"].Private))
+}
+
+func TestRenewAfterRotation(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	zapLogger, err := zap.NewDevelopment()
+	require.NoError(err)
+	defer zapLogger.Sync()
+
+	validator := quote.NewMockValidator()
+	issuer := quote.NewMockIssuer()
+	sealer := &MockSealer{}
+	recovery := recovery.NewSinglePartyRecovery()
+
+	var testManifest manifest.Manifest
+	require.NoError(json.Unmarshal([]byte(test.ManifestJSON), &testManifest))
+
+	newCore := func(overlap time.Duration) *Core {
+		coreServer, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{IntermediateOverlap: overlap}, zapLogger)
+		require.NoError(err)
+		_, err = coreServer.SetManifest(context.TODO(), []byte(test.ManifestJSON))
+		require.NoError(err)
+		return coreServer
+	}
+
+	// activate returns the Marble's certificate and private key
+	activate := func(coreServer *Core) (*x509.Certificate, interface{}) {
+		cert, csr, _ := util.MustGenerateTestMarbleCredentials()
+		marbleQuote, err := issuer.Issue(cert.Raw)
+		require.NoError(err)
+		validator.AddValidQuote(marbleQuote, cert.Raw, testManifest.Packages["backend"], testManifest.Infrastructures["Azure"])
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}},
+		})
+		resp, err := coreServer.Activate(ctx, &rpc.ActivationReq{
+			CSR:        csr,
+			MarbleType: "backend_other",
+			Quote:      marbleQuote,
+			UUID:       uuid.New().String(),
+		})
+		require.NoError(err)
+		block, _ := pem.Decode([]byte(resp.Parameters.Env[libMarble.MarbleEnvironmentCertificateChain]))
+		require.NotNil(block)
+		marbleCert, err := x509.ParseCertificate(block.Bytes)
+		require.NoError(err)
+		block, _ = pem.Decode([]byte(resp.Parameters.Env[libMarble.MarbleEnvironmentPrivateKey]))
+		require.NotNil(block)
+		marbleKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		require.NoError(err)
+		return marbleCert, marbleKey
+	}
+
+	// renewWithQuote authenticates with the Marble's certificate and the given quote and requests a new certificate for the same key
+	renewWithQuote := func(coreServer *Core, marbleCert *x509.Certificate, marbleKey interface{}, marbleQuote []byte) (*rpc.RenewResp, error) {
+		csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{"localhost"}}, marbleKey)
+		require.NoError(err)
+		ctx := peer.NewContext(context.TODO(), &peer.Peer{
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{marbleCert}}},
+		})
+		return coreServer.Renew(ctx, &rpc.RenewReq{CSR: csr, Quote: marbleQuote})
+	}
+
+	// renew uses a valid quote over the Marble's certificate
+	renew := func(coreServer *Core, marbleCert *x509.Certificate, marbleKey interface{}) (*rpc.RenewResp, error) {
+		marbleQuote, err := issuer.Issue(marbleCert.Raw)
+		require.NoError(err)
+		validator.AddValidQuote(marbleQuote, marbleCert.Raw, testManifest.Packages["backend"], testManifest.Infrastructures["Azure"])
+		return renewWithQuote(coreServer, marbleCert, marbleKey, marbleQuote)
+	}
+
+	coreServer := newCore(time.Hour)
+	marbleCert, marbleKey := activate(coreServer)
+	oldIntermediate := coreServer.intermediateCert
+
+	// only Marble certificates can be renewed
+	testCert, _, testKey := util.MustGenerateTestMarbleCredentials()
+	_, err = renew(coreServer, testCert, testKey)
+	assert.Error(err)
+
+	// the quote is verified again
+	_, err = renewWithQuote(coreServer, marbleCert, marbleKey, nil)
+	assert.Error(err)
+	invalidQuote, err := issuer.Issue(testCert.Raw)
+	require.NoError(err)
+	validator.AddValidQuote(invalidQuote, testCert.Raw, testManifest.Packages["backend"], testManifest.Infrastructures["Azure"])
+	_, err = renewWithQuote(coreServer, marbleCert, marbleKey, invalidQuote)
+	assert.Error(err)
+
+	// rotate the intermediate CA
+	assert.Nil(coreServer.GetIntermediateRotation(context.TODO()))
+	require.NoError(coreServer.RotateIntermediate(context.TODO()))
+	rotation := coreServer.GetIntermediateRotation(context.TODO())
+	require.NotNil(rotation)
+	assert.True(rotation.OverlapUntil.After(rotation.Time))
+	assert.False(oldIntermediate.Equal(coreServer.intermediateCert))
+
+	// during the overlap period, the old certificate can be renewed
+	resp, err := renew(coreServer, marbleCert, marbleKey)
+	require.NoError(err)
+	block, _ := pem.Decode([]byte(resp.CertificateChain))
+	require.NotNil(block)
+	renewedCert, err := x509.ParseCertificate(block.Bytes)
+	require.NoError(err)
+	identity, err := util.MarbleIdentityFromCert(renewedCert)
+	require.NoError(err)
+	assert.Equal("backend_other", identity.Type)
+	assert.Equal(marbleCert.Subject.CommonName, identity.UUID)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(coreServer.intermediateCert)
+	_, err = renewedCert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(err)
+
+	intermediates, err := parseCertificateChain([]byte(resp.IntermediateCA))
+	require.NoError(err)
+	assert.Len(intermediates, 2)
+
+	// the previous intermediate is sealed
+	c2, err := NewCore([]string{"localhost"}, validator, issuer, sealer, recovery, Options{IntermediateOverlap: time.Hour}, zapLogger)
+	require.NoError(err)
+	assert.True(oldIntermediate.Equal(c2.previousIntermediateCert))
+	assert.Equal(rotation.OverlapUntil.Unix(), c2.GetIntermediateRotation(context.TODO()).OverlapUntil.Unix())
+
+	// without overlap, the old certificate is not trusted anymore
+	sealer = &MockSealer{}
+	coreServer = newCore(0)
+	marbleCert, marbleKey = activate(coreServer)
+	require.NoError(coreServer.RotateIntermediate(context.TODO()))
+	_, err = renew(coreServer, marbleCert, marbleKey)
+	assert.Error(err)
+}
+
+func TestMarbleCertificateExpiry(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewCoreWithMocks()
+	now := time.Now()
+
+	// without automatic rotation, certificates do not expire
+	assert.True(c.marbleCertificateExpiry(now).After(now.AddDate(100, 0, 0)))
+
+	// with automatic rotation, certificates expire after the overlap period of the next rotation
+	c.intermediateRotationInterval = 30 * 24 * time.Hour
+	c.intermediateOverlap = 24 * time.Hour
+	assert.Equal(c.intermediateCert.NotBefore.Add(31*24*time.Hour), c.marbleCertificateExpiry(now))
+
+	// if the rotation is overdue, certificates are still valid for the overlap period
+	c.intermediateRotationInterval = time.Second
+	later := c.intermediateCert.NotBefore.Add(time.Hour)
+	assert.Equal(later.Add(24*time.Hour), c.marbleCertificateExpiry(later))
+}
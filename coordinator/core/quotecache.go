@@ -0,0 +1,73 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+type quoteCacheEntry struct {
+	infrastructure string
+	expiry         time.Time
+}
+
+// quoteCache caches the infrastructures that the quotes of a platform matched, keyed by the platform's identity.
+// All Marbles on a platform share the entry, also across restarts of the Marbles, so their quotes are validated against the matching infrastructure first.
+// A TTL of zero disables the cache.
+type quoteCache struct {
+	mutex     sync.Mutex
+	ttl       time.Duration
+	entries   map[string]quoteCacheEntry
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+func newQuoteCache(ttl time.Duration) *quoteCache {
+	return &quoteCache{
+		ttl:     ttl,
+		entries: make(map[string]quoteCacheEntry),
+		now:     time.Now,
+	}
+}
+
+// get returns the name of the infrastructure the platform's quotes matched, if the entry did not expire yet
+func (q *quoteCache) get(platformID []byte) (string, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	entry, ok := q.entries[string(platformID)]
+	if !ok {
+		return "", false
+	}
+	if !q.now().Before(entry.expiry) {
+		delete(q.entries, string(platformID))
+		return "", false
+	}
+	return entry.infrastructure, true
+}
+
+// put caches the infrastructure a quote of the platform matched
+func (q *quoteCache) put(platformID []byte, infrastructure string) {
+	if q.ttl <= 0 {
+		return
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	now := q.now()
+	q.entries[string(platformID)] = quoteCacheEntry{infrastructure: infrastructure, expiry: now.Add(q.ttl)}
+
+	// remove expired entries at most once per TTL
+	if now.Sub(q.lastPrune) < q.ttl {
+		return
+	}
+	for k, entry := range q.entries {
+		if !now.Before(entry.expiry) {
+			delete(q.entries, k)
+		}
+	}
+	q.lastPrune = now
+}
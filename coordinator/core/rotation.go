@@ -0,0 +1,100 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// IntermediateRotation describes the last rotation of the intermediate CA
+type IntermediateRotation struct {
+	// Time is the time of the rotation.
+	Time time.Time
+	// OverlapUntil is the time until which certificates issued by the previous intermediate CA are still trusted.
+	OverlapUntil time.Time
+}
+
+// rotationRetryInterval is the time to wait before retrying a scheduled rotation that could not be performed
+const rotationRetryInterval = time.Minute
+
+// rotateIntermediate replaces the intermediate CA and keeps trusting the previous one during the overlap period. The caller must hold c.mux.
+func (c *Core) rotateIntermediate() error {
+	intermediateCert, intermediatePrivK, err := generateCert(c.rootCert.DNSNames, c.intermediateProfile, c.rootCert, c.rootPrivK)
+	if err != nil {
+		c.zaplogger.Error("Could not generate a new intermediate CA for Marble authentication.", zap.Error(err))
+		return err
+	}
+
+	// Retrieve current recovery data before we seal the state again
+	currentRecoveryData, err := c.recovery.GetRecoveryData()
+	if err != nil {
+		c.zaplogger.Error("Could not retrieve the current recovery data from the recovery module. Cannot reseal the state, the intermediate CA will not be rotated.")
+		return err
+	}
+
+	now := time.Now()
+	c.previousIntermediateCert = c.intermediateCert
+	c.intermediateRotation = &IntermediateRotation{Time: now, OverlapUntil: now.Add(c.intermediateOverlap)}
+	c.intermediateCert = intermediateCert
+	c.intermediatePrivK = intermediatePrivK
+
+	c.zaplogger.Info("Rotated the intermediate CA. Marbles can renew their certificates until the overlap period ends.", zap.Time("overlapUntil", c.intermediateRotation.OverlapUntil))
+	return c.sealState(currentRecoveryData)
+}
+
+// rotateIntermediatePeriodically rotates the intermediate CA once it is older than interval until the Core is stopped
+func (c *Core) rotateIntermediatePeriodically(interval time.Duration) {
+	for {
+		c.mux.Lock()
+		wait := time.Until(c.intermediateCert.NotBefore.Add(interval))
+		if wait <= 0 {
+			wait = rotationRetryInterval
+			// Rotation only makes sense once Marbles can be activated
+			if c.state == stateAcceptingMarbles && c.rotateIntermediate() == nil {
+				wait = interval
+			}
+		}
+		c.mux.Unlock()
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// trustedIntermediates returns the intermediate CA certificates whose Marble certificates are trusted. The caller must hold c.mux.
+func (c *Core) trustedIntermediates() []*x509.Certificate {
+	intermediates := []*x509.Certificate{c.intermediateCert}
+	if c.previousIntermediateCert != nil && c.intermediateRotation != nil && time.Now().Before(c.intermediateRotation.OverlapUntil) {
+		intermediates = append(intermediates, c.previousIntermediateCert)
+	}
+	return intermediates
+}
+
+// trustedIntermediatesPEM returns the PEM-encoded intermediate CA certificates whose Marble certificates are trusted. The caller must hold c.mux.
+func (c *Core) trustedIntermediatesPEM() string {
+	var intermediatesPEM []byte
+	for _, intermediate := range c.trustedIntermediates() {
+		intermediatesPEM = append(intermediatesPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: intermediate.Raw})...)
+	}
+	return string(intermediatesPEM)
+}
+
+// verifyMarbleCertificate checks that a Marble certificate was issued by a trusted intermediate CA. The caller must hold c.mux.
+func (c *Core) verifyMarbleCertificate(cert *x509.Certificate) error {
+	roots := x509.NewCertPool()
+	for _, intermediate := range c.trustedIntermediates() {
+		roots.AddCert(intermediate)
+	}
+	_, err := cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	return err
+}
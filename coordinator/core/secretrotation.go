@@ -0,0 +1,146 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package core
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/manifest"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// SecretStatus describes the validity and rotation of a shared certificate secret
+type SecretStatus struct {
+	// NotAfter is the expiry of the current certificate.
+	NotAfter time.Time
+	// RenewAt is the time at which the certificate will be renewed. It is zero if the secret has no rotation policy.
+	RenewAt time.Time `json:",omitempty"`
+	// LastRotation is the time of the last automatic renewal. It is zero if the secret has not been renewed.
+	LastRotation time.Time `json:",omitempty"`
+}
+
+// secretExpiryWarning is the period before the expiry of a shared certificate in which a warning is logged
+const secretExpiryWarning = 30 * 24 * time.Hour
+
+var (
+	secretExpiry = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "marblerun",
+		Name:      "secret_expiry_timestamp_seconds",
+		Help:      "Expiry of shared certificate secrets as Unix time.",
+	}, []string{"secret"})
+	secretRotations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "marblerun",
+		Name:      "secret_rotations_total",
+		Help:      "Number of automatic renewals of shared certificate secrets.",
+	}, []string{"secret"})
+	secretRotationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "marblerun",
+		Name:      "secret_rotation_failures_total",
+		Help:      "Number of failed attempts to renew shared certificate secrets.",
+	})
+)
+
+// rotateSecretsPeriodically renews expiring shared certificate secrets every interval until the Core is stopped
+func (c *Core) rotateSecretsPeriodically(interval time.Duration) {
+	for {
+		c.mux.Lock()
+		// Secrets only exist once a manifest has been set
+		if c.state == stateAcceptingMarbles {
+			if err := c.rotateExpiringSecrets(time.Now()); err != nil {
+				secretRotationFailures.Inc()
+				c.zaplogger.Error("Could not renew expiring secrets.", zap.Error(err))
+			}
+		}
+		c.mux.Unlock()
+		select {
+		case <-c.stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// rotateExpiringSecrets regenerates the shared certificate secrets that are due according to their rotation policy and reseals the state.
+// Secrets without a rotation policy only cause a warning when they are about to expire. The caller must hold c.mux.
+func (c *Core) rotateExpiringSecrets(now time.Time) error {
+	secretsToRegenerate := make(map[string]manifest.Secret)
+	for name, secret := range c.secrets {
+		if !isSharedCertificate(secret) {
+			continue
+		}
+		notAfter := secret.Cert.NotAfter
+		secretExpiry.WithLabelValues(name).Set(float64(notAfter.Unix()))
+
+		policy := c.manifest.Secrets[name].Rotation
+		if policy == nil {
+			if notAfter.Sub(now) < secretExpiryWarning {
+				c.zaplogger.Warn("Shared certificate secret is about to expire and has no rotation policy. Update the manifest to renew it.", zap.String("name", name), zap.Time("notAfter", notAfter))
+			}
+			continue
+		}
+		if !now.Before(renewAt(notAfter, *policy)) {
+			// Regenerate from the manifest's definition, as the stored secret contains the expiring certificate
+			secretsToRegenerate[name] = c.manifest.Secrets[name]
+		}
+	}
+	if len(secretsToRegenerate) == 0 {
+		return nil
+	}
+
+	regeneratedSecrets, err := c.generateSecrets(context.Background(), secretsToRegenerate, uuid.Nil, c.intermediateCert, c.intermediatePrivK)
+	if err != nil {
+		return err
+	}
+
+	// Retrieve current recovery data before we seal the state again
+	currentRecoveryData, err := c.recovery.GetRecoveryData()
+	if err != nil {
+		return err
+	}
+
+	if c.secretRotations == nil {
+		c.secretRotations = make(map[string]time.Time)
+	}
+	for name, secret := range regeneratedSecrets {
+		c.secrets[name] = secret
+		c.secretRotations[name] = now
+		secretRotations.WithLabelValues(name).Inc()
+		secretExpiry.WithLabelValues(name).Set(float64(secret.Cert.NotAfter.Unix()))
+		c.zaplogger.Info("Renewed shared certificate secret. Restart Marbles using it to apply the new certificate.", zap.String("name", name), zap.Time("notAfter", secret.Cert.NotAfter))
+	}
+
+	return c.sealState(currentRecoveryData)
+}
+
+// getSecretStatus returns the status of all shared certificate secrets. The caller must hold c.mux.
+func (c *Core) getSecretStatus() map[string]SecretStatus {
+	secretStatus := make(map[string]SecretStatus)
+	for name, secret := range c.secrets {
+		if !isSharedCertificate(secret) {
+			continue
+		}
+		status := SecretStatus{NotAfter: secret.Cert.NotAfter, LastRotation: c.secretRotations[name]}
+		if policy := c.manifest.Secrets[name].Rotation; policy != nil {
+			status.RenewAt = renewAt(secret.Cert.NotAfter, *policy)
+		}
+		secretStatus[name] = status
+	}
+	return secretStatus
+}
+
+func isSharedCertificate(secret manifest.Secret) bool {
+	return secret.Shared && strings.HasPrefix(secret.Type, "cert-")
+}
+
+func renewAt(notAfter time.Time, policy manifest.RotationPolicy) time.Time {
+	return notAfter.AddDate(0, 0, -int(policy.RenewBefore))
+}
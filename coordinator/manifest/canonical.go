@@ -0,0 +1,140 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+)
+
+// Canonicalize returns the canonical form of a manifest in JSON or YAML format.
+//
+// The canonical form does not depend on the formatting of the manifest. It is compact JSON, object keys are sorted,
+// strings are escaped as by encoding/json without HTML escaping, and numbers are written without exponent if they are integers.
+// Thus, manifests that only differ in whitespace, key order or format have the same canonical form.
+func Canonicalize(rawManifest []byte) ([]byte, error) {
+	jsonManifest, err := ToJSON(rawManifest)
+	if err != nil {
+		return nil, err
+	}
+	return canonicalJSON(jsonManifest)
+}
+
+// CanonicalHash returns the SHA256 hash of the canonical form of a manifest in JSON or YAML format.
+func CanonicalHash(rawManifest []byte) ([]byte, error) {
+	canonical, err := Canonicalize(rawManifest)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(canonical)
+	return hash[:], nil
+}
+
+// canonicalJSON returns the canonical form of a JSON document.
+func canonicalJSON(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid JSON manifest: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON manifest: unexpected data after the manifest")
+	}
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteByte('{')
+		for i, key := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalString(buf, key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, elem := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, elem); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case string:
+		return writeCanonicalString(buf, v)
+	case json.Number:
+		number, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		buf.WriteString(number)
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case nil:
+		buf.WriteString("null")
+	default:
+		return fmt.Errorf("unexpected JSON value of type %T", value)
+	}
+	return nil
+}
+
+func writeCanonicalString(buf *bytes.Buffer, s string) error {
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(s); err != nil {
+		return err
+	}
+	// Remove the newline added by the encoder.
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// canonicalNumber writes integers in decimal notation and other numbers in the shortest representation.
+func canonicalNumber(n json.Number) (string, error) {
+	f, _, err := big.ParseFloat(n.String(), 10, 256, big.ToNearestEven)
+	if err != nil {
+		return "", fmt.Errorf("invalid number %v", n)
+	}
+	// Large exponents are written as float to bound the length of the result.
+	if f.IsInt() && f.MantExp(nil) <= 256 {
+		i, _ := f.Int(nil)
+		return i.String(), nil
+	}
+	float, err := n.Float64()
+	if err != nil {
+		return "", fmt.Errorf("invalid number %v", n)
+	}
+	return strconv.FormatFloat(float, 'g', -1, 64), nil
+}
@@ -0,0 +1,99 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanonicalize(t *testing.T) {
+	testCases := map[string]struct {
+		manifest  string
+		canonical string
+	}{
+		"sorted keys": {
+			manifest:  `{"b": 1, "a": {"d": [3, 2], "c": null}}`,
+			canonical: `{"a":{"c":null,"d":[3,2]},"b":1}`,
+		},
+		"whitespace": {
+			manifest:  "\n{ \"a\" :\t[ true , false ] }\n",
+			canonical: `{"a":[true,false]}`,
+		},
+		"strings are not HTML escaped": {
+			manifest:  `{"a": "<b> & ä \"c\""}`,
+			canonical: `{"a":"<b> & ä \"c\""}`,
+		},
+		"integers": {
+			manifest:  `{"a": 1.0, "b": 1e3, "c": -0, "d": 18446744073709551616}`,
+			canonical: `{"a":1,"b":1000,"c":0,"d":18446744073709551616}`,
+		},
+		"floats": {
+			manifest:  `{"a": 1.50, "b": 1e-3, "c": 1e300}`,
+			canonical: `{"a":1.5,"b":0.001,"c":1e+300}`,
+		},
+		"yaml": {
+			manifest:  "b: 1\na:\n  - x\n  - 'y'\n",
+			canonical: `{"a":["x","y"],"b":1}`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			canonical, err := Canonicalize([]byte(tc.manifest))
+			require.NoError(err)
+			assert.Equal(tc.canonical, string(canonical))
+
+			// The canonical form is a fixed point
+			again, err := Canonicalize(canonical)
+			require.NoError(err)
+			assert.Equal(canonical, again)
+		})
+	}
+}
+
+func TestCanonicalizeInvalid(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := Canonicalize([]byte(`{"a": `))
+	assert.Error(err)
+	_, err = Canonicalize([]byte(`{"a": 1} {}`))
+	assert.Error(err)
+	_, err = Canonicalize([]byte("a: [1"))
+	assert.Error(err)
+}
+
+func TestCanonicalHash(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	hash, err := CanonicalHash([]byte(test.ManifestJSON))
+	require.NoError(err)
+	canonical, err := Canonicalize([]byte(test.ManifestJSON))
+	require.NoError(err)
+	expected := sha256.Sum256(canonical)
+	assert.Equal(expected[:], hash)
+
+	// The hash does not depend on the format of the manifest
+	yamlManifest, err := ToYAML([]byte(test.ManifestJSON))
+	require.NoError(err)
+	yamlHash, err := CanonicalHash(yamlManifest)
+	require.NoError(err)
+	assert.Equal(hash, yamlHash)
+
+	// The hash depends on the content of the manifest
+	otherHash, err := CanonicalHash([]byte(test.UpdateManifest))
+	require.NoError(err)
+	assert.NotEqual(hash, otherHash)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// CSRPolicy restricts the DNS names and IP addresses a Marble may request in its CSR.
+// Loopback addresses are always allowed, because every Marble requests them by default.
+type CSRPolicy struct {
+	// DNSNames lists the allowed DNS names. A leading "*." matches exactly one label, e.g., "*.example.org" allows "a.example.org", but not "a.b.example.org".
+	DNSNames []string
+	// IPAddresses lists the allowed IP addresses and CIDR ranges, e.g., "10.0.0.1" or "10.0.0.0/8".
+	IPAddresses []string
+	// Trim removes disallowed names from the certificate instead of rejecting the activation.
+	Trim bool
+}
+
+// Check checks if the policy is well-formed
+func (p CSRPolicy) Check() error {
+	for _, pattern := range p.DNSNames {
+		name := strings.TrimPrefix(pattern, "*.")
+		if name == "" || strings.Contains(name, "*") {
+			return fmt.Errorf("invalid DNS name pattern %q, wildcards are only allowed as leftmost label", pattern)
+		}
+	}
+	for _, addr := range p.IPAddresses {
+		if _, err := parseIPNet(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply returns the DNS names and IP addresses of a CSR that are allowed by the policy.
+// If the CSR requests anything else, Apply returns an error, unless the policy trims disallowed names.
+func (p CSRPolicy) Apply(dnsNames []string, ipAddrs []net.IP) ([]string, []net.IP, error) {
+	var allowedNames, deniedNames []string
+	for _, name := range dnsNames {
+		if p.allowsDNSName(name) {
+			allowedNames = append(allowedNames, name)
+		} else {
+			deniedNames = append(deniedNames, name)
+		}
+	}
+
+	var allowedAddrs []net.IP
+	var deniedAddrs []string
+	for _, addr := range ipAddrs {
+		if p.allowsIP(addr) {
+			allowedAddrs = append(allowedAddrs, addr)
+		} else {
+			deniedAddrs = append(deniedAddrs, addr.String())
+		}
+	}
+
+	if !p.Trim && (len(deniedNames) > 0 || len(deniedAddrs) > 0) {
+		return nil, nil, fmt.Errorf("CSR requests names which are not allowed: %s", strings.Join(append(deniedNames, deniedAddrs...), ", "))
+	}
+	return allowedNames, allowedAddrs, nil
+}
+
+func (p CSRPolicy) allowsDNSName(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, pattern := range p.DNSNames {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if !strings.HasPrefix(pattern, "*.") {
+			if name == pattern {
+				return true
+			}
+			continue
+		}
+		// the wildcard matches exactly one non-empty label
+		label := strings.TrimSuffix(name, pattern[1:])
+		if label != name && label != "" && !strings.Contains(label, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p CSRPolicy) allowsIP(addr net.IP) bool {
+	if addr.IsLoopback() {
+		return true
+	}
+	for _, allowed := range p.IPAddresses {
+		ipNet, err := parseIPNet(allowed)
+		if err == nil && ipNet.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIPNet parses an IP address or CIDR range
+func parseIPNet(addr string) (*net.IPNet, error) {
+	if strings.Contains(addr, "/") {
+		_, ipNet, err := net.ParseCIDR(addr)
+		return ipNet, err
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", addr)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		bits = 8 * net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Difference describes a value that differs between two manifests.
+type Difference struct {
+	// Path is the JSON pointer of the value, e.g., "/Marbles/frontend/MaxActivations".
+	Path string
+	// Old is the value in the old manifest in canonical JSON form, or nil if it does not exist there.
+	Old json.RawMessage
+	// New is the value in the new manifest in canonical JSON form, or nil if it does not exist there.
+	New json.RawMessage
+}
+
+// Section returns the top-level field of the manifest the difference belongs to, e.g., "Marbles".
+func (d Difference) Section() string {
+	return strings.SplitN(strings.TrimPrefix(d.Path, "/"), "/", 2)[0]
+}
+
+// Diff compares two manifests in JSON or YAML format and returns their differences ordered by path.
+// Formatting is ignored, as both manifests are compared in their canonical form.
+func Diff(oldManifest, newManifest []byte) ([]Difference, error) {
+	var values [2]interface{}
+	for i, rawManifest := range [][]byte{oldManifest, newManifest} {
+		canonical, err := Canonicalize(rawManifest)
+		if err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(canonical))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values[i]); err != nil {
+			return nil, err
+		}
+	}
+	var differences []Difference
+	if err := diffValues(&differences, "", values[0], values[1], true, true); err != nil {
+		return nil, err
+	}
+	return differences, nil
+}
+
+func diffValues(differences *[]Difference, path string, oldValue, newValue interface{}, oldExists, newExists bool) error {
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldExists && newExists && oldIsMap && newIsMap {
+		keys := make(map[string]bool, len(oldMap)+len(newMap))
+		for key := range oldMap {
+			keys[key] = true
+		}
+		for key := range newMap {
+			keys[key] = true
+		}
+		for _, key := range sortedKeys(keys) {
+			oldElem, oldOk := oldMap[key]
+			newElem, newOk := newMap[key]
+			if err := diffValues(differences, path+"/"+escapePointer(key), oldElem, newElem, oldOk, newOk); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	oldSlice, oldIsSlice := oldValue.([]interface{})
+	newSlice, newIsSlice := newValue.([]interface{})
+	if oldExists && newExists && oldIsSlice && newIsSlice && len(oldSlice) == len(newSlice) {
+		for i := range oldSlice {
+			if err := diffValues(differences, path+"/"+strconv.Itoa(i), oldSlice[i], newSlice[i], true, true); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var difference Difference
+	difference.Path = path
+	if oldExists {
+		var buf bytes.Buffer
+		if err := writeCanonical(&buf, oldValue); err != nil {
+			return err
+		}
+		difference.Old = buf.Bytes()
+	}
+	if newExists {
+		var buf bytes.Buffer
+		if err := writeCanonical(&buf, newValue); err != nil {
+			return err
+		}
+		difference.New = buf.Bytes()
+	}
+	if !bytes.Equal(difference.Old, difference.New) {
+		*differences = append(*differences, difference)
+	}
+	return nil
+}
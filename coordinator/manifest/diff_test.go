@@ -0,0 +1,95 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	testCases := map[string]struct {
+		old         string
+		new         string
+		differences []Difference
+	}{
+		"equal": {
+			old: `{"Packages": {"a": {"Debug": true}}, "Marbles": {}}`,
+			new: "Marbles: {}\nPackages:\n  a:\n    Debug: true\n",
+		},
+		"changed value": {
+			old: `{"Marbles": {"a": {"MaxActivations": 1}}}`,
+			new: `{"Marbles": {"a": {"MaxActivations": 2}}}`,
+			differences: []Difference{
+				{Path: "/Marbles/a/MaxActivations", Old: json.RawMessage(`1`), New: json.RawMessage(`2`)},
+			},
+		},
+		"added and removed": {
+			old: `{"Marbles": {"a": {"Package": "p"}}, "Secrets": {"s": {"Size": 128}}}`,
+			new: `{"Marbles": {"b": {"Package": "p"}}, "Secrets": {"s": {"Size": 128}}}`,
+			differences: []Difference{
+				{Path: "/Marbles/a", Old: json.RawMessage(`{"Package":"p"}`)},
+				{Path: "/Marbles/b", New: json.RawMessage(`{"Package":"p"}`)},
+			},
+		},
+		"array element": {
+			old: `{"Clients": {"owner": [1, 2, 3]}}`,
+			new: `{"Clients": {"owner": [1, 4, 3]}}`,
+			differences: []Difference{
+				{Path: "/Clients/owner/1", Old: json.RawMessage(`2`), New: json.RawMessage(`4`)},
+			},
+		},
+		"array length": {
+			old: `{"Clients": {"owner": [1, 2]}}`,
+			new: `{"Clients": {"owner": [1, 2, 3]}}`,
+			differences: []Difference{
+				{Path: "/Clients/owner", Old: json.RawMessage(`[1,2]`), New: json.RawMessage(`[1,2,3]`)},
+			},
+		},
+		"escaped key": {
+			old: `{"Marbles": {"a": {"Parameters": {"Files": {"/tmp/a~b": "x"}}}}}`,
+			new: `{"Marbles": {"a": {"Parameters": {"Files": {"/tmp/a~b": "y"}}}}}`,
+			differences: []Difference{
+				{Path: "/Marbles/a/Parameters/Files/~1tmp~1a~0b", Old: json.RawMessage(`"x"`), New: json.RawMessage(`"y"`)},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			differences, err := Diff([]byte(tc.old), []byte(tc.new))
+			require.NoError(err)
+			assert.Equal(tc.differences, differences)
+		})
+	}
+}
+
+func TestDiffManifest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	newManifest := strings.Replace(test.ManifestJSON, `"MaxActivations": 1`, `"MaxActivations": 2`, 1)
+	newManifest = strings.Replace(newManifest, `"Size": 128`, `"Size": 256`, 1)
+	differences, err := Diff([]byte(test.ManifestJSON), []byte(newManifest))
+	require.NoError(err)
+	require.Len(differences, 2)
+	assert.Equal("/Marbles/backend_first/MaxActivations", differences[0].Path)
+	assert.Equal("Marbles", differences[0].Section())
+	assert.Equal("/Secrets/symmetric_key_shared/Size", differences[1].Path)
+	assert.Equal("Secrets", differences[1].Section())
+
+	_, err = Diff([]byte(test.ManifestJSON), []byte(`{"Marbles": `))
+	assert.Error(err)
+}
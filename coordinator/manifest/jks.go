@@ -0,0 +1,107 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"time"
+	"unicode/utf16"
+)
+
+// jksAlias is the alias of the private key entry in generated Java keystores
+const jksAlias = "marblerun"
+
+const (
+	jksMagic           = 0xFEEDFEED
+	jksVersion         = 2
+	jksPrivateKeyEntry = 1
+)
+
+// oidJavaKeyProtector identifies Sun's proprietary key protection algorithm used in JKS files
+var oidJavaKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// encodeJKS creates a Java keystore (JKS) containing the PKCS #8 encoded private key and its certificate under the alias "marblerun".
+// Both the key and the keystore are protected by the password.
+func encodeJKS(certDER []byte, privKeyDER []byte, password string, timestamp time.Time) ([]byte, error) {
+	passwordBytes := jksPassword(password)
+
+	protectedKey, err := jksProtectKey(privKeyDER, passwordBytes)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKeyInfo, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     algorithmIdentifier{Algorithm: oidJavaKeyProtector, Parameters: asn1.NullRawValue},
+		EncryptedData: protectedKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeUint32 := func(v uint32) { binary.Write(&buf, binary.BigEndian, v) }
+	writeUTF := func(s string) {
+		binary.Write(&buf, binary.BigEndian, uint16(len(s)))
+		buf.WriteString(s)
+	}
+
+	writeUint32(jksMagic)
+	writeUint32(jksVersion)
+	writeUint32(1) // number of entries
+
+	writeUint32(jksPrivateKeyEntry)
+	writeUTF(jksAlias)
+	binary.Write(&buf, binary.BigEndian, timestamp.UnixNano()/int64(time.Millisecond))
+	writeUint32(uint32(len(encryptedKeyInfo)))
+	buf.Write(encryptedKeyInfo)
+	writeUint32(1) // length of the certificate chain
+	writeUTF("X.509")
+	writeUint32(uint32(len(certDER)))
+	buf.Write(certDER)
+
+	// The keystore is authenticated by a SHA-1 digest over the password, a fixed phrase and the content
+	digest := sha1.New()
+	digest.Write(passwordBytes)
+	digest.Write([]byte("Mighty Aphrodite"))
+	digest.Write(buf.Bytes())
+	buf.Write(digest.Sum(nil))
+
+	return buf.Bytes(), nil
+}
+
+// jksProtectKey encrypts the key with a SHA-1 based key stream as done by Java's KeyProtector.
+func jksProtectKey(key []byte, passwordBytes []byte) ([]byte, error) {
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, len(key))
+	keyStream := salt
+	for i := 0; i < len(key); i += sha1.Size {
+		digest := sha1.Sum(append(append([]byte{}, passwordBytes...), keyStream...))
+		keyStream = digest[:]
+		for j := 0; j < sha1.Size && i+j < len(key); j++ {
+			encrypted[i+j] = key[i+j] ^ keyStream[j]
+		}
+	}
+
+	check := sha1.Sum(append(append([]byte{}, passwordBytes...), key...))
+	return append(append(salt, encrypted...), check[:]...), nil
+}
+
+// jksPassword encodes the password as big-endian UTF-16 as done by Java for JKS files and by PKCS #12 for BMPStrings
+func jksPassword(password string) []byte {
+	var result []byte
+	for _, r := range utf16.Encode([]rune(password)) {
+		result = append(result, byte(r>>8), byte(r))
+	}
+	return result
+}
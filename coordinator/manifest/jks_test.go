@@ -0,0 +1,131 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeJKS(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	secret := mustGenerateCertificateSecret(t)
+	encoded, err := EncodeSecretDataToJKS(secret, "passwörd")
+	require.NoError(err)
+
+	entry, err := decodeJKS([]byte(encoded), "passwörd")
+	require.NoError(err)
+	assert.Equal(jksAlias, entry.alias)
+	assert.Equal(secret.Cert.NotBefore.Truncate(time.Millisecond).Unix(), entry.timestamp.Unix())
+	assert.Equal(secret.Cert.Raw, entry.certDER)
+	assert.Equal([]byte(secret.Private), entry.keyDER)
+	_, err = x509.ParsePKCS8PrivateKey(entry.keyDER)
+	assert.NoError(err)
+
+	_, err = decodeJKS([]byte(encoded), "wrong")
+	assert.Error(err)
+
+	_, err = EncodeSecretDataToJKS(Secret{Private: secret.Private}, "")
+	assert.Equal(errNoCertificate, err)
+}
+
+type jksEntry struct {
+	alias     string
+	timestamp time.Time
+	keyDER    []byte
+	certDER   []byte
+}
+
+// decodeJKS verifies the digest of a keystore created by encodeJKS and returns its only entry with the decrypted private key.
+func decodeJKS(keystore []byte, password string) (jksEntry, error) {
+	var entry jksEntry
+	passwordBytes := jksPassword(password)
+
+	if len(keystore) < sha1.Size {
+		return entry, errUnexpectedStructure
+	}
+	content, digest := keystore[:len(keystore)-sha1.Size], keystore[len(keystore)-sha1.Size:]
+	expectedDigest := sha1.New()
+	expectedDigest.Write(passwordBytes)
+	expectedDigest.Write([]byte("Mighty Aphrodite"))
+	expectedDigest.Write(content)
+	if !bytes.Equal(expectedDigest.Sum(nil), digest) {
+		return entry, errInvalidMAC
+	}
+
+	reader := bytes.NewReader(content)
+	readUint32 := func() uint32 {
+		var v uint32
+		binary.Read(reader, binary.BigEndian, &v)
+		return v
+	}
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		io.ReadFull(reader, b)
+		return b
+	}
+	readUTF := func() string {
+		var n uint16
+		binary.Read(reader, binary.BigEndian, &n)
+		return string(readBytes(int(n)))
+	}
+
+	if readUint32() != jksMagic || readUint32() != jksVersion || readUint32() != 1 || readUint32() != jksPrivateKeyEntry {
+		return entry, errUnexpectedStructure
+	}
+	entry.alias = readUTF()
+	var millis int64
+	binary.Read(reader, binary.BigEndian, &millis)
+	entry.timestamp = time.Unix(0, millis*int64(time.Millisecond))
+
+	var keyInfo encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(readBytes(int(readUint32())), &keyInfo); err != nil {
+		return entry, err
+	}
+	if !keyInfo.Algorithm.Algorithm.Equal(oidJavaKeyProtector) {
+		return entry, errUnexpectedStructure
+	}
+	if readUint32() != 1 || readUTF() != "X.509" {
+		return entry, errUnexpectedStructure
+	}
+	entry.certDER = readBytes(int(readUint32()))
+	if reader.Len() != 0 {
+		return entry, errUnexpectedStructure
+	}
+
+	// Unprotect the key: salt || key XOR key stream || SHA-1(password || key)
+	protected := keyInfo.EncryptedData
+	if len(protected) < 2*sha1.Size {
+		return entry, errUnexpectedStructure
+	}
+	keyStream := protected[:sha1.Size]
+	encrypted := protected[sha1.Size : len(protected)-sha1.Size]
+	entry.keyDER = make([]byte, len(encrypted))
+	for i := range encrypted {
+		if i%sha1.Size == 0 {
+			digest := sha1.Sum(append(append([]byte{}, passwordBytes...), keyStream...))
+			keyStream = digest[:]
+		}
+		entry.keyDER[i] = encrypted[i] ^ keyStream[i%sha1.Size]
+	}
+	check := sha1.Sum(append(append([]byte{}, passwordBytes...), entry.keyDER...))
+	if !bytes.Equal(check[:], protected[len(protected)-sha1.Size:]) {
+		return entry, errInvalidMAC
+	}
+	return entry, nil
+}
@@ -0,0 +1,139 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// jsonWebKey is a JSON Web Key as defined in RFC 7517, RFC 7518 and RFC 8037
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+	P   string `json:"p,omitempty"`
+	Q   string `json:"q,omitempty"`
+	Dp  string `json:"dp,omitempty"`
+	Dq  string `json:"dq,omitempty"`
+	Qi  string `json:"qi,omitempty"`
+}
+
+// EncodeSecretDataToJWK encodes a private or public key to a JSON Web Key. A secret is encoded as its public key.
+func EncodeSecretDataToJWK(data interface{}) (string, error) {
+	var jwk jsonWebKey
+	var err error
+
+	switch x := data.(type) {
+	case PrivateKey:
+		if len(x) == 0 {
+			return "", errNoPrivateKey
+		}
+		var key interface{}
+		if key, err = x509.ParsePKCS8PrivateKey(x); err != nil {
+			return "", err
+		}
+		jwk, err = privateJWK(key)
+	case PublicKey:
+		if len(x) == 0 {
+			return "", errNoPublicKey
+		}
+		var key interface{}
+		if key, err = x509.ParsePKIXPublicKey(x); err != nil {
+			return "", err
+		}
+		jwk, err = publicJWK(key)
+	case Secret:
+		return EncodeSecretDataToJWK(x.Public)
+	default:
+		return "", invalidSecretDataError(data, "a secret, Public or Private")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	jwkJSON, err := json.Marshal(jwk)
+	if err != nil {
+		return "", err
+	}
+	return string(jwkJSON), nil
+}
+
+func publicJWK(key interface{}) (jsonWebKey, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{Kty: "RSA", N: encodeJWKInt(k.N, 0), E: encodeJWKInt(big.NewInt(int64(k.E)), 0)}, nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		crv := k.Curve.Params().Name
+		switch k.Curve.Params().BitSize {
+		case 256, 384, 521:
+		default:
+			return jsonWebKey{}, fmt.Errorf("JWK does not support the curve %s", k.Curve.Params().Name)
+		}
+		return jsonWebKey{Kty: "EC", Crv: crv, X: encodeJWKInt(k.X, size), Y: encodeJWKInt(k.Y, size)}, nil
+	case ed25519.PublicKey:
+		return jsonWebKey{Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(k)}, nil
+	}
+	return jsonWebKey{}, fmt.Errorf("unsupported key type %T", key)
+}
+
+func privateJWK(key interface{}) (jsonWebKey, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		jwk, err := publicJWK(&k.PublicKey)
+		if err != nil {
+			return jsonWebKey{}, err
+		}
+		if len(k.Primes) != 2 {
+			return jsonWebKey{}, errors.New("JWK encoding of multi-prime RSA keys is not supported")
+		}
+		k.Precompute()
+		jwk.D = encodeJWKInt(k.D, 0)
+		jwk.P = encodeJWKInt(k.Primes[0], 0)
+		jwk.Q = encodeJWKInt(k.Primes[1], 0)
+		jwk.Dp = encodeJWKInt(k.Precomputed.Dp, 0)
+		jwk.Dq = encodeJWKInt(k.Precomputed.Dq, 0)
+		jwk.Qi = encodeJWKInt(k.Precomputed.Qinv, 0)
+		return jwk, nil
+	case *ecdsa.PrivateKey:
+		jwk, err := publicJWK(&k.PublicKey)
+		if err != nil {
+			return jsonWebKey{}, err
+		}
+		jwk.D = encodeJWKInt(k.D, (k.Curve.Params().BitSize+7)/8)
+		return jwk, nil
+	case ed25519.PrivateKey:
+		jwk, err := publicJWK(k.Public())
+		if err != nil {
+			return jsonWebKey{}, err
+		}
+		jwk.D = base64.RawURLEncoding.EncodeToString(k.Seed())
+		return jwk, nil
+	}
+	return jsonWebKey{}, fmt.Errorf("unsupported key type %T", key)
+}
+
+// encodeJWKInt encodes an integer as base64url, left-padded with zeros to size bytes
+func encodeJWKInt(i *big.Int, size int) string {
+	b := i.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -15,11 +15,14 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"strings"
 	"text/template"
 
 	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/util"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
 )
 
 // Manifest defines the rules of a mesh.
@@ -38,60 +41,216 @@ type Manifest struct {
 	Secrets map[string]Secret
 	// RecoveryKeys holds one or multiple RSA public keys to encrypt multiple secrets, which can be used to decrypt the sealed state again in case the encryption key on disk was corrupted somehow.
 	RecoveryKeys map[string]string
+	// Connections declares which marble types may connect to which (optional).
+	// If set, each marble receives the list of its peers and the TLS configurations of package tlsconfig only accept the declared connections.
+	Connections []Connection `json:",omitempty"`
+}
+
+// Connection allows marbles of type From to connect to marbles of type To
+type Connection struct {
+	From string
+	To   string
+	// Ports restricts the connection to the given ports of the server (optional). An empty list allows any port.
+	Ports []uint16 `json:",omitempty"`
 }
 
 // Marble describes a service in the mesh that should be handled and verified by the Coordinator
 type Marble struct {
 	// Package references one of the allowed enclaves in the manifest.
 	Package string
+	// Packages references additional allowed enclaves, e.g., the new version of the enclave during a rolling upgrade (optional).
+	// A marble is accepted if its quote matches Package or any of Packages.
+	Packages []string `json:",omitempty"`
 	// MaxActivations allows to limit the number of marbles of a kind.
 	MaxActivations uint
 	// Parameters contains lists for files, environment variables and commandline arguments that should be passed to the application.
 	// Placeholder variables are supported for specific assets of the marble's activation process.
 	Parameters *rpc.Parameters
+	// CSRPolicy restricts the DNS names and IP addresses the marble may request for its certificate (optional).
+	CSRPolicy *CSRPolicy
 }
 
 // Check checks if the manifest is consistent.
 func (m Manifest) Check(ctx context.Context, zaplogger *zap.Logger) error {
+	if problems := m.CheckAll(ctx, zaplogger); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// CheckAll checks if the manifest is consistent and returns every problem found.
+func (m Manifest) CheckAll(ctx context.Context, zaplogger *zap.Logger) []error {
+	var problems []error
 	if len(m.Packages) <= 0 {
-		return errors.New("no allowed packages defined")
+		problems = append(problems, errors.New("no allowed packages defined"))
 	}
 	if len(m.Marbles) <= 0 {
-		return errors.New("no allowed marbles defined")
+		problems = append(problems, errors.New("no allowed marbles defined"))
 	}
 	// if len(m.Infrastructures) <= 0 {
 	// 	return errors.New("no allowed infrastructures defined")
 	// }
-	for _, marble := range m.Marbles {
-		singlePackage, ok := m.Packages[marble.Package]
-		if !ok {
-			return errors.New("manifest does not contain marble package " + marble.Package)
-		}
-		// Check if package specifies either UniqueID, or values for all, SignerID, ProductID & Security version
-		// Debug mode bypasses this requirement and throws a warning instead
-		if singlePackage.UniqueID != "" && (singlePackage.SignerID != "" || singlePackage.ProductID != nil || singlePackage.SecurityVersion != nil) {
-			if singlePackage.Debug {
-				zaplogger.Warn("Manifest specifies UniqueID *and* SignerID/ProductID/SecurityVersion. This is not accepted in non-debug mode, please check your configuration.", zap.String("packageName", marble.Package))
-			} else {
-				return fmt.Errorf("manifest specfies both UniqueID *and* SignerID/ProductID/SecurityVersion in package %s", marble.Package)
+	for _, name := range sortedKeys(m.Secrets) {
+		secret := m.Secrets[name]
+		if err := secret.check(); err != nil {
+			problems = append(problems, fmt.Errorf("manifest specifies an invalid secret %s: %v", name, err))
+		}
+		if secret.Rotation == nil {
+			continue
+		}
+		if err := secret.Rotation.check(secret); err != nil {
+			problems = append(problems, fmt.Errorf("manifest specifies an invalid rotation policy for secret %s: %v", name, err))
+		}
+	}
+	for i, connection := range m.Connections {
+		if err := connection.check(m.Marbles); err != nil {
+			problems = append(problems, fmt.Errorf("manifest specifies an invalid connection %d: %v", i, err))
+		}
+	}
+	for _, name := range sortedKeys(m.Marbles) {
+		marble := m.Marbles[name]
+		if marble.CSRPolicy != nil {
+			if err := marble.CSRPolicy.Check(); err != nil {
+				problems = append(problems, fmt.Errorf("manifest specifies an invalid CSR policy for marble %s: %v", name, err))
 			}
-		} else if singlePackage.UniqueID == "" {
-			if singlePackage.SignerID == "" {
-				if err := warnOrFailForMissingValue(singlePackage.Debug, "SignerID", marble.Package, zaplogger); err != nil {
-					return err
-				}
+		}
+		if marble.Parameters != nil {
+			if err := checkTemplates(marble.Parameters.Files, "file", m.Secrets, false); err != nil {
+				problems = append(problems, fmt.Errorf("manifest specifies invalid parameters for marble %s: %v", name, err))
 			}
-			if singlePackage.ProductID == nil {
-				if err := warnOrFailForMissingValue(singlePackage.Debug, "ProductID", marble.Package, zaplogger); err != nil {
-					return err
-				}
+			if err := checkTemplates(marble.Parameters.Env, "environment variable", m.Secrets, false); err != nil {
+				problems = append(problems, fmt.Errorf("manifest specifies invalid parameters for marble %s: %v", name, err))
 			}
-			if singlePackage.SecurityVersion == nil {
-				if err := warnOrFailForMissingValue(singlePackage.Debug, "SecurityVersion", marble.Package, zaplogger); err != nil {
-					return err
+			if err := checkStructuredFiles(marble.Parameters, m.Secrets); err != nil {
+				problems = append(problems, fmt.Errorf("manifest specifies invalid parameters for marble %s: %v", name, err))
+			}
+			if allowlist := marble.Parameters.HostAllowlist; allowlist != nil {
+				if err := allowlist.Check(); err != nil {
+					problems = append(problems, fmt.Errorf("manifest specifies an invalid host allowlist for marble %s: %v", name, err))
 				}
 			}
 		}
+		allowedPackages := marble.AllowedPackages()
+		if len(allowedPackages) == 0 {
+			problems = append(problems, fmt.Errorf("manifest specifies no package for marble %s", name))
+		}
+		for _, packageName := range allowedPackages {
+			singlePackage, ok := m.Packages[packageName]
+			if !ok {
+				problems = append(problems, errors.New("manifest does not contain marble package "+packageName))
+				continue
+			}
+			if err := checkPackage(singlePackage, packageName, zaplogger); err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+	return problems
+}
+
+// AllowedPackages returns the names of the packages the marble may run as: Package followed by Packages.
+func (m Marble) AllowedPackages() []string {
+	var allowed []string
+	seen := make(map[string]bool)
+	for _, name := range append([]string{m.Package}, m.Packages...) {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		allowed = append(allowed, name)
+	}
+	return allowed
+}
+
+// check checks if the connection references existing marble types and valid ports.
+func (c Connection) check(marbles map[string]Marble) error {
+	for _, marbleType := range []string{c.From, c.To} {
+		if _, ok := marbles[marbleType]; !ok {
+			return fmt.Errorf("unknown marble type %q", marbleType)
+		}
+	}
+	for _, port := range c.Ports {
+		if port == 0 {
+			return errors.New("port 0 is not allowed")
+		}
+	}
+	return nil
+}
+
+// MarblePeers returns the peers the Connections allow a marble of the given type to communicate with.
+// Returns nil if the manifest does not declare any connections.
+func (m Manifest) MarblePeers(marbleType string) *util.MarblePeers {
+	if len(m.Connections) == 0 {
+		return nil
+	}
+	peers := &util.MarblePeers{Clients: make(map[string][]uint16), Servers: make(map[string][]uint16)}
+	for _, connection := range m.Connections {
+		if connection.To == marbleType {
+			addPeer(peers.Clients, connection.From, connection.Ports)
+		}
+		if connection.From == marbleType {
+			addPeer(peers.Servers, connection.To, connection.Ports)
+		}
+	}
+	return peers
+}
+
+// addPeer merges the ports of a connection into the allowed ports of a peer. An empty list allows any port.
+func addPeer(peers map[string][]uint16, marbleType string, ports []uint16) {
+	allowed, ok := peers[marbleType]
+	switch {
+	case !ok:
+		peers[marbleType] = append([]uint16{}, ports...)
+	case len(allowed) == 0:
+		// any port is already allowed
+	case len(ports) == 0:
+		peers[marbleType] = []uint16{}
+	default:
+		for _, port := range ports {
+			if !containsPort(allowed, port) {
+				allowed = append(allowed, port)
+			}
+		}
+		peers[marbleType] = allowed
+	}
+}
+
+func containsPort(ports []uint16, port uint16) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPackage checks if a package referenced by a marble specifies either UniqueID, or values for all, SignerID, ProductID & Security version.
+// Debug mode bypasses this requirement and throws a warning instead.
+func checkPackage(singlePackage quote.PackageProperties, packageName string, zaplogger *zap.Logger) error {
+	if singlePackage.UniqueID != "" && (singlePackage.SignerID != "" || singlePackage.ProductID != nil || singlePackage.SecurityVersion != nil) {
+		if singlePackage.Debug {
+			zaplogger.Warn("Manifest specifies UniqueID *and* SignerID/ProductID/SecurityVersion. This is not accepted in non-debug mode, please check your configuration.", zap.String("packageName", packageName))
+			return nil
+		}
+		return fmt.Errorf("manifest specfies both UniqueID *and* SignerID/ProductID/SecurityVersion in package %s", packageName)
+	}
+	if singlePackage.UniqueID != "" {
+		return nil
+	}
+	if singlePackage.SignerID == "" {
+		if err := warnOrFailForMissingValue(singlePackage.Debug, "SignerID", packageName, zaplogger); err != nil {
+			return err
+		}
+	}
+	if singlePackage.ProductID == nil {
+		if err := warnOrFailForMissingValue(singlePackage.Debug, "ProductID", packageName, zaplogger); err != nil {
+			return err
+		}
+	}
+	if singlePackage.SecurityVersion == nil {
+		if err := warnOrFailForMissingValue(singlePackage.Debug, "SecurityVersion", packageName, zaplogger); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -109,10 +268,71 @@ type Secret struct {
 	Shared   bool
 	Cert     Certificate
 	ValidFor uint
+	// Rotation enables the automatic renewal of a shared certificate before it expires (optional).
+	Rotation *RotationPolicy `json:",omitempty"`
 	Private  PrivateKey
 	Public   PublicKey
 }
 
+// check checks if the size of the secret is supported by its type.
+func (s Secret) check() error {
+	switch s.Type {
+	case "symmetric-key":
+		if s.Size == 0 || s.Size%8 != 0 {
+			return fmt.Errorf("symmetric keys need a size that is a multiple of 8 bits, given: %d", s.Size)
+		}
+	case "rsa":
+		if s.Size < 2048 {
+			return fmt.Errorf("RSA keys must have a size of at least 2048 bits, given: %d", s.Size)
+		}
+	case "cert-rsa":
+		// certificates of existing manifests may use smaller keys
+		if s.Size < 1024 {
+			return fmt.Errorf("RSA certificates must have a key size of at least 1024 bits, given: %d", s.Size)
+		}
+	case "ed25519", "cert-ed25519":
+		if s.Size != 0 {
+			return fmt.Errorf("ed25519 keys have a fixed size, none is expected. given: %d", s.Size)
+		}
+	case "ecdsa", "cert-ecdsa":
+		switch s.Size {
+		case 224, 256, 384, 521:
+		default:
+			return fmt.Errorf("ECDSA keys only support P224, P256, P384 and P521 as curve, given size: %d", s.Size)
+		}
+	default:
+		return fmt.Errorf("unsupported secret type %s", s.Type)
+	}
+	return nil
+}
+
+// RotationPolicy defines when the Coordinator renews a shared certificate secret
+type RotationPolicy struct {
+	// RenewBefore is the number of days before its expiry at which the certificate is renewed.
+	RenewBefore uint
+}
+
+// check checks if the policy can be applied to the secret.
+func (p RotationPolicy) check(secret Secret) error {
+	if !secret.Shared || !strings.HasPrefix(secret.Type, "cert-") {
+		return errors.New("rotation is only supported for shared certificates")
+	}
+	if !secret.Cert.NotAfter.IsZero() {
+		return errors.New("rotation requires the validity to be given by ValidFor instead of a fixed NotAfter")
+	}
+	validFor := secret.ValidFor
+	if validFor == 0 {
+		validFor = DefaultValidFor
+	}
+	if p.RenewBefore == 0 || p.RenewBefore >= validFor {
+		return fmt.Errorf("RenewBefore must be between 1 and %d days", validFor-1)
+	}
+	return nil
+}
+
+// DefaultValidFor is the validity of certificate secrets in days, if neither ValidFor nor NotAfter is specified
+const DefaultValidFor = 365
+
 // Certificate is an x509.Certificate
 type Certificate x509.Certificate
 
@@ -150,13 +370,22 @@ func EncodeSecretDataToPem(data interface{}) (string, error) {
 
 	switch x := data.(type) {
 	case Certificate:
+		if len(x.Raw) == 0 {
+			return "", errNoCertificate
+		}
 		pemData = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: x.Raw})
 	case PublicKey:
+		if len(x) == 0 {
+			return "", errNoPublicKey
+		}
 		pemData = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: x})
 	case PrivateKey:
+		if len(x) == 0 {
+			return "", errNoPrivateKey
+		}
 		pemData = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: x})
 	default:
-		return "", errors.New("invalid secret type")
+		return "", invalidSecretDataError(data, "Cert, Public or Private")
 	}
 
 	return string(pemData), nil
@@ -176,6 +405,8 @@ func EncodeSecretDataToRaw(data interface{}) (string, error) {
 	switch secret := data.(type) {
 	case []byte:
 		return string(secret), nil
+	case string:
+		return secret, nil
 	case PrivateKey:
 		return string(secret), nil
 	case PublicKey:
@@ -185,7 +416,7 @@ func EncodeSecretDataToRaw(data interface{}) (string, error) {
 	case Certificate:
 		return string(secret.Raw), nil
 	default:
-		return "", errors.New("invalid secret type")
+		return "", invalidSecretDataError(data, "a secret or one of its fields")
 	}
 }
 
@@ -198,12 +429,140 @@ func EncodeSecretDataToBase64(data interface{}) (string, error) {
 	return base64.StdEncoding.EncodeToString([]byte(raw)), nil
 }
 
+// EncodeSecretDataToDer encodes a certificate or key to its binary DER encoding
+func EncodeSecretDataToDer(data interface{}) (string, error) {
+	switch x := data.(type) {
+	case Certificate:
+		if len(x.Raw) == 0 {
+			return "", errNoCertificate
+		}
+		return string(x.Raw), nil
+	case PublicKey:
+		if len(x) == 0 {
+			return "", errNoPublicKey
+		}
+		return string(x), nil
+	case PrivateKey:
+		if len(x) == 0 {
+			return "", errNoPrivateKey
+		}
+		return string(x), nil
+	}
+	return "", invalidSecretDataError(data, "Cert, Public or Private")
+}
+
+// EncodeSecretDataToOpenSSH encodes the public key of a secret in the OpenSSH authorized_keys format
+func EncodeSecretDataToOpenSSH(data interface{}) (string, error) {
+	var pubKey interface{}
+	var err error
+
+	switch x := data.(type) {
+	case Secret:
+		return EncodeSecretDataToOpenSSH(x.Public)
+	case Certificate:
+		if len(x.Raw) == 0 {
+			return "", errNoCertificate
+		}
+		pubKey = x.PublicKey
+	case PublicKey:
+		if len(x) == 0 {
+			return "", errNoPublicKey
+		}
+		if pubKey, err = x509.ParsePKIXPublicKey(x); err != nil {
+			return "", err
+		}
+	default:
+		return "", invalidSecretDataError(data, "a secret, Cert or Public")
+	}
+
+	sshPubKey, err := ssh.NewPublicKey(pubKey)
+	if err != nil {
+		return "", err
+	}
+	return string(ssh.MarshalAuthorizedKey(sshPubKey)), nil
+}
+
+// EncodeSecretDataToPemBundle encodes the certificate and the private key of a secret to a single PEM file
+func EncodeSecretDataToPemBundle(data interface{}) (string, error) {
+	secret, err := certificateSecret(data)
+	if err != nil {
+		return "", err
+	}
+	certPem, err := EncodeSecretDataToPem(secret.Cert)
+	if err != nil {
+		return "", err
+	}
+	keyPem, err := EncodeSecretDataToPem(secret.Private)
+	if err != nil {
+		return "", err
+	}
+	return certPem + keyPem, nil
+}
+
+// EncodeSecretDataToPKCS12 encodes the certificate and the private key of a secret to a PKCS #12 file protected by the password
+func EncodeSecretDataToPKCS12(data interface{}, password string) (string, error) {
+	secret, err := certificateSecret(data)
+	if err != nil {
+		return "", err
+	}
+	pfx, err := encodePKCS12(secret.Cert.Raw, secret.Private, password)
+	if err != nil {
+		return "", err
+	}
+	return string(pfx), nil
+}
+
+// EncodeSecretDataToJKS encodes the certificate and the private key of a secret to a Java keystore protected by the password.
+// The key is stored under the alias "marblerun".
+func EncodeSecretDataToJKS(data interface{}, password string) (string, error) {
+	secret, err := certificateSecret(data)
+	if err != nil {
+		return "", err
+	}
+	jks, err := encodeJKS(secret.Cert.Raw, secret.Private, password, secret.Cert.NotBefore)
+	if err != nil {
+		return "", err
+	}
+	return string(jks), nil
+}
+
+var (
+	errNoCertificate = errors.New("the secret has no certificate")
+	errNoPublicKey   = errors.New("the secret has no public key")
+	errNoPrivateKey  = errors.New("the secret has no private key")
+)
+
+// certificateSecret returns data as a secret that has a certificate and a private key
+func certificateSecret(data interface{}) (Secret, error) {
+	secret, ok := data.(Secret)
+	if !ok {
+		return Secret{}, invalidSecretDataError(data, "a secret with a certificate")
+	}
+	if len(secret.Cert.Raw) == 0 {
+		return Secret{}, errNoCertificate
+	}
+	if len(secret.Private) == 0 {
+		return Secret{}, errNoPrivateKey
+	}
+	return secret, nil
+}
+
+func invalidSecretDataError(data interface{}, expected string) error {
+	return fmt.Errorf("invalid secret data of type %T, expected %s", data, expected)
+}
+
 // ManifestTemplateFuncMap defines the functions which can be specified for secrets in the in go template format
 var ManifestTemplateFuncMap = template.FuncMap{
-	"pem":    EncodeSecretDataToPem,
-	"hex":    EncodeSecretDataToHex,
-	"raw":    EncodeSecretDataToRaw,
-	"base64": EncodeSecretDataToBase64,
+	"pem":       EncodeSecretDataToPem,
+	"hex":       EncodeSecretDataToHex,
+	"raw":       EncodeSecretDataToRaw,
+	"base64":    EncodeSecretDataToBase64,
+	"jwk":       EncodeSecretDataToJWK,
+	"der":       EncodeSecretDataToDer,
+	"openssh":   EncodeSecretDataToOpenSSH,
+	"pembundle": EncodeSecretDataToPemBundle,
+	"pkcs12":    EncodeSecretDataToPKCS12,
+	"jks":       EncodeSecretDataToJKS,
 }
 
 // CheckUpdate checks if the manifest is consistent and only contains supported values.
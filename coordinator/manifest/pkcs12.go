@@ -0,0 +1,254 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/asn1"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PKCS #12 files are encrypted with PBES2 (PBKDF2 with HMAC-SHA256 and AES-256-CBC) and authenticated with HMAC-SHA256, like OpenSSL 3 does by default.
+const pkcs12Iterations = 10000
+
+var (
+	oidData                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidLocalKeyID          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBES2               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES256CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA256              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue // [0] EXPLICIT, see explicitTag
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm algorithmIdentifier
+	Digest    []byte
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     // [0] EXPLICIT, see explicitTag
+	Attributes []pkcs12Attribute `asn1:"set"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     algorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc algorithmIdentifier
+	EncryptionScheme  algorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	PRF        algorithmIdentifier
+}
+
+// encodePKCS12 creates a password-protected PKCS #12 file containing the certificate and the PKCS #8 encoded private key.
+func encodePKCS12(certDER []byte, privKeyDER []byte, password string) ([]byte, error) {
+	localKeyID := sha1.Sum(certDER)
+	attributes, err := localKeyIDAttributes(localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Certificate bag
+	certBagValue, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: certDER})
+	if err != nil {
+		return nil, err
+	}
+	certSafeContents, err := marshalSafeContents(safeBag{ID: oidCertBag, Value: explicitTag(certBagValue), Attributes: attributes})
+	if err != nil {
+		return nil, err
+	}
+
+	// Shrouded key bag
+	encryptedKey, err := encryptPBES2(privKeyDER, password)
+	if err != nil {
+		return nil, err
+	}
+	keySafeContents, err := marshalSafeContents(safeBag{ID: oidPKCS8ShroudedKeyBag, Value: explicitTag(encryptedKey), Attributes: attributes})
+	if err != nil {
+		return nil, err
+	}
+
+	authenticatedSafe, err := asn1.Marshal([]contentInfo{dataContentInfo(certSafeContents), dataContentInfo(keySafeContents)})
+	if err != nil {
+		return nil, err
+	}
+
+	// MAC over the authenticated safe
+	macSalt := make([]byte, 16)
+	if _, err := rand.Read(macSalt); err != nil {
+		return nil, err
+	}
+	macKey := pkcs12MacKey(password, macSalt, pkcs12Iterations)
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(authenticatedSafe)
+
+	return asn1.Marshal(pfxPdu{
+		Version:  3,
+		AuthSafe: dataContentInfo(authenticatedSafe),
+		MacData: macData{
+			Mac:        digestInfo{Algorithm: algorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}, Digest: mac.Sum(nil)},
+			MacSalt:    macSalt,
+			Iterations: pkcs12Iterations,
+		},
+	})
+}
+
+func dataContentInfo(data []byte) contentInfo {
+	octets, _ := asn1.Marshal(data)
+	return contentInfo{ContentType: oidData, Content: explicitTag(octets)}
+}
+
+// explicitTag wraps DER-encoded data in an explicit [0] tag. encoding/asn1 ignores the explicit tag of RawValue fields, so it must be added manually.
+func explicitTag(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
+
+func marshalSafeContents(bags ...safeBag) ([]byte, error) {
+	return asn1.Marshal(bags)
+}
+
+func localKeyIDAttributes(localKeyID []byte) ([]pkcs12Attribute, error) {
+	value, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return []pkcs12Attribute{{ID: oidLocalKeyID, Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value}}}, nil
+}
+
+// encryptPBES2 encrypts the data with the password and returns the DER-encoded EncryptedPrivateKeyInfo.
+func encryptPBES2(data []byte, password string) ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	key := pbkdf2.Key([]byte(password), salt, pkcs12Iterations, 32, sha256.New)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	// PKCS #7 padding
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	encrypted := append(append([]byte{}, data...), make([]byte, padding)...)
+	for i := len(data); i < len(encrypted); i++ {
+		encrypted[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: pkcs12Iterations,
+		PRF:        algorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: algorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  algorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     algorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: encrypted,
+	})
+}
+
+// pkcs12MacKey derives the MAC key with the key derivation function of RFC 7292, Appendix B, using SHA-256.
+func pkcs12MacKey(password string, salt []byte, iterations int) []byte {
+	const v = 64 // block size of SHA-256
+	const id = 3 // MAC key
+
+	// The password is a BMPString with a terminating null character
+	bmpPassword := jksPassword(password + "\x00")
+
+	input := make([]byte, v)
+	for i := range input {
+		input[i] = id
+	}
+	input = append(input, fill(salt, v)...)
+	input = append(input, fill(bmpPassword, v)...)
+
+	// The key has the size of a single hash, so a single round suffices
+	digest := sha256.Sum256(input)
+	for i := 1; i < iterations; i++ {
+		digest = sha256.Sum256(digest[:])
+	}
+	return digest[:]
+}
+
+// fill repeats data to the next multiple of v bytes
+func fill(data []byte, v int) []byte {
+	if len(data) == 0 {
+		return nil
+	}
+	result := make([]byte, v*((len(data)+v-1)/v))
+	for i := range result {
+		result[i] = data[i%len(data)]
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	errUnexpectedStructure = errors.New("unexpected structure")
+	errInvalidMAC          = errors.New("invalid MAC")
+)
+
+func TestEncodePKCS12(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	secret := mustGenerateCertificateSecret(t)
+	encoded, err := EncodeSecretDataToPKCS12(secret, "passwörd")
+	require.NoError(err)
+
+	certDER, keyDER, err := decodePKCS12([]byte(encoded), "passwörd")
+	require.NoError(err)
+	assert.Equal(secret.Cert.Raw, certDER)
+	assert.Equal([]byte(secret.Private), keyDER)
+	_, err = x509.ParsePKCS8PrivateKey(keyDER)
+	assert.NoError(err)
+
+	_, _, err = decodePKCS12([]byte(encoded), "wrong")
+	assert.Error(err)
+
+	_, err = EncodeSecretDataToPKCS12(Secret{Private: secret.Private}, "")
+	assert.Equal(errNoCertificate, err)
+	_, err = EncodeSecretDataToPKCS12(Secret{Cert: secret.Cert}, "")
+	assert.Equal(errNoPrivateKey, err)
+}
+
+// decodePKCS12 verifies the MAC of a PKCS #12 file created by encodePKCS12 and returns the certificate and the decrypted private key.
+func decodePKCS12(pfx []byte, password string) (certDER []byte, keyDER []byte, err error) {
+	var pdu pfxPdu
+	if _, err := asn1.Unmarshal(pfx, &pdu); err != nil {
+		return nil, nil, err
+	}
+	if pdu.Version != 3 || !pdu.AuthSafe.ContentType.Equal(oidData) || !pdu.MacData.Mac.Algorithm.Algorithm.Equal(oidSHA256) {
+		return nil, nil, errUnexpectedStructure
+	}
+	var authenticatedSafe []byte
+	if _, err := asn1.Unmarshal(pdu.AuthSafe.Content.Bytes, &authenticatedSafe); err != nil {
+		return nil, nil, err
+	}
+	mac := hmac.New(sha256.New, pkcs12MacKey(password, pdu.MacData.MacSalt, pdu.MacData.Iterations))
+	mac.Write(authenticatedSafe)
+	if !hmac.Equal(mac.Sum(nil), pdu.MacData.Mac.Digest) {
+		return nil, nil, errInvalidMAC
+	}
+
+	var safes []contentInfo
+	if _, err := asn1.Unmarshal(authenticatedSafe, &safes); err != nil {
+		return nil, nil, err
+	}
+	for _, safe := range safes {
+		var safeContents []byte
+		if _, err := asn1.Unmarshal(safe.Content.Bytes, &safeContents); err != nil {
+			return nil, nil, err
+		}
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(safeContents, &bags); err != nil {
+			return nil, nil, err
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var cert certBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cert); err != nil {
+					return nil, nil, err
+				}
+				certDER = cert.Data
+			case bag.ID.Equal(oidPKCS8ShroudedKeyBag):
+				if keyDER, err = decryptPBES2(bag.Value.Bytes, password); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+	return certDER, keyDER, nil
+}
+
+func decryptPBES2(data []byte, password string) ([]byte, error) {
+	var keyInfo encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(data, &keyInfo); err != nil {
+		return nil, err
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(keyInfo.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, err
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	if !keyInfo.Algorithm.Algorithm.Equal(oidPBES2) || !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) ||
+		!kdfParams.PRF.Algorithm.Equal(oidHMACWithSHA256) || !params.EncryptionScheme.Algorithm.Equal(oidAES256CBC) {
+		return nil, errUnexpectedStructure
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key([]byte(password), kdfParams.Salt, kdfParams.Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make([]byte, len(keyInfo.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, keyInfo.EncryptedData)
+	padding := int(decrypted[len(decrypted)-1])
+	if padding < 1 || padding > aes.BlockSize {
+		return nil, errUnexpectedStructure
+	}
+	return decrypted[:len(decrypted)-padding], nil
+}
+
+func mustGenerateCertificateSecret(t *testing.T) Secret {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Marblerun Unit Test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &privKey.PublicKey, privKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(certDER)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privKey)
+	require.NoError(t, err)
+	return Secret{Type: "cert-ecdsa", Cert: Certificate(*cert), Private: keyDER}
+}
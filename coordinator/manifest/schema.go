@@ -0,0 +1,418 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+)
+
+// schemaVersion is the JSON Schema dialect of the generated schema.
+const schemaVersion = "http://json-schema.org/draft-07/schema#"
+
+// jsonSchema is the subset of JSON Schema needed to describe a manifest.
+// AdditionalProperties is either false or a *jsonSchema.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Description          string                 `json:"description,omitempty"`
+	Type                 schemaType             `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	ContentEncoding      string                 `json:"contentEncoding,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+	Minimum              json.Number            `json:"minimum,omitempty"`
+	Maximum              json.Number            `json:"maximum,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties interface{}            `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	OneOf                []*jsonSchema          `json:"oneOf,omitempty"`
+}
+
+// schemaType lists the JSON types a value may have. It is marshalled as a single string if it has one element.
+type schemaType []string
+
+// MarshalJSON implements the json.Marshaler interface.
+func (t schemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	bigIntType          = reflect.TypeOf(big.Int{})
+	certificateType     = reflect.TypeOf(Certificate{})
+	fileType            = reflect.TypeOf(rpc.File{})
+	timeType            = reflect.TypeOf(time.Time{})
+)
+
+// Schema returns the JSON Schema of the manifest.
+func Schema() ([]byte, error) {
+	schema := schemaFor(reflect.TypeOf(Manifest{}), nil)
+	schema.Schema = schemaVersion
+	schema.Title = "Marblerun manifest"
+	return json.MarshalIndent(schema, "", "  ")
+}
+
+// schemaFor derives the schema of a type from the way encoding/json decodes it.
+// visiting contains the struct types on the current path to break cycles.
+func schemaFor(t reflect.Type, visiting []reflect.Type) *jsonSchema {
+	// Types with custom decoding are described explicitly.
+	switch t {
+	case bigIntType:
+		return &jsonSchema{Type: schemaType{"integer"}}
+	case timeType:
+		return &jsonSchema{Type: schemaType{"string"}, Format: "date-time"}
+	case certificateType:
+		return &jsonSchema{
+			Description: "Certificate template as object or DER certificate as base64 string",
+			OneOf: []*jsonSchema{
+				{Type: schemaType{"null"}},
+				{Type: schemaType{"string"}, ContentEncoding: "base64"},
+				schemaFor(reflect.TypeOf(x509.Certificate{}), visiting),
+			},
+		}
+	case fileType:
+		return &jsonSchema{
+			Type: schemaType{"object"},
+			Properties: map[string]*jsonSchema{
+				"Content":   {Type: schemaType{"string"}},
+				"Encoding":  {Type: schemaType{"string"}, Pattern: "^(|" + rpc.FileEncodingBase64 + "|" + rpc.FileEncodingHex + ")$"},
+				"Mode":      {Type: schemaType{"string"}, Pattern: "^[0-7]{1,4}$"},
+				"Owner":     {Type: schemaType{"string"}, Pattern: "^([0-9]+:[0-9]+)?$"},
+				"Directory": {Type: schemaType{"boolean"}},
+			},
+			AdditionalProperties: false,
+		}
+	}
+	if t.Kind() != reflect.Ptr && t.Kind() != reflect.Interface {
+		ptr := reflect.PtrTo(t)
+		if ptr.Implements(jsonUnmarshalerType) {
+			return &jsonSchema{}
+		}
+		if ptr.Implements(textUnmarshalerType) {
+			return &jsonSchema{Type: schemaType{"string"}}
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return nullable(schemaFor(t.Elem(), visiting))
+	case reflect.Bool:
+		return &jsonSchema{Type: schemaType{"boolean"}}
+	case reflect.String:
+		return &jsonSchema{Type: schemaType{"string"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := uint(t.Bits() - 1)
+		min := new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), bits))
+		max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), bits), big.NewInt(1))
+		return &jsonSchema{Type: schemaType{"integer"}, Minimum: json.Number(min.String()), Maximum: json.Number(max.String())}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(t.Bits())), big.NewInt(1))
+		return &jsonSchema{Type: schemaType{"integer"}, Minimum: "0", Maximum: json.Number(max.String())}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: schemaType{"number"}}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && !reflect.PtrTo(t.Elem()).Implements(jsonUnmarshalerType) && !reflect.PtrTo(t.Elem()).Implements(textUnmarshalerType) {
+			// encoding/json decodes byte slices from base64 strings and from arrays of bytes.
+			return &jsonSchema{OneOf: []*jsonSchema{
+				{Type: schemaType{"null"}},
+				{Type: schemaType{"string"}, ContentEncoding: "base64"},
+				{Type: schemaType{"array"}, Items: schemaFor(t.Elem(), visiting)},
+			}}
+		}
+		return nullable(&jsonSchema{Type: schemaType{"array"}, Items: schemaFor(t.Elem(), visiting)})
+	case reflect.Array:
+		return &jsonSchema{Type: schemaType{"array"}, Items: schemaFor(t.Elem(), visiting)}
+	case reflect.Map:
+		return nullable(&jsonSchema{Type: schemaType{"object"}, AdditionalProperties: schemaFor(t.Elem(), visiting)})
+	case reflect.Struct:
+		for _, v := range visiting {
+			if v == t {
+				return &jsonSchema{}
+			}
+		}
+		visiting = append(visiting, t)
+		schema := &jsonSchema{Type: schemaType{"object"}, Properties: map[string]*jsonSchema{}, AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := field.Name
+			if tag := field.Tag.Get("json"); tag != "" {
+				if tag == "-" {
+					continue
+				}
+				if tagName := strings.Split(tag, ",")[0]; tagName != "" {
+					name = tagName
+				}
+			}
+			schema.Properties[name] = schemaFor(field.Type, visiting)
+		}
+		return schema
+	}
+	// Interfaces and other kinds accept any value.
+	return &jsonSchema{}
+}
+
+// nullable allows a schema's value to be null, like encoding/json does for pointers, slices and maps.
+func nullable(schema *jsonSchema) *jsonSchema {
+	if len(schema.Type) == 0 {
+		return schema
+	}
+	schema.Type = append(schema.Type, "null")
+	return schema
+}
+
+// ValidateSchema validates a manifest in JSON format against the schema and returns every problem found.
+// Each problem is prefixed with the JSON pointer of the offending value.
+func ValidateSchema(rawManifest []byte) []error {
+	decoder := json.NewDecoder(bytes.NewReader(rawManifest))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return []error{err}
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return []error{errors.New("unexpected data after the manifest")}
+	}
+	return validateValue(schemaFor(reflect.TypeOf(Manifest{}), nil), value, "")
+}
+
+func validateValue(schema *jsonSchema, value interface{}, path string) []error {
+	if len(schema.OneOf) > 0 {
+		// The alternatives of the generated schemas are distinguished by their type.
+		for _, alternative := range schema.OneOf {
+			if alternative.allowsType(value) {
+				return validateValue(alternative, value, path)
+			}
+		}
+		var types []string
+		for _, alternative := range schema.OneOf {
+			types = append(types, alternative.Type...)
+		}
+		return []error{schemaError(path, "expected %s, got %s", strings.Join(types, " or "), typeOf(value))}
+	}
+	if len(schema.Type) > 0 && !schema.allowsType(value) {
+		return []error{schemaError(path, "expected %s, got %s", strings.Join(schema.Type, " or "), typeOf(value))}
+	}
+
+	var problems []error
+	switch v := value.(type) {
+	case string:
+		if schema.Pattern != "" && !regexp.MustCompile(schema.Pattern).MatchString(v) {
+			problems = append(problems, schemaError(path, "%q does not match %s", v, schema.Pattern))
+		}
+		if schema.Format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, v); err != nil {
+				problems = append(problems, schemaError(path, "%q is not an RFC 3339 date-time", v))
+			}
+		}
+		if schema.ContentEncoding == "base64" {
+			if _, err := base64.StdEncoding.DecodeString(v); err != nil {
+				problems = append(problems, schemaError(path, "invalid base64 content"))
+			}
+		}
+	case json.Number:
+		if schema.Type.contains("integer") {
+			n, ok := new(big.Int).SetString(v.String(), 10)
+			if !ok {
+				problems = append(problems, schemaError(path, "expected integer, got %s", v))
+				break
+			}
+			if min, ok := new(big.Int).SetString(schema.Minimum.String(), 10); ok && n.Cmp(min) < 0 {
+				problems = append(problems, schemaError(path, "%s is less than the minimum %s", v, schema.Minimum))
+			}
+			if max, ok := new(big.Int).SetString(schema.Maximum.String(), 10); ok && n.Cmp(max) > 0 {
+				problems = append(problems, schemaError(path, "%s is greater than the maximum %s", v, schema.Maximum))
+			}
+		}
+	case []interface{}:
+		if schema.Items == nil {
+			break
+		}
+		for i, item := range v {
+			problems = append(problems, validateValue(schema.Items, item, path+"/"+strconv.Itoa(i))...)
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			keyPath := path + "/" + escapePointer(key)
+			if property := schema.property(key); property != nil {
+				problems = append(problems, validateValue(property, v[key], keyPath)...)
+				continue
+			}
+			switch additional := schema.AdditionalProperties.(type) {
+			case *jsonSchema:
+				problems = append(problems, validateValue(additional, v[key], keyPath)...)
+			case bool:
+				if !additional {
+					problems = append(problems, schemaError(keyPath, "unknown field"))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// allowsType returns true if the schema allows the JSON type of the value.
+func (s *jsonSchema) allowsType(value interface{}) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+	valueType := typeOf(value)
+	if valueType == "number" && !strings.ContainsAny(value.(json.Number).String(), ".eE") {
+		// Integers are numbers, too.
+		return s.Type.contains("integer") || s.Type.contains("number")
+	}
+	return s.Type.contains(valueType)
+}
+
+func (t schemaType) contains(name string) bool {
+	for _, v := range t {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+func typeOf(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func schemaError(path string, format string, args ...interface{}) error {
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+}
+
+// escapePointer escapes a key for use in a JSON pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
+
+// sortedKeys returns the keys of a map with string keys in sorted order.
+func sortedKeys(m interface{}) []string {
+	keys := reflect.ValueOf(m).MapKeys()
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, key.String())
+	}
+	sort.Strings(result)
+	return result
+}
+
+// Unmarshal decodes a manifest in JSON format. Unlike json.Unmarshal, it rejects unknown fields and trailing data.
+//
+// Only new manifests should be decoded with Unmarshal. Sealed manifests are decoded with json.Unmarshal, so they can still be loaded if a field is removed.
+func Unmarshal(rawManifest []byte, manifest *Manifest) error {
+	decoder := json.NewDecoder(bytes.NewReader(rawManifest))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(manifest); err != nil {
+		return fmt.Errorf("invalid manifest: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("invalid manifest: unexpected data after the manifest")
+	}
+
+	// Types with their own decoder, e.g., Certificate, do not inherit DisallowUnknownFields, so their fields are checked against the schema
+	decoder = json.NewDecoder(bytes.NewReader(rawManifest))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("invalid manifest: %v", err)
+	}
+	if problems := unknownFields(schemaFor(reflect.TypeOf(Manifest{}), nil), value, ""); len(problems) > 0 {
+		return fmt.Errorf("invalid manifest: %v", problems[0])
+	}
+	return nil
+}
+
+// unknownFields returns the fields of the value that the schema does not define.
+// Like encoding/json, it matches field names case-insensitively.
+func unknownFields(schema *jsonSchema, value interface{}, path string) []error {
+	for _, alternative := range schema.OneOf {
+		if alternative.allowsType(value) {
+			return unknownFields(alternative, value, path)
+		}
+	}
+
+	var problems []error
+	switch v := value.(type) {
+	case []interface{}:
+		if schema.Items == nil {
+			break
+		}
+		for i, item := range v {
+			problems = append(problems, unknownFields(schema.Items, item, path+"/"+strconv.Itoa(i))...)
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			keyPath := path + "/" + escapePointer(key)
+			if property := schema.property(key); property != nil {
+				problems = append(problems, unknownFields(property, v[key], keyPath)...)
+				continue
+			}
+			switch additional := schema.AdditionalProperties.(type) {
+			case *jsonSchema:
+				problems = append(problems, unknownFields(additional, v[key], keyPath)...)
+			case bool:
+				if !additional {
+					problems = append(problems, schemaError(keyPath, "unknown field"))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// property returns the schema of the property with the given name, which is matched case-insensitively if there is no exact match.
+// ValidateSchema and Unmarshal both use it, so the offline check accepts the same field names as the Coordinator.
+func (s *jsonSchema) property(name string) *jsonSchema {
+	if property, ok := s.Properties[name]; ok {
+		return property
+	}
+	for key, property := range s.Properties {
+		if strings.EqualFold(key, name) {
+			return property
+		}
+	}
+	return nil
+}
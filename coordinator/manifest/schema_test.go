@@ -0,0 +1,133 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rawSchema, err := Schema()
+	require.NoError(err)
+	var schema map[string]interface{}
+	require.NoError(json.Unmarshal(rawSchema, &schema))
+	assert.Equal(schemaVersion, schema["$schema"])
+	assert.Equal("object", schema["type"])
+	assert.Equal(false, schema["additionalProperties"])
+
+	properties, ok := schema["properties"].(map[string]interface{})
+	require.True(ok)
+	for _, field := range []string{"Packages", "Marbles", "Secrets", "Clients"} {
+		assert.Contains(properties, field)
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	testCases := map[string]struct {
+		old      string
+		new      string
+		problems []string
+	}{
+		"valid": {},
+		"unknown field": {
+			old:      `"Debug": false`,
+			new:      `"Debug": false, "Foo": 1`,
+			problems: []string{"/Packages/backend/Foo: unknown field"},
+		},
+		"field in other case": {
+			// encoding/json matches field names case-insensitively, so the Coordinator accepts them
+			old: `"UniqueID"`,
+			new: `"uniqueid"`,
+		},
+		"section in other case": {
+			old: `"Marbles"`,
+			new: `"marbles"`,
+		},
+		"wrong type": {
+			old:      `"MaxActivations": 1`,
+			new:      `"MaxActivations": "1"`,
+			problems: []string{"/Marbles/backend_first/MaxActivations: expected integer, got string"},
+		},
+		"negative unsigned integer": {
+			old:      `"MaxActivations": 1`,
+			new:      `"MaxActivations": -1`,
+			problems: []string{"/Marbles/backend_first/MaxActivations: -1 is less than the minimum 0"},
+		},
+		"invalid file mode": {
+			old:      `"Mode": "0644"`,
+			new:      `"Mode": "0999"`,
+			problems: []string{`/Marbles/backend_first/Parameters/StructuredFiles/~1tmp~1marblerun-test~1cert.der/Mode: "0999" does not match`},
+		},
+		"unknown certificate field": {
+			old:      `"SerialNumber": 42,`,
+			new:      `"SerialNumber": 42, "Foo": 1,`,
+			problems: []string{"/Secrets/cert_private/Cert/Foo: unknown field"},
+		},
+		"multiple problems": {
+			old: `"Debug": false`,
+			new: `"Debug": 0, "Foo": 1`,
+			problems: []string{
+				"/Packages/backend/Debug: expected boolean, got number",
+				"/Packages/backend/Foo: unknown field",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			rawManifest := strings.Replace(test.ManifestJSON, tc.old, tc.new, 1)
+			problems := ValidateSchema([]byte(rawManifest))
+			require.Len(t, problems, len(tc.problems))
+			for i, problem := range problems {
+				assert.Contains(problem.Error(), tc.problems[i])
+			}
+
+			// The Coordinator accepts the manifests the schema accepts
+			if len(tc.problems) == 0 {
+				var manifest Manifest
+				assert.NoError(Unmarshal([]byte(rawManifest), &manifest))
+			}
+		})
+	}
+}
+
+func TestValidateSchemaInvalidJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Len(ValidateSchema([]byte(`{"Packages": `)), 1)
+	assert.Len(ValidateSchema([]byte(test.ManifestJSON+"{}")), 1)
+}
+
+func TestUnmarshal(t *testing.T) {
+	assert := assert.New(t)
+
+	var manifest Manifest
+	assert.NoError(Unmarshal([]byte(test.ManifestJSON), &manifest))
+	assert.Equal(uint(1), manifest.Marbles["backend_first"].MaxActivations)
+
+	// Field names are matched case-insensitively, like encoding/json does
+	assert.NoError(Unmarshal([]byte(strings.Replace(test.ManifestJSON, `"CommonName"`, `"commonname"`, 1)), &manifest))
+
+	assert.Error(Unmarshal([]byte(strings.Replace(test.ManifestJSON, `"Debug": false`, `"Debug": false, "Foo": 1`, 1)), &manifest))
+	assert.Error(Unmarshal([]byte(test.ManifestJSON+"{}"), &manifest))
+
+	// Certificates have their own decoder, which is lenient, so that sealed manifests can still be loaded
+	rawManifest := []byte(strings.Replace(test.ManifestJSON, `"SerialNumber": 42,`, `"SerialNumber": 42, "Foo": 1,`, 1))
+	assert.Error(Unmarshal(rawManifest, &manifest))
+	assert.NoError(json.Unmarshal(rawManifest, &manifest))
+}
@@ -0,0 +1,214 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"fmt"
+	"reflect"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+)
+
+// ReservedSecretNames are the secrets provided by the Coordinator, which templates can access with the "Marblerun" prefix.
+var ReservedSecretNames = []string{"RootCA", "MarbleCert", "SealKey"}
+
+// binaryTemplateFuncs are the template functions that produce binary data.
+// Files and environment variables are transferred as UTF-8 strings, so their output can only be used in structured files or must be encoded, e.g., with base64.
+var binaryTemplateFuncs = []string{"der", "pkcs12", "jks"}
+
+// checkTemplates checks that the templates of a Marble's files and environment variables are valid
+// and only reference declared secrets, reserved secrets and existing fields.
+// If allowBinary is false, the templates must not output the result of a binary function.
+func checkTemplates(parameters map[string]string, kind string, secrets map[string]Secret, allowBinary bool) error {
+	for name, data := range parameters {
+		tpl, err := template.New(name).Funcs(ManifestTemplateFuncMap).Parse(data)
+		if err != nil {
+			return fmt.Errorf("invalid template in %s %s: %v", kind, name, err)
+		}
+		if tpl.Tree == nil {
+			continue
+		}
+		checker := templateChecker{tree: tpl.Tree, secrets: secrets, allowBinary: allowBinary}
+		if err := checker.walk(tpl.Tree.Root, true); err != nil {
+			return fmt.Errorf("invalid template in %s %s: %v", kind, name, err)
+		}
+	}
+	return nil
+}
+
+// checkStructuredFiles checks the attributes and templates of a Marble's structured files.
+func checkStructuredFiles(parameters *rpc.Parameters, secrets map[string]Secret) error {
+	templates := make(map[string]string)
+	for path, file := range parameters.StructuredFiles {
+		if file == nil {
+			return fmt.Errorf("file %s is empty", path)
+		}
+		if _, ok := parameters.Files[path]; ok {
+			return fmt.Errorf("file %s is specified in both Files and StructuredFiles", path)
+		}
+		if err := file.Check(); err != nil {
+			return fmt.Errorf("invalid file %s: %v", path, err)
+		}
+		templates[path] = string(file.Content)
+	}
+	return checkTemplates(templates, "file", secrets, true)
+}
+
+type templateChecker struct {
+	tree        *parse.Tree
+	secrets     map[string]Secret
+	allowBinary bool
+}
+
+// walk checks all field references below the node. Fields can only be checked while dot refers to the template's root data,
+// so references inside of range and with blocks are skipped.
+func (c templateChecker) walk(node parse.Node, dotIsRoot bool) error {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			if err := c.walk(child, dotIsRoot); err != nil {
+				return err
+			}
+		}
+	case *parse.ActionNode:
+		if err := c.checkOutput(n.Pipe); err != nil {
+			return err
+		}
+		return c.walk(n.Pipe, dotIsRoot)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			if err := c.walk(cmd, dotIsRoot); err != nil {
+				return err
+			}
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			if err := c.walk(arg, dotIsRoot); err != nil {
+				return err
+			}
+		}
+	case *parse.IfNode:
+		return c.walkBranch(&n.BranchNode, dotIsRoot, dotIsRoot)
+	case *parse.RangeNode:
+		return c.walkBranch(&n.BranchNode, dotIsRoot, false)
+	case *parse.WithNode:
+		return c.walkBranch(&n.BranchNode, dotIsRoot, false)
+	case *parse.FieldNode:
+		if dotIsRoot {
+			return c.checkPath(n, n.Ident)
+		}
+	case *parse.VariableNode:
+		// $ always refers to the root data
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			return c.checkPath(n, n.Ident[1:])
+		}
+	}
+	return nil
+}
+
+func (c templateChecker) walkBranch(n *parse.BranchNode, dotIsRoot bool, dotIsRootInBody bool) error {
+	// The result of the pipeline may become dot, which can be output in the body
+	if err := c.checkOutput(n.Pipe); err != nil {
+		return err
+	}
+	if err := c.walk(n.Pipe, dotIsRoot); err != nil {
+		return err
+	}
+	if err := c.walk(n.List, dotIsRootInBody); err != nil {
+		return err
+	}
+	// dot is unchanged in the else branch
+	return c.walk(n.ElseList, dotIsRoot)
+}
+
+// checkOutput checks that the result of a pipeline is not binary data, unless it is allowed.
+// Binary functions can still be used in arguments of other functions, e.g., base64.
+func (c templateChecker) checkOutput(pipe *parse.PipeNode) error {
+	if c.allowBinary || pipe == nil || len(pipe.Cmds) == 0 {
+		return nil
+	}
+	last := pipe.Cmds[len(pipe.Cmds)-1]
+	if len(last.Args) == 0 {
+		return nil
+	}
+	switch arg := last.Args[0].(type) {
+	case *parse.IdentifierNode:
+		if isBinaryFunc(arg.Ident) {
+			location, _ := c.tree.ErrorContext(arg)
+			return fmt.Errorf("%s: %s produces binary data, which is only supported in StructuredFiles. Encode it, e.g., with base64", location, arg.Ident)
+		}
+	case *parse.PipeNode:
+		return c.checkOutput(arg)
+	}
+	return nil
+}
+
+// checkPath checks a field reference relative to the template's root data.
+func (c templateChecker) checkPath(node parse.Node, path []string) error {
+	location, _ := c.tree.ErrorContext(node)
+	if path[0] != "Secrets" && path[0] != "Marblerun" {
+		return fmt.Errorf("%s: unknown field %s, expected Secrets or Marblerun", location, path[0])
+	}
+	if len(path) < 2 {
+		return nil
+	}
+
+	if path[0] == "Secrets" {
+		if _, ok := c.secrets[path[1]]; !ok {
+			return fmt.Errorf("%s: secret %s is not declared in the manifest", location, path[1])
+		}
+	} else if !isReservedSecret(path[1]) {
+		return fmt.Errorf("%s: unknown reserved secret %s, expected one of %v", location, path[1], ReservedSecretNames)
+	}
+
+	// Check the fields of the secret
+	typ := reflect.TypeOf(Secret{})
+	for _, field := range path[2:] {
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		if _, ok := reflect.PtrTo(typ).MethodByName(field); ok {
+			// Do not check beyond method calls
+			return nil
+		}
+		if typ.Kind() != reflect.Struct {
+			return nil
+		}
+		structField, ok := typ.FieldByName(field)
+		if !ok || structField.PkgPath != "" {
+			return fmt.Errorf("%s: %s has no field %s", location, typ.Name(), field)
+		}
+		typ = structField.Type
+	}
+	return nil
+}
+
+func isBinaryFunc(name string) bool {
+	for _, binary := range binaryTemplateFuncs {
+		if name == binary {
+			return true
+		}
+	}
+	return false
+}
+
+func isReservedSecret(name string) bool {
+	for _, reserved := range ReservedSecretNames {
+		if name == reserved {
+			return true
+		}
+	}
+	return false
+}
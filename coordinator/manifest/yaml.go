@@ -0,0 +1,152 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Formats of a manifest
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+// DetectFormat returns FormatJSON if the manifest is a JSON object and FormatYAML otherwise.
+func DetectFormat(rawManifest []byte) string {
+	if trimmed := bytes.TrimSpace(rawManifest); len(trimmed) > 0 && trimmed[0] == '{' {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+// ToJSON converts a manifest in JSON or YAML format to JSON.
+//
+// JSON manifests are returned unchanged. YAML manifests are converted to the canonical form (see Canonicalize).
+// The manifest signature is computed with CanonicalHash, so it does not depend on the format.
+func ToJSON(rawManifest []byte) ([]byte, error) {
+	if DetectFormat(rawManifest) == FormatJSON {
+		return rawManifest, nil
+	}
+
+	var value interface{}
+	if err := yaml.Unmarshal(rawManifest, &value); err != nil {
+		return nil, fmt.Errorf("invalid YAML manifest: %v", err)
+	}
+	value, err := jsonValueFromYAML(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML manifest: %v", err)
+	}
+
+	jsonManifest, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid YAML manifest: %v", err)
+	}
+	return canonicalJSON(jsonManifest)
+}
+
+// jsonValueFromYAML converts the maps decoded by the YAML parser to maps with string keys.
+// Keys that YAML does not decode as strings are rejected. Formatting them would silently change keys like "yes" or "on", which YAML 1.1 decodes as booleans.
+func jsonValueFromYAML(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			stringKey, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("unsupported key %v of type %T, quote the key to use it as a string", key, key)
+			}
+			converted, err := jsonValueFromYAML(elem)
+			if err != nil {
+				return nil, err
+			}
+			result[stringKey] = converted
+		}
+		return result, nil
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, elem := range v {
+			converted, err := jsonValueFromYAML(elem)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = converted
+		}
+		return result, nil
+	}
+	return value, nil
+}
+
+// ToYAML converts a manifest in JSON format to YAML. The order of the fields is preserved.
+func ToYAML(rawManifest []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(rawManifest))
+	decoder.UseNumber()
+	value, err := yamlValueFromJSON(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON manifest: %v", err)
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON manifest: unexpected data after the manifest")
+	}
+	return yaml.Marshal(value)
+}
+
+// yamlValueFromJSON decodes the next JSON value. Objects are decoded to yaml.MapSlice to keep the order of their keys.
+func yamlValueFromJSON(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t := token.(type) {
+	case json.Delim:
+		switch t {
+		case '{':
+			result := yaml.MapSlice{}
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := yamlValueFromJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, yaml.MapItem{Key: key, Value: value})
+			}
+			_, err := decoder.Token()
+			return result, err
+		case '[':
+			result := []interface{}{}
+			for decoder.More() {
+				value, err := yamlValueFromJSON(decoder)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, value)
+			}
+			_, err := decoder.Token()
+			return result, err
+		}
+		return nil, fmt.Errorf("unexpected %v", t)
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i, nil
+		}
+		if u, err := strconv.ParseUint(t.String(), 10, 64); err == nil {
+			return u, nil
+		}
+		return t.Float64()
+	}
+	return token, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package manifest
+
+import (
+	"testing"
+
+	"github.com/edgelesssys/marblerun/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(FormatJSON, DetectFormat([]byte(test.ManifestJSON)))
+	assert.Equal(FormatJSON, DetectFormat([]byte("\n  {}")))
+	assert.Equal(FormatYAML, DetectFormat([]byte("Packages: {}")))
+	assert.Equal(FormatYAML, DetectFormat([]byte("")))
+}
+
+func TestToJSON(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// JSON manifests are returned unchanged
+	jsonManifest, err := ToJSON([]byte(test.ManifestJSON))
+	require.NoError(err)
+	assert.Equal(test.ManifestJSON, string(jsonManifest))
+
+	yamlManifest := `
+Packages:
+  backend:
+    UniqueID: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+    Debug: false
+Marbles:
+  backend:
+    Package: backend
+    MaxActivations: 1
+    Parameters:
+      Argv: [serve]
+`
+	jsonManifest, err = ToJSON([]byte(yamlManifest))
+	require.NoError(err)
+	assert.Equal(`{"Marbles":{"backend":{"MaxActivations":1,"Package":"backend","Parameters":{"Argv":["serve"]}}},"Packages":{"backend":{"Debug":false,"UniqueID":"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"}}}`, string(jsonManifest))
+
+	var manifest Manifest
+	require.NoError(Unmarshal(jsonManifest, &manifest))
+	assert.Equal("backend", manifest.Marbles["backend"].Package)
+
+	_, err = ToJSON([]byte("Packages: [1"))
+	assert.Error(err)
+	_, err = ToJSON([]byte("? [1, 2]\n: foo\n"))
+	assert.Error(err)
+}
+
+func TestToJSONNonStringKeys(t *testing.T) {
+	// YAML 1.1 decodes these keys as booleans, numbers or null, which must not be silently converted to other strings
+	for _, key := range []string{"Y", "yes", "on", "off", "true", "1", "1.0", "0x10", "~", "null"} {
+		t.Run(key, func(t *testing.T) {
+			assert := assert.New(t)
+			require := require.New(t)
+
+			_, err := ToJSON([]byte("Marbles:\n  " + key + ":\n    Package: backend\n"))
+			assert.Error(err)
+
+			// Quoted keys are strings
+			jsonManifest, err := ToJSON([]byte("Marbles:\n  \"" + key + "\":\n    Package: backend\n"))
+			require.NoError(err)
+			assert.Equal(`{"Marbles":{"`+key+`":{"Package":"backend"}}}`, string(jsonManifest))
+
+			// Converted manifests quote such keys
+			yamlManifest, err := ToYAML(jsonManifest)
+			require.NoError(err)
+			convertedManifest, err := ToJSON(yamlManifest)
+			require.NoError(err)
+			assert.Equal(jsonManifest, convertedManifest)
+		})
+	}
+}
+
+func TestToYAML(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	yamlManifest, err := ToYAML([]byte(`{"b": {"d": 1, "c": [1.5, "x", null]}, "a": true}`))
+	require.NoError(err)
+	// The order of the fields is preserved
+	assert.Equal("b:\n  d: 1\n  c:\n  - 1.5\n  - x\n  - null\na: true\n", string(yamlManifest))
+
+	_, err = ToYAML([]byte(`{"a": `))
+	assert.Error(err)
+	_, err = ToYAML([]byte(`{"a": 1} {}`))
+	assert.Error(err)
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	yamlManifest, err := ToYAML([]byte(test.ManifestJSON))
+	require.NoError(err)
+	jsonManifest, err := ToJSON(yamlManifest)
+	require.NoError(err)
+	canonical, err := Canonicalize([]byte(test.ManifestJSON))
+	require.NoError(err)
+	assert.Equal(canonical, jsonManifest)
+}
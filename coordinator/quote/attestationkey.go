@@ -0,0 +1,90 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// keySignedReport is the payload of a quote in the attestation-key format
+type keySignedReport struct {
+	// JSON-encoded Report
+	Report []byte
+	// Ed25519 signature over Report
+	Signature []byte
+}
+
+// AttestationKeyValidator validates reports signed by one of the trusted software attestation keys.
+// The keys are not bound to any hardware, so this validator is meant for testing only.
+type AttestationKeyValidator struct {
+	keys []ed25519.PublicKey
+}
+
+// NewAttestationKeyValidator returns a new AttestationKeyValidator object
+func NewAttestationKeyValidator(keys ...ed25519.PublicKey) *AttestationKeyValidator {
+	return &AttestationKeyValidator{keys: keys}
+}
+
+// Validate implements the Validator interface for AttestationKeyValidator
+func (v *AttestationKeyValidator) Validate(quote []byte, cert []byte, pp PackageProperties, ip InfrastructureProperties) error {
+	if err := requireNoInfrastructure(FormatAttestationKey, ip); err != nil {
+		return err
+	}
+	return v.validate(quote, messageHash(cert), pp)
+}
+
+// ValidateWithNonce implements the NonceValidator interface for AttestationKeyValidator
+func (v *AttestationKeyValidator) ValidateWithNonce(quote []byte, cert []byte, nonce []byte, pp PackageProperties) error {
+	return v.validate(quote, NonceReportData(cert, nonce), pp)
+}
+
+func (v *AttestationKeyValidator) validate(quote []byte, data []byte, pp PackageProperties) error {
+	var report keySignedReport
+	if err := json.Unmarshal(quote, &report); err != nil {
+		return fmt.Errorf("parsing report failed: %v", err)
+	}
+
+	for _, key := range v.keys {
+		if ed25519.Verify(key, report.Report, report.Signature) {
+			return verifyReport(report.Report, data, pp)
+		}
+	}
+	return errors.New("report is not signed by a trusted attestation key")
+}
+
+// AttestationKeyIssuer issues reports signed by a software attestation key. It is meant for testing only.
+type AttestationKeyIssuer struct {
+	key        ed25519.PrivateKey
+	properties Report
+}
+
+// NewAttestationKeyIssuer returns a new AttestationKeyIssuer object.
+// properties is the report to issue, its Data is set for every quote.
+func NewAttestationKeyIssuer(key ed25519.PrivateKey, properties Report) *AttestationKeyIssuer {
+	return &AttestationKeyIssuer{key: key, properties: properties}
+}
+
+// Issue implements the Issuer interface
+func (i *AttestationKeyIssuer) Issue(cert []byte) ([]byte, error) {
+	rawReport, err := marshalReport(i.properties, cert)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(keySignedReport{Report: rawReport, Signature: ed25519.Sign(i.key, rawReport)})
+}
+
+// IssueWithNonce implements the NonceIssuer interface
+func (i *AttestationKeyIssuer) IssueWithNonce(cert []byte, nonce []byte) ([]byte, error) {
+	rawReport, err := marshalNonceReport(i.properties, cert, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(keySignedReport{Report: rawReport, Signature: ed25519.Sign(i.key, rawReport)})
+}
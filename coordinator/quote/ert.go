@@ -7,9 +7,8 @@
 package quote
 
 import (
+	"bytes"
 	"strings"
-
-	"github.com/google/go-cmp/cmp"
 )
 
 // PackageProperties contains the enclave package-specific properties of an OpenEnclave quote.
@@ -60,8 +59,20 @@ func (required PackageProperties) IsCompliant(given PackageProperties) bool {
 	return true
 }
 
-// IsCompliant checks if the given infrastructure properties comply with the requirements
+// IsCompliant checks if the given infrastructure properties comply with the requirements.
+// Properties that are not required are not checked. The security version numbers are minimums.
 func (required InfrastructureProperties) IsCompliant(given InfrastructureProperties) bool {
-	// TODO: implement proper logic including SVN comparison
-	return cmp.Equal(required, given)
+	if len(required.CPUSVN) > 0 && !bytes.Equal(required.CPUSVN, given.CPUSVN) {
+		return false
+	}
+	if required.QESVN != nil && (given.QESVN == nil || *required.QESVN > *given.QESVN) {
+		return false
+	}
+	if required.PCESVN != nil && (given.PCESVN == nil || *required.PCESVN > *given.PCESVN) {
+		return false
+	}
+	if len(required.RootCA) > 0 && !bytes.Equal(required.RootCA, given.RootCA) {
+		return false
+	}
+	return true
 }
@@ -0,0 +1,46 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInfrastructurePropertiesIsCompliant(t *testing.T) {
+	svn := func(v uint16) *uint16 { return &v }
+	given := InfrastructureProperties{
+		CPUSVN: []byte{0, 1, 2, 3},
+		QESVN:  svn(2),
+		PCESVN: svn(3),
+		RootCA: []byte{3, 3, 3},
+	}
+
+	testCases := map[string]struct {
+		required  InfrastructureProperties
+		compliant bool
+	}{
+		"nothing required": {compliant: true},
+		"equal":            {required: given, compliant: true},
+		"older QESVN":      {required: InfrastructureProperties{QESVN: svn(1)}, compliant: true},
+		"newer QESVN":      {required: InfrastructureProperties{QESVN: svn(3)}},
+		"older PCESVN":     {required: InfrastructureProperties{PCESVN: svn(2)}, compliant: true},
+		"newer PCESVN":     {required: InfrastructureProperties{PCESVN: svn(4)}},
+		"other CPUSVN":     {required: InfrastructureProperties{CPUSVN: []byte{3, 2, 1, 0}}},
+		"other root CA":    {required: InfrastructureProperties{RootCA: []byte{4, 4, 4}}},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.compliant, tc.required.IsCompliant(given))
+		})
+	}
+
+	// required versions must be reported
+	assert.False(t, InfrastructureProperties{QESVN: svn(0)}.IsCompliant(InfrastructureProperties{}))
+}
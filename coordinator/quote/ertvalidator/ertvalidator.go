@@ -28,16 +28,62 @@ func NewERTValidator() *ERTValidator {
 
 // Validate implements the Validator interface for ERTValidator
 func (m *ERTValidator) Validate(givenQuote []byte, cert []byte, pp quote.PackageProperties, ip quote.InfrastructureProperties) error {
+	reported, err := m.ValidateAndReport(givenQuote, cert, pp)
+	if err != nil {
+		return err
+	}
+	if !ip.IsCompliant(reported) {
+		return fmt.Errorf("InfrastructureProperties not compliant:\n%v\n%v", reported, ip)
+	}
+	return nil
+}
+
+// ValidateAndReport implements the InfrastructureReporter interface for ERTValidator.
+// The infrastructure properties are read from the quote and its PCK certificate chain, which are covered by the quote's verification.
+func (m *ERTValidator) ValidateAndReport(givenQuote []byte, cert []byte, pp quote.PackageProperties) (quote.InfrastructureProperties, error) {
+	err := m.validate(givenQuote, pp, func(reportData []byte) error {
+		// Check that cert is equal
+		hash := sha256.Sum256(cert)
+		if !bytes.Equal(reportData[:len(hash)], hash[:]) {
+			return fmt.Errorf("hash(cert) != report.Data: %v != %v", hash, reportData)
+		}
+		return nil
+	})
+	if err != nil {
+		return quote.InfrastructureProperties{}, err
+	}
+	platform, err := parsePlatform(givenQuote)
+	if err != nil {
+		return quote.InfrastructureProperties{}, fmt.Errorf("parsing quote failed: %v", err)
+	}
+	return platform.infrastructure, nil
+}
+
+// PlatformID implements the PlatformIdentifier interface for ERTValidator.
+// The platform is identified by its FMSPC and the security version numbers of its TCB.
+func (m *ERTValidator) PlatformID(givenQuote []byte) ([]byte, error) {
+	platform, err := parsePlatform(givenQuote)
+	if err != nil {
+		return nil, err
+	}
+	return platform.id(), nil
+}
+
+// ValidateWithNonce implements the NonceValidator interface for ERTValidator
+func (m *ERTValidator) ValidateWithNonce(givenQuote []byte, cert []byte, nonce []byte, pp quote.PackageProperties) error {
+	return m.validate(givenQuote, pp, func(reportData []byte) error {
+		return quote.CheckNonceReportData(reportData, cert, nonce)
+	})
+}
+
+func (m *ERTValidator) validate(givenQuote []byte, pp quote.PackageProperties, checkReportData func([]byte) error) error {
 	// Verify Quote
 	report, err := ertenclave.VerifyRemoteReport(givenQuote)
 	if err != nil {
 		return fmt.Errorf("verifying quote failed: %v", err)
 	}
-
-	// Check that cert is equal
-	hash := sha256.Sum256(cert)
-	if !bytes.Equal(report.Data[:len(hash)], hash[:]) {
-		return fmt.Errorf("hash(cert) != report.Data: %v != %v", hash, report.Data)
+	if err := checkReportData(report.Data); err != nil {
+		return err
 	}
 
 	// Verify PackageProperties
@@ -52,8 +98,6 @@ func (m *ERTValidator) Validate(givenQuote []byte, cert []byte, pp quote.Package
 	if !pp.IsCompliant(reportedProps) {
 		return fmt.Errorf("PackageProperties not compliant:\n%v\n%v", reportedProps, pp)
 	}
-
-	// TODO Verify InfrastructureProperties with information from OE Quote
 	return nil
 }
 
@@ -70,3 +114,8 @@ func (m *ERTIssuer) Issue(cert []byte) ([]byte, error) {
 	hash := sha256.Sum256(cert)
 	return ertenclave.GetRemoteReport(hash[:])
 }
+
+// IssueWithNonce implements the NonceIssuer interface
+func (m *ERTIssuer) IssueWithNonce(cert []byte, nonce []byte) ([]byte, error) {
+	return ertenclave.GetRemoteReport(quote.NonceReportData(cert, nonce))
+}
@@ -0,0 +1,157 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ertvalidator
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+)
+
+// Layout of an OE remote report, which is an oe_report_header followed by an SGX ECDSA quote (version 3)
+const (
+	oeReportHeaderSize = 16
+	sgxQuoteVersion    = 3
+	quoteHeaderSize    = 48
+	reportBodySize     = 384
+	cpuSVNSize         = 16
+	// ECDSA signature, attestation key, QE report body and QE report signature
+	qeAuthDataOffset = 64 + 64 + reportBodySize + 64
+	// certification data containing the PEM-encoded PCK certificate chain
+	certDataTypePCKChain = 5
+)
+
+var (
+	oidSGXExtensions = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1}
+	oidFMSPC         = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 4}
+)
+
+// platform contains the properties of the platform an SGX quote was issued on
+type platform struct {
+	infrastructure quote.InfrastructureProperties
+	// Family-Model-Stepping-Platform-CustomSKU of the platform, taken from its PCK certificate
+	fmspc []byte
+}
+
+// id returns an identifier of the platform and its TCB level
+func (p platform) id() []byte {
+	id := make([]byte, 0, len(p.fmspc)+len(p.infrastructure.CPUSVN)+4)
+	id = append(id, p.fmspc...)
+	id = append(id, p.infrastructure.CPUSVN...)
+	id = append(id, byte(*p.infrastructure.PCESVN), byte(*p.infrastructure.PCESVN>>8))
+	return append(id, byte(*p.infrastructure.QESVN), byte(*p.infrastructure.QESVN>>8))
+}
+
+// parsePlatform reads the platform properties from an OE remote report. It does not verify the report.
+func parsePlatform(report []byte) (platform, error) {
+	if len(report) < oeReportHeaderSize {
+		return platform{}, errors.New("report is too short")
+	}
+	sgxQuote := report[oeReportHeaderSize:]
+	if binary.LittleEndian.Uint64(report[8:oeReportHeaderSize]) != uint64(len(sgxQuote)) {
+		return platform{}, errors.New("report size does not match its header")
+	}
+	if len(sgxQuote) < quoteHeaderSize+reportBodySize+4 {
+		return platform{}, errors.New("quote is too short")
+	}
+	if version := binary.LittleEndian.Uint16(sgxQuote); version != sgxQuoteVersion {
+		return platform{}, fmt.Errorf("unsupported quote version: %v", version)
+	}
+
+	qeSVN := binary.LittleEndian.Uint16(sgxQuote[8:])
+	pceSVN := binary.LittleEndian.Uint16(sgxQuote[10:])
+	body := sgxQuote[quoteHeaderSize : quoteHeaderSize+reportBodySize]
+	cpuSVN := append([]byte{}, body[:cpuSVNSize]...)
+
+	signatureData := sgxQuote[quoteHeaderSize+reportBodySize+4:]
+	if uint64(binary.LittleEndian.Uint32(sgxQuote[quoteHeaderSize+reportBodySize:])) != uint64(len(signatureData)) {
+		return platform{}, errors.New("quote signature size does not match the quote")
+	}
+	if len(signatureData) < qeAuthDataOffset+2 {
+		return platform{}, errors.New("quote signature is too short")
+	}
+	certDataOffset := qeAuthDataOffset + 2 + int(binary.LittleEndian.Uint16(signatureData[qeAuthDataOffset:]))
+	if len(signatureData) < certDataOffset+6 {
+		return platform{}, errors.New("quote signature is too short")
+	}
+	certDataType := binary.LittleEndian.Uint16(signatureData[certDataOffset:])
+	certDataSize := uint64(binary.LittleEndian.Uint32(signatureData[certDataOffset+2:]))
+	certData := signatureData[certDataOffset+6:]
+	if certDataSize > uint64(len(certData)) {
+		return platform{}, errors.New("quote certification data is too short")
+	}
+	if certDataType != certDataTypePCKChain {
+		return platform{}, fmt.Errorf("unsupported quote certification data type: %v", certDataType)
+	}
+
+	chain, err := parsePEMCertificates(certData[:certDataSize])
+	if err != nil {
+		return platform{}, err
+	}
+	fmspc, err := parseFMSPC(chain[0])
+	if err != nil {
+		return platform{}, err
+	}
+
+	return platform{
+		infrastructure: quote.InfrastructureProperties{
+			CPUSVN: cpuSVN,
+			QESVN:  &qeSVN,
+			PCESVN: &pceSVN,
+			RootCA: chain[len(chain)-1].Raw,
+		},
+		fmspc: fmspc,
+	}, nil
+}
+
+// parsePEMCertificates parses the PCK certificate chain of a quote, leaf first
+func parsePEMCertificates(data []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("parsing PCK certificate chain failed: %v", err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("quote does not contain a PCK certificate chain")
+	}
+	return chain, nil
+}
+
+// parseFMSPC reads the FMSPC from the SGX extension of a PCK certificate
+func parseFMSPC(pckCert *x509.Certificate) ([]byte, error) {
+	for _, ext := range pckCert.Extensions {
+		if !ext.Id.Equal(oidSGXExtensions) {
+			continue
+		}
+		var sgxExtensions []struct {
+			ID    asn1.ObjectIdentifier
+			Value asn1.RawValue
+		}
+		if _, err := asn1.Unmarshal(ext.Value, &sgxExtensions); err != nil {
+			return nil, fmt.Errorf("parsing SGX extension of PCK certificate failed: %v", err)
+		}
+		for _, sgxExt := range sgxExtensions {
+			if sgxExt.ID.Equal(oidFMSPC) {
+				return sgxExt.Value.Bytes, nil
+			}
+		}
+	}
+	return nil, errors.New("PCK certificate does not contain the FMSPC")
+}
@@ -0,0 +1,129 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package ertvalidator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlatform(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	fmspc := []byte{0, 0x90, 0x6e, 0xa1, 0, 0}
+	rootCert, pckCert := createPCKChain(t, fmspc)
+	cpuSVN := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	report := createReport(2, 3, cpuSVN, append(pemCertificate(pckCert), pemCertificate(rootCert)...))
+
+	platform, err := parsePlatform(report)
+	require.NoError(err)
+	assert.Equal(cpuSVN, platform.infrastructure.CPUSVN)
+	assert.Equal(uint16(2), *platform.infrastructure.QESVN)
+	assert.Equal(uint16(3), *platform.infrastructure.PCESVN)
+	assert.Equal(rootCert.Raw, platform.infrastructure.RootCA)
+	assert.Equal(fmspc, platform.fmspc)
+
+	// the platform ID changes with the TCB level
+	other, err := parsePlatform(createReport(2, 4, cpuSVN, append(pemCertificate(pckCert), pemCertificate(rootCert)...)))
+	require.NoError(err)
+	assert.NotEqual(platform.id(), other.id())
+
+	// truncated reports
+	for _, size := range []int{0, oeReportHeaderSize, oeReportHeaderSize + quoteHeaderSize + reportBodySize, len(report) - 1} {
+		truncated := append([]byte{}, report[:size]...)
+		if size >= oeReportHeaderSize {
+			binary.LittleEndian.PutUint64(truncated[8:], uint64(size-oeReportHeaderSize))
+		}
+		_, err = parsePlatform(truncated)
+		assert.Error(err, size)
+	}
+
+	// the PCK certificate must contain the FMSPC
+	_, err = parsePlatform(createReport(2, 3, cpuSVN, pemCertificate(rootCert)))
+	assert.Error(err)
+}
+
+// createReport creates an OE remote report with the given platform properties and PCK certificate chain. Its signatures are empty.
+func createReport(qeSVN uint16, pceSVN uint16, cpuSVN []byte, pckChain []byte) []byte {
+	signatureData := make([]byte, qeAuthDataOffset+2+6+len(pckChain))
+	binary.LittleEndian.PutUint16(signatureData[qeAuthDataOffset+2:], certDataTypePCKChain)
+	binary.LittleEndian.PutUint32(signatureData[qeAuthDataOffset+4:], uint32(len(pckChain)))
+	copy(signatureData[qeAuthDataOffset+8:], pckChain)
+
+	sgxQuote := make([]byte, quoteHeaderSize+reportBodySize+4, quoteHeaderSize+reportBodySize+4+len(signatureData))
+	binary.LittleEndian.PutUint16(sgxQuote, sgxQuoteVersion)
+	binary.LittleEndian.PutUint16(sgxQuote[8:], qeSVN)
+	binary.LittleEndian.PutUint16(sgxQuote[10:], pceSVN)
+	copy(sgxQuote[quoteHeaderSize:], cpuSVN)
+	binary.LittleEndian.PutUint32(sgxQuote[quoteHeaderSize+reportBodySize:], uint32(len(signatureData)))
+	sgxQuote = append(sgxQuote, signatureData...)
+
+	report := make([]byte, oeReportHeaderSize, oeReportHeaderSize+len(sgxQuote))
+	binary.LittleEndian.PutUint64(report[8:], uint64(len(sgxQuote)))
+	return append(report, sgxQuote...)
+}
+
+// createPCKChain creates a root CA and a PCK certificate with the given FMSPC in its SGX extension
+func createPCKChain(t *testing.T, fmspc []byte) (*x509.Certificate, *x509.Certificate) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "SGX Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rawRoot, err := x509.CreateCertificate(rand.Reader, template, template, &rootKey.PublicKey, rootKey)
+	require.NoError(t, err)
+	rootCert, err := x509.ParseCertificate(rawRoot)
+	require.NoError(t, err)
+
+	rawFMSPC, err := asn1.Marshal(fmspc)
+	require.NoError(t, err)
+	sgxExtensions, err := asn1.Marshal([]struct {
+		ID    asn1.ObjectIdentifier
+		Value asn1.RawValue
+	}{
+		{ID: asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 1}, Value: asn1.RawValue{Tag: asn1.TagOctetString, Bytes: []byte{1}}},
+		{ID: oidFMSPC, Value: asn1.RawValue{FullBytes: rawFMSPC}},
+	})
+	require.NoError(t, err)
+	pckKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template = &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		Subject:         pkix.Name{CommonName: "SGX PCK Certificate"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidSGXExtensions, Value: sgxExtensions}},
+	}
+	rawPCK, err := x509.CreateCertificate(rand.Reader, template, rootCert, &pckKey.PublicKey, rootKey)
+	require.NoError(t, err)
+	pckCert, err := x509.ParseCertificate(rawPCK)
+	require.NoError(t, err)
+	return rootCert, pckCert
+}
+
+func pemCertificate(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
@@ -24,6 +24,11 @@ func (m *FailValidator) Validate(quote []byte, cert []byte, pp PackageProperties
 	return fmt.Errorf("cannot validate quote")
 }
 
+// ValidateWithNonce implements the NonceValidator interface for FailValidator
+func (m *FailValidator) ValidateWithNonce(quote []byte, cert []byte, nonce []byte, pp PackageProperties) error {
+	return fmt.Errorf("cannot validate quote")
+}
+
 // FailIssuer always fails
 type FailIssuer struct{}
 
@@ -36,3 +41,8 @@ func NewFailIssuer() *FailIssuer {
 func (m *FailIssuer) Issue(cert []byte) ([]byte, error) {
 	return nil, fmt.Errorf("cannot issue quote")
 }
+
+// IssueWithNonce implements the NonceIssuer interface
+func (m *FailIssuer) IssueWithNonce(cert []byte, nonce []byte) ([]byte, error) {
+	return nil, fmt.Errorf("cannot issue quote")
+}
@@ -7,6 +7,8 @@
 // Package quote provides the quoting functionialty for remote attestation on both Coordinator and Marble site.
 package quote
 
+import "errors"
+
 // Validator validates quotes
 type Validator interface {
 	// Validate validates a quote for a given message and properties
@@ -18,3 +20,34 @@ type Issuer interface {
 	// Issue issues a quote for remote attestation for a given message
 	Issue(cert []byte) (quote []byte, err error)
 }
+
+// NonceIssuer is implemented by issuers that can bind a quote to a client's nonce in addition to a certificate.
+// Such quotes prove their freshness to the client.
+type NonceIssuer interface {
+	// IssueWithNonce issues a quote whose report data is NonceReportData(cert, nonce)
+	IssueWithNonce(cert []byte, nonce []byte) (quote []byte, err error)
+}
+
+// NonceValidator is implemented by validators that can validate quotes issued by a NonceIssuer
+type NonceValidator interface {
+	// ValidateWithNonce validates a quote whose report data must be NonceReportData(cert, nonce)
+	ValidateWithNonce(quote []byte, cert []byte, nonce []byte, pp PackageProperties) error
+}
+
+// InfrastructureReporter is implemented by validators that can extract the infrastructure properties from a quote.
+// It allows to verify a quote once and match the result against multiple infrastructures.
+type InfrastructureReporter interface {
+	// ValidateAndReport validates a quote for a given message and package properties and returns the infrastructure properties of the platform
+	ValidateAndReport(quote []byte, cert []byte, pp PackageProperties) (InfrastructureProperties, error)
+}
+
+// ErrInfrastructureNotReported is returned by an InfrastructureReporter if the quote's format does not report infrastructure properties.
+// Callers should fall back to Validator.Validate in that case.
+var ErrInfrastructureNotReported = errors.New("attestation format does not report infrastructure properties")
+
+// PlatformIdentifier is implemented by validators that can identify the platform a quote was issued on, e.g., by its FMSPC and security version numbers.
+// The quote is not validated, so the identity must only be used to look up the results of previous validations.
+type PlatformIdentifier interface {
+	// PlatformID returns an identifier of the platform and its TCB level
+	PlatformID(quote []byte) ([]byte, error)
+}
@@ -9,6 +9,7 @@ package quote
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"sync"
 )
@@ -34,22 +35,56 @@ func NewMockValidator() *MockValidator {
 
 // Validate implements the Validator interface
 func (m *MockValidator) Validate(quote []byte, message []byte, pp PackageProperties, ip InfrastructureProperties) error {
+	entry, err := m.lookup(quote, message, pp)
+	if err != nil {
+		return err
+	}
+	if !ip.IsCompliant(entry.ip) {
+		return errors.New("infrastructure does not comply")
+	}
+	return nil
+}
+
+// ValidateWithNonce implements the NonceValidator interface. Valid quotes must have been added with NonceReportData(cert, nonce) as message.
+func (m *MockValidator) ValidateWithNonce(quote []byte, cert []byte, nonce []byte, pp PackageProperties) error {
+	_, err := m.lookup(quote, NonceReportData(cert, nonce), pp)
+	return err
+}
+
+// ValidateAndReport implements the InfrastructureReporter interface
+func (m *MockValidator) ValidateAndReport(quote []byte, message []byte, pp PackageProperties) (InfrastructureProperties, error) {
+	entry, err := m.lookup(quote, message, pp)
+	if err != nil {
+		return InfrastructureProperties{}, err
+	}
+	return entry.ip, nil
+}
+
+// PlatformID implements the PlatformIdentifier interface. Quotes added with the same infrastructure properties are from the same platform.
+func (m *MockValidator) PlatformID(quote []byte) ([]byte, error) {
+	m.mutex.Lock()
+	entry, found := m.valid[string(quote)]
+	m.mutex.Unlock()
+	if !found {
+		return nil, errors.New("wrong quote")
+	}
+	return json.Marshal(entry.ip)
+}
+
+func (m *MockValidator) lookup(quote []byte, message []byte, pp PackageProperties) (entry, error) {
 	m.mutex.Lock()
 	entry, found := m.valid[string(quote)]
 	m.mutex.Unlock()
 	if !found {
-		return errors.New("wrong quote")
+		return entry, errors.New("wrong quote")
 	}
 	if !bytes.Equal(entry.message, message) {
-		return errors.New("wrong message")
+		return entry, errors.New("wrong message")
 	}
 	if !pp.IsCompliant(entry.pp) {
-		return errors.New("package does not comply")
+		return entry, errors.New("package does not comply")
 	}
-	if !ip.IsCompliant(entry.ip) {
-		return errors.New("infrastructure does not comply")
-	}
-	return nil
+	return entry, nil
 }
 
 // AddValidQuote adds a valid quote
@@ -72,3 +107,9 @@ func (m *MockIssuer) Issue(message []byte) ([]byte, error) {
 	quote := sha256.Sum256(message)
 	return quote[:], nil
 }
+
+// IssueWithNonce implements the NonceIssuer interface
+func (m *MockIssuer) IssueWithNonce(message []byte, nonce []byte) ([]byte, error) {
+	quote := sha256.Sum256(NonceReportData(message, nonce))
+	return quote[:], nil
+}
@@ -0,0 +1,39 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+)
+
+// MaxNonceSize is the maximum size of a nonce a client may bind a quote to
+const MaxNonceSize = 64
+
+// NonceReportData returns the report data of a quote that binds both a certificate and a client's nonce.
+// The first half is the certificate's hash as for quotes without a nonce, so verifiers that only check the certificate keep working.
+func NonceReportData(cert []byte, nonce []byte) []byte {
+	certHash := sha256.Sum256(cert)
+	nonceHash := sha256.Sum256(nonce)
+	return append(certHash[:], nonceHash[:]...)
+}
+
+// CheckNonceReportData checks that the report data of a quote binds the given certificate and nonce
+func CheckNonceReportData(reportData []byte, cert []byte, nonce []byte) error {
+	expected := NonceReportData(cert, nonce)
+	if len(reportData) < len(expected) {
+		return errors.New("report data is too short to contain a nonce")
+	}
+	if !bytes.Equal(reportData[:sha256.Size], expected[:sha256.Size]) {
+		return errors.New("report data does not match the certificate's hash")
+	}
+	if !bytes.Equal(reportData[sha256.Size:len(expected)], expected[sha256.Size:]) {
+		return errors.New("report data does not match the nonce's hash")
+	}
+	return nil
+}
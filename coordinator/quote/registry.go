@@ -0,0 +1,183 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Format is the type tag of an attestation format
+type Format string
+
+const (
+	// FormatERT is the format of quotes issued by EdgelessRT
+	FormatERT Format = "ert"
+	// FormatSignedReport is the format of reports signed by a key certified by a trusted CA
+	FormatSignedReport Format = "signed-report"
+	// FormatAttestationKey is the format of reports signed by a software attestation key. It is meant for testing only.
+	FormatAttestationKey Format = "attestation-key"
+)
+
+// quoteMagic prefixes quotes that carry a format tag
+var quoteMagic = []byte("MRQ\x00")
+
+// EncodeQuote prefixes a quote with the tag of its format
+func EncodeQuote(format Format, payload []byte) []byte {
+	result := make([]byte, 0, len(quoteMagic)+1+len(format)+len(payload))
+	result = append(result, quoteMagic...)
+	result = append(result, byte(len(format)))
+	result = append(result, format...)
+	return append(result, payload...)
+}
+
+// DecodeQuote splits a quote into its format tag and payload. Untagged quotes are returned with an empty format.
+func DecodeQuote(quote []byte) (Format, []byte, error) {
+	if !bytes.HasPrefix(quote, quoteMagic) {
+		return "", quote, nil
+	}
+	rest := quote[len(quoteMagic):]
+	if len(rest) == 0 || int(rest[0]) > len(rest)-1 {
+		return "", nil, errors.New("quote has a malformed format tag")
+	}
+	tagLength := int(rest[0])
+	return Format(rest[1 : 1+tagLength]), rest[1+tagLength:], nil
+}
+
+// Registry is a Validator that dispatches quotes to the backend registered for their format tag.
+// Untagged quotes are passed to the default validator, so quotes of existing issuers keep working.
+type Registry struct {
+	mutex            sync.RWMutex
+	validators       map[Format]Validator
+	defaultValidator Validator
+}
+
+// NewRegistry returns a new Registry object that passes untagged quotes to defaultValidator
+func NewRegistry(defaultValidator Validator) *Registry {
+	return &Registry{
+		validators:       make(map[Format]Validator),
+		defaultValidator: defaultValidator,
+	}
+}
+
+// Register registers the validator for quotes of the given format
+func (r *Registry) Register(format Format, validator Validator) {
+	r.mutex.Lock()
+	r.validators[format] = validator
+	r.mutex.Unlock()
+}
+
+// Validate implements the Validator interface
+func (r *Registry) Validate(quote []byte, cert []byte, pp PackageProperties, ip InfrastructureProperties) error {
+	validator, payload, err := r.lookup(quote)
+	if err != nil {
+		return err
+	}
+	return validator.Validate(payload, cert, pp, ip)
+}
+
+// ValidateWithNonce implements the NonceValidator interface if the backend of the quote's format does
+func (r *Registry) ValidateWithNonce(quote []byte, cert []byte, nonce []byte, pp PackageProperties) error {
+	validator, payload, err := r.lookup(quote)
+	if err != nil {
+		return err
+	}
+	nonceValidator, ok := validator.(NonceValidator)
+	if !ok {
+		return errors.New("attestation format does not support nonces")
+	}
+	return nonceValidator.ValidateWithNonce(payload, cert, nonce, pp)
+}
+
+// ValidateAndReport implements the InfrastructureReporter interface.
+// It returns ErrInfrastructureNotReported if the backend of the quote's format is not an InfrastructureReporter.
+func (r *Registry) ValidateAndReport(quote []byte, cert []byte, pp PackageProperties) (InfrastructureProperties, error) {
+	validator, payload, err := r.lookup(quote)
+	if err != nil {
+		return InfrastructureProperties{}, err
+	}
+	reporter, ok := validator.(InfrastructureReporter)
+	if !ok {
+		return InfrastructureProperties{}, ErrInfrastructureNotReported
+	}
+	return reporter.ValidateAndReport(payload, cert, pp)
+}
+
+// PlatformID implements the PlatformIdentifier interface if the backend of the quote's format does.
+// The identifier is prefixed with the format, so platforms of different formats are never confused.
+func (r *Registry) PlatformID(quote []byte) ([]byte, error) {
+	validator, payload, err := r.lookup(quote)
+	if err != nil {
+		return nil, err
+	}
+	identifier, ok := validator.(PlatformIdentifier)
+	if !ok {
+		return nil, errors.New("attestation format does not identify the platform")
+	}
+	id, err := identifier.PlatformID(payload)
+	if err != nil {
+		return nil, err
+	}
+	format, _, _ := DecodeQuote(quote)
+	return append([]byte(format+"\x00"), id...), nil
+}
+
+func (r *Registry) lookup(quote []byte) (Validator, []byte, error) {
+	format, payload, err := DecodeQuote(quote)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format == "" {
+		if r.defaultValidator == nil {
+			return nil, nil, errors.New("untagged quotes are not supported")
+		}
+		return r.defaultValidator, payload, nil
+	}
+
+	r.mutex.RLock()
+	validator, ok := r.validators[format]
+	r.mutex.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported attestation format: %s", format)
+	}
+	return validator, payload, nil
+}
+
+// TaggedIssuer wraps an Issuer and tags its quotes with their format
+type TaggedIssuer struct {
+	format Format
+	issuer Issuer
+}
+
+// NewTaggedIssuer returns a new TaggedIssuer object
+func NewTaggedIssuer(format Format, issuer Issuer) *TaggedIssuer {
+	return &TaggedIssuer{format: format, issuer: issuer}
+}
+
+// Issue implements the Issuer interface
+func (t *TaggedIssuer) Issue(cert []byte) ([]byte, error) {
+	payload, err := t.issuer.Issue(cert)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeQuote(t.format, payload), nil
+}
+
+// IssueWithNonce implements the NonceIssuer interface if the wrapped Issuer does
+func (t *TaggedIssuer) IssueWithNonce(cert []byte, nonce []byte) ([]byte, error) {
+	nonceIssuer, ok := t.issuer.(NonceIssuer)
+	if !ok {
+		return nil, fmt.Errorf("issuer of format %s does not support nonces", t.format)
+	}
+	payload, err := nonceIssuer.IssueWithNonce(cert, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeQuote(t.format, payload), nil
+}
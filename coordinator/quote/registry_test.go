@@ -0,0 +1,149 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeQuote(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	format, payload, err := DecodeQuote(EncodeQuote(FormatSignedReport, []byte("payload")))
+	require.NoError(err)
+	assert.Equal(FormatSignedReport, format)
+	assert.Equal([]byte("payload"), payload)
+
+	// untagged quotes are passed through
+	format, payload, err = DecodeQuote([]byte("untagged"))
+	require.NoError(err)
+	assert.Empty(format)
+	assert.Equal([]byte("untagged"), payload)
+
+	// tag length exceeds the quote
+	_, _, err = DecodeQuote(append(append([]byte{}, quoteMagic...), 42, 'a'))
+	assert.Error(err)
+}
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	productID := uint64(44)
+	securityVersion := uint(3)
+	pp := PackageProperties{SignerID: "signer", ProductID: &productID, SecurityVersion: &securityVersion}
+	properties := Report{SignerID: "signer", ProductID: productID, SecurityVersion: securityVersion}
+	cert := []byte("cert")
+
+	// software attestation key
+	attestationPub, attestationPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	attestationKeyIssuer := NewTaggedIssuer(FormatAttestationKey, NewAttestationKeyIssuer(attestationPriv, properties))
+
+	// report signer certified by a CA
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	rootCert := createCertificate(t, &rootKey.PublicKey, rootKey, nil)
+	signerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	signerCert := createCertificate(t, &signerKey.PublicKey, rootKey, rootCert)
+	signedReportIssuer := NewTaggedIssuer(FormatSignedReport, NewSignedReportIssuer(signerKey, []*x509.Certificate{signerCert}, properties))
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCert)
+
+	// untagged quotes are passed to the default validator
+	mockValidator := NewMockValidator()
+	mockQuote, err := NewMockIssuer().Issue(cert)
+	require.NoError(err)
+	mockValidator.AddValidQuote(mockQuote, cert, pp, InfrastructureProperties{})
+
+	registry := NewRegistry(mockValidator)
+	registry.Register(FormatAttestationKey, NewAttestationKeyValidator(attestationPub))
+	registry.Register(FormatSignedReport, NewSignedReportValidator(roots))
+
+	// a mixed fleet is validated with the same package properties
+	attestationKeyQuote, err := attestationKeyIssuer.Issue(cert)
+	require.NoError(err)
+	signedReportQuote, err := signedReportIssuer.Issue(cert)
+	require.NoError(err)
+	for _, quote := range [][]byte{mockQuote, attestationKeyQuote, signedReportQuote} {
+		assert.NoError(registry.Validate(quote, cert, pp, InfrastructureProperties{}))
+
+		// wrong message
+		assert.Error(registry.Validate(quote, []byte("other cert"), pp, InfrastructureProperties{}))
+
+		// package properties do not comply
+		newerVersion := securityVersion + 1
+		assert.Error(registry.Validate(quote, cert, PackageProperties{SignerID: "signer", SecurityVersion: &newerVersion}, InfrastructureProperties{}))
+	}
+
+	// infrastructure requirements cannot be met by reports that do not cover the infrastructure
+	qeSVN := uint16(2)
+	for _, quote := range [][]byte{attestationKeyQuote, signedReportQuote} {
+		assert.Error(registry.Validate(quote, cert, pp, InfrastructureProperties{QESVN: &qeSVN}))
+		assert.Error(registry.Validate(quote, cert, pp, InfrastructureProperties{RootCA: rootCert.Raw}))
+	}
+
+	// untrusted attestation key
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	untrustedQuote, err := NewTaggedIssuer(FormatAttestationKey, NewAttestationKeyIssuer(otherPriv, properties)).Issue(cert)
+	require.NoError(err)
+	assert.Error(registry.Validate(untrustedQuote, cert, pp, InfrastructureProperties{}))
+
+	// signer not certified by a trusted root
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	selfSignedCert := createCertificate(t, &otherKey.PublicKey, otherKey, nil)
+	untrustedQuote, err = NewTaggedIssuer(FormatSignedReport, NewSignedReportIssuer(otherKey, []*x509.Certificate{selfSignedCert}, properties)).Issue(cert)
+	require.NoError(err)
+	assert.Error(registry.Validate(untrustedQuote, cert, pp, InfrastructureProperties{}))
+
+	// unregistered format
+	assert.Error(registry.Validate(EncodeQuote("unknown", mockQuote), cert, pp, InfrastructureProperties{}))
+
+	// platforms are identified by the backend of the quote's format
+	platformID, err := registry.PlatformID(mockQuote)
+	require.NoError(err)
+	assert.NotEmpty(platformID)
+	_, err = registry.PlatformID(signedReportQuote)
+	assert.Error(err)
+}
+
+// createCertificate creates a certificate for pub signed by priv. A nil parent creates a self-signed CA certificate.
+func createCertificate(t *testing.T, pub *ecdsa.PublicKey, priv *ecdsa.PrivateKey, parent *x509.Certificate) *x509.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "report signer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	if parent == nil {
+		template.Subject.CommonName = "report root"
+		template.IsCA = true
+		parent = template
+	}
+	rawCert, err := x509.CreateCertificate(rand.Reader, template, parent, pub, priv)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(rawCert)
+	require.NoError(t, err)
+	return cert
+}
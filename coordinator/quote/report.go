@@ -0,0 +1,112 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Report is the statement attested by the signed-report and attestation-key formats.
+// It carries the same properties as an SGX report, so the PackageProperties matching rules apply to all formats alike.
+type Report struct {
+	// SHA-256 hash of the message the report is bound to. Issuers set it when issuing a quote.
+	Data []byte
+	// Hash of the enclave
+	UniqueID string
+	// Hash of the enclave signer's public key
+	SignerID string
+	// Product ID of the package
+	ProductID uint64
+	// Security version number of the package
+	SecurityVersion uint
+	// Debug Flag of the Attributes
+	Debug bool
+}
+
+// marshalReport binds a copy of the report to the given message and encodes it
+func marshalReport(report Report, message []byte) ([]byte, error) {
+	report.Data = messageHash(message)
+	return json.Marshal(report)
+}
+
+// marshalNonceReport binds a copy of the report to the given message and nonce and encodes it
+func marshalNonceReport(report Report, message []byte, nonce []byte) ([]byte, error) {
+	report.Data = NonceReportData(message, nonce)
+	return json.Marshal(report)
+}
+
+// verifyReport checks that an encoded report carries the expected data and complies with the package properties.
+// The data is the message's hash for quotes issued by Issue and NonceReportData for quotes issued by IssueWithNonce.
+func verifyReport(rawReport []byte, data []byte, pp PackageProperties) error {
+	var report Report
+	if err := json.Unmarshal(rawReport, &report); err != nil {
+		return fmt.Errorf("parsing report failed: %v", err)
+	}
+
+	if !bytes.Equal(report.Data, data) {
+		return fmt.Errorf("unexpected report.Data: %v != %v", data, report.Data)
+	}
+
+	reportedProps := PackageProperties{
+		UniqueID:        report.UniqueID,
+		SignerID:        report.SignerID,
+		Debug:           report.Debug,
+		ProductID:       &report.ProductID,
+		SecurityVersion: &report.SecurityVersion,
+	}
+	if !pp.IsCompliant(reportedProps) {
+		return fmt.Errorf("PackageProperties not compliant:\n%v\n%v", reportedProps, pp)
+	}
+	return nil
+}
+
+// requireNoInfrastructure rejects infrastructure requirements, which reports of the given format cannot satisfy because they do not cover the infrastructure
+func requireNoInfrastructure(format Format, ip InfrastructureProperties) error {
+	if len(ip.CPUSVN) > 0 || ip.QESVN != nil || ip.PCESVN != nil || len(ip.RootCA) > 0 {
+		return fmt.Errorf("infrastructure properties are not covered by the %s format", format)
+	}
+	return nil
+}
+
+// messageHash returns the report data of a quote bound to message only
+func messageHash(message []byte) []byte {
+	hash := sha256.Sum256(message)
+	return hash[:]
+}
+
+// signReport signs an encoded report. Ed25519 keys sign the report itself, all other keys sign its SHA-256 hash.
+func signReport(key crypto.Signer, rawReport []byte) ([]byte, error) {
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		return key.Sign(rand.Reader, rawReport, crypto.Hash(0))
+	}
+	hash := sha256.Sum256(rawReport)
+	return key.Sign(rand.Reader, hash[:], crypto.SHA256)
+}
+
+// signatureAlgorithm returns the algorithm signReport uses for the given public key
+func signatureAlgorithm(pub crypto.PublicKey) (x509.SignatureAlgorithm, error) {
+	switch pub.(type) {
+	case *ecdsa.PublicKey:
+		return x509.ECDSAWithSHA256, nil
+	case *rsa.PublicKey:
+		return x509.SHA256WithRSA, nil
+	case ed25519.PublicKey:
+		return x509.PureEd25519, nil
+	default:
+		return x509.UnknownSignatureAlgorithm, errors.New("unsupported public key type")
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package quote
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// signedReport is the payload of a quote in the signed-report format
+type signedReport struct {
+	// JSON-encoded Report
+	Report []byte
+	// Signature over Report
+	Signature []byte
+	// DER-encoded certificate chain of the signing key, leaf first
+	Certificates [][]byte
+}
+
+// SignedReportValidator validates reports signed by a key that is certified by one of the trusted roots
+type SignedReportValidator struct {
+	roots *x509.CertPool
+}
+
+// NewSignedReportValidator returns a new SignedReportValidator object
+func NewSignedReportValidator(roots *x509.CertPool) *SignedReportValidator {
+	return &SignedReportValidator{roots: roots}
+}
+
+// Validate implements the Validator interface for SignedReportValidator
+func (v *SignedReportValidator) Validate(quote []byte, cert []byte, pp PackageProperties, ip InfrastructureProperties) error {
+	if err := requireNoInfrastructure(FormatSignedReport, ip); err != nil {
+		return err
+	}
+	return v.validate(quote, messageHash(cert), pp)
+}
+
+// ValidateWithNonce implements the NonceValidator interface for SignedReportValidator
+func (v *SignedReportValidator) ValidateWithNonce(quote []byte, cert []byte, nonce []byte, pp PackageProperties) error {
+	return v.validate(quote, NonceReportData(cert, nonce), pp)
+}
+
+func (v *SignedReportValidator) validate(quote []byte, data []byte, pp PackageProperties) error {
+	var report signedReport
+	if err := json.Unmarshal(quote, &report); err != nil {
+		return fmt.Errorf("parsing signed report failed: %v", err)
+	}
+	if len(report.Certificates) == 0 {
+		return errors.New("signed report does not contain a certificate")
+	}
+
+	// Verify the certificate chain of the signing key
+	leaf, err := x509.ParseCertificate(report.Certificates[0])
+	if err != nil {
+		return err
+	}
+	intermediates := x509.NewCertPool()
+	for _, rawCert := range report.Certificates[1:] {
+		intermediate, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return err
+		}
+		intermediates.AddCert(intermediate)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}
+	if _, err := leaf.Verify(opts); err != nil {
+		return fmt.Errorf("verifying report signer failed: %v", err)
+	}
+
+	// Verify the signature over the report
+	algorithm, err := signatureAlgorithm(leaf.PublicKey)
+	if err != nil {
+		return err
+	}
+	if err := leaf.CheckSignature(algorithm, report.Report, report.Signature); err != nil {
+		return fmt.Errorf("verifying report signature failed: %v", err)
+	}
+
+	return verifyReport(report.Report, data, pp)
+}
+
+// SignedReportIssuer issues reports signed by a certified key
+type SignedReportIssuer struct {
+	key        crypto.Signer
+	chain      [][]byte
+	properties Report
+}
+
+// NewSignedReportIssuer returns a new SignedReportIssuer object.
+// chain is the certificate chain of key, leaf first. properties is the report to issue, its Data is set for every quote.
+func NewSignedReportIssuer(key crypto.Signer, chain []*x509.Certificate, properties Report) *SignedReportIssuer {
+	rawChain := make([][]byte, 0, len(chain))
+	for _, cert := range chain {
+		rawChain = append(rawChain, cert.Raw)
+	}
+	return &SignedReportIssuer{key: key, chain: rawChain, properties: properties}
+}
+
+// Issue implements the Issuer interface
+func (i *SignedReportIssuer) Issue(cert []byte) ([]byte, error) {
+	rawReport, err := marshalReport(i.properties, cert)
+	if err != nil {
+		return nil, err
+	}
+	return i.sign(rawReport)
+}
+
+// IssueWithNonce implements the NonceIssuer interface
+func (i *SignedReportIssuer) IssueWithNonce(cert []byte, nonce []byte) ([]byte, error) {
+	rawReport, err := marshalNonceReport(i.properties, cert, nonce)
+	if err != nil {
+		return nil, err
+	}
+	return i.sign(rawReport)
+}
+
+func (i *SignedReportIssuer) sign(rawReport []byte) ([]byte, error) {
+	signature, err := signReport(i.key, rawReport)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(signedReport{Report: rawReport, Signature: signature, Certificates: i.chain})
+}
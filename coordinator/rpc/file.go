@@ -0,0 +1,141 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package rpc
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Encodings of a File's content
+const (
+	FileEncodingBase64 = "base64"
+	FileEncodingHex    = "hex"
+)
+
+// Default permissions of structured files and directories
+const (
+	DefaultFileMode      os.FileMode = 0600
+	DefaultDirectoryMode os.FileMode = 0700
+)
+
+// fileJSON is the representation of a File in the manifest. Content is a string and Mode is an octal string, e.g., "0644".
+type fileJSON struct {
+	Content   string `json:",omitempty"`
+	Encoding  string `json:",omitempty"`
+	Mode      string `json:",omitempty"`
+	Owner     string `json:",omitempty"`
+	Directory bool   `json:",omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (x *File) MarshalJSON() ([]byte, error) {
+	f := fileJSON{Content: string(x.Content), Encoding: x.Encoding, Owner: x.Owner, Directory: x.Directory}
+	if x.Mode != 0 {
+		f.Mode = fmt.Sprintf("%04o", x.Mode)
+	}
+	return json.Marshal(f)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (x *File) UnmarshalJSON(data []byte) error {
+	var f fileJSON
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	var mode uint64
+	if f.Mode != "" {
+		var err error
+		if mode, err = strconv.ParseUint(f.Mode, 8, 32); err != nil {
+			return fmt.Errorf("invalid file mode %v: must be an octal number", f.Mode)
+		}
+	}
+	x.Content = []byte(f.Content)
+	x.Encoding = f.Encoding
+	x.Mode = uint32(mode)
+	x.Owner = f.Owner
+	x.Directory = f.Directory
+	return nil
+}
+
+// Check checks if the file's attributes are valid.
+func (x *File) Check() error {
+	switch x.Encoding {
+	case "", FileEncodingBase64, FileEncodingHex:
+	default:
+		return fmt.Errorf("unsupported encoding %v", x.Encoding)
+	}
+	if x.Mode > 07777 {
+		return fmt.Errorf("invalid mode %04o", x.Mode)
+	}
+	if _, _, err := x.ParseOwner(); err != nil {
+		return err
+	}
+	if x.Directory && len(x.Content) > 0 {
+		return errors.New("a directory must not have content")
+	}
+	return nil
+}
+
+// DecodeContent returns the decoded content of the file.
+func (x *File) DecodeContent() ([]byte, error) {
+	switch x.Encoding {
+	case "":
+		return x.Content, nil
+	case FileEncodingBase64:
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(x.Content)))
+	case FileEncodingHex:
+		return hex.DecodeString(strings.TrimSpace(string(x.Content)))
+	}
+	return nil, fmt.Errorf("unsupported encoding %v", x.Encoding)
+}
+
+// FileMode returns the permissions of the file or directory, applying the defaults if unset.
+func (x *File) FileMode() os.FileMode {
+	if x.Mode != 0 {
+		// os.FileMode uses other bits than the octal notation for setuid, setgid and sticky
+		mode := os.FileMode(x.Mode) & os.ModePerm
+		if x.Mode&04000 != 0 {
+			mode |= os.ModeSetuid
+		}
+		if x.Mode&02000 != 0 {
+			mode |= os.ModeSetgid
+		}
+		if x.Mode&01000 != 0 {
+			mode |= os.ModeSticky
+		}
+		return mode
+	}
+	if x.Directory {
+		return DefaultDirectoryMode
+	}
+	return DefaultFileMode
+}
+
+// ParseOwner returns the numeric user and group ID of the owner. Both are -1 if no owner is set.
+func (x *File) ParseOwner() (uid int, gid int, err error) {
+	if x.Owner == "" {
+		return -1, -1, nil
+	}
+	parts := strings.Split(x.Owner, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid owner %v: expected uid:gid", x.Owner)
+	}
+	if uid, err = strconv.Atoi(parts[0]); err != nil || uid < 0 {
+		return 0, 0, fmt.Errorf("invalid owner %v: expected a numeric uid", x.Owner)
+	}
+	if gid, err = strconv.Atoi(parts[1]); err != nil || gid < 0 {
+		return 0, 0, fmt.Errorf("invalid owner %v: expected a numeric gid", x.Owner)
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package rpc
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Check checks if the allowlist's patterns are valid regular expressions.
+func (x *HostAllowlist) Check() error {
+	for name, pattern := range x.Env {
+		if name == "" || strings.Contains(name, "=") {
+			return fmt.Errorf("invalid environment variable name %q", name)
+		}
+		if _, err := compileFullMatch(pattern); err != nil {
+			return fmt.Errorf("invalid pattern for environment variable %v: %v", name, err)
+		}
+	}
+	for _, pattern := range x.Args {
+		if _, err := compileFullMatch(pattern); err != nil {
+			return fmt.Errorf("invalid pattern for arguments: %v", err)
+		}
+	}
+	return nil
+}
+
+// FilterEnv splits the environment, given as "key=value" strings, into the allowed variables and the names of the dropped variables.
+func (x *HostAllowlist) FilterEnv(environ []string) (allowed map[string]string, dropped []string, err error) {
+	allowed = make(map[string]string)
+	for _, keyValue := range environ {
+		parts := strings.SplitN(keyValue, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name, value := parts[0], parts[1]
+		pattern, ok := x.GetEnv()[name]
+		if !ok {
+			dropped = append(dropped, name)
+			continue
+		}
+		re, err := compileFullMatch(pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !re.MatchString(value) {
+			dropped = append(dropped, name)
+			continue
+		}
+		allowed[name] = value
+	}
+	sort.Strings(dropped)
+	return allowed, dropped, nil
+}
+
+// FilterArgs returns the arguments that match one of the allowed patterns and the number of dropped arguments.
+func (x *HostAllowlist) FilterArgs(args []string) (allowed []string, dropped int, err error) {
+	var patterns []*regexp.Regexp
+	for _, pattern := range x.GetArgs() {
+		re, err := compileFullMatch(pattern)
+		if err != nil {
+			return nil, 0, err
+		}
+		patterns = append(patterns, re)
+	}
+
+	for _, arg := range args {
+		if matchesAny(patterns, arg) {
+			allowed = append(allowed, arg)
+		} else {
+			dropped++
+		}
+	}
+	return allowed, dropped, nil
+}
+
+// compileFullMatch compiles a pattern that must match the whole string. An empty pattern matches everything.
+func compileFullMatch(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		pattern = ".*"
+	}
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
@@ -12,6 +12,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -19,6 +20,8 @@ import (
 	"os"
 
 	"github.com/edgelesssys/marblerun/coordinator/core"
+	"github.com/edgelesssys/marblerun/coordinator/manifest"
+	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/edgelesssys/marblerun/coordinator/rpc"
 	"github.com/gorilla/handlers"
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
@@ -32,15 +35,30 @@ import (
 )
 
 type certQuoteResp struct {
-	Cert  string
-	Quote []byte
+	Cert           string
+	Quote          []byte
+	SimulationMode bool
+	// PEM-encoded certificates of the external CAs that issued the root certificate, if any
+	RootChain string
 }
 type statusResp struct {
-	Code   int
-	Status string
+	Code           int
+	Status         string
+	SimulationMode bool
+	RootCA         core.CertificateProfile
+	IntermediateCA core.CertificateProfile
+}
+type statusDetailsResp struct {
+	IntermediateRotation *core.IntermediateRotation   `json:",omitempty"`
+	Secrets              map[string]core.SecretStatus `json:",omitempty"`
+	PackageActivations   map[string]map[string]uint   `json:",omitempty"`
+}
+type csrResp struct {
+	CSR string
 }
 type manifestSignatureResp struct {
-	ManifestSignature string
+	ManifestSignature    string
+	RawManifestSignature string
 }
 
 // Contains RSA-encrypted AES state sealing key with public key specified by user in manifest
@@ -105,7 +123,24 @@ func CreateServeMux(cc core.ClientCore) *http.ServeMux {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			writeJSON(w, statusResp{statusCode, status})
+			rootProfile, intermediateProfile := cc.GetCAProfiles(r.Context())
+			writeJSON(w, statusResp{statusCode, status, cc.InSimulationMode(r.Context()), rootProfile, intermediateProfile})
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// The details reveal the secrets, the rotation state and the activations of the deployment, so only admins can get them
+	mux.HandleFunc("/status/details", func(w http.ResponseWriter, r *http.Request) {
+		// Abort if no admin client certificate was provided
+		if r.TLS == nil || !cc.VerifyAdmin(r.Context(), r.TLS.PeerCertificates) {
+			http.Error(w, "unauthorized user", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, statusDetailsResp{cc.GetIntermediateRotation(r.Context()), cc.GetSecretStatus(r.Context()), cc.GetPackageActivations(r.Context())})
 		default:
 			http.Error(w, "", http.StatusMethodNotAllowed)
 		}
@@ -115,7 +150,8 @@ func CreateServeMux(cc core.ClientCore) *http.ServeMux {
 		switch r.Method {
 		case http.MethodGet:
 			signature := cc.GetManifestSignature(r.Context())
-			writeJSON(w, manifestSignatureResp{hex.EncodeToString(signature)})
+			rawSignature := cc.GetRawManifestSignature(r.Context())
+			writeJSON(w, manifestSignatureResp{hex.EncodeToString(signature), hex.EncodeToString(rawSignature)})
 		case http.MethodPost:
 			manifest, err := ioutil.ReadAll(r.Body)
 			if err != nil {
@@ -143,15 +179,83 @@ func CreateServeMux(cc core.ClientCore) *http.ServeMux {
 		}
 	})
 
+	// The manifest may reveal details of the deployment, e.g., the parameters of the Marbles, so only admins can get it
+	mux.HandleFunc("/manifest/raw", func(w http.ResponseWriter, r *http.Request) {
+		// Abort if no admin client certificate was provided
+		if r.TLS == nil || !cc.VerifyAdmin(r.Context(), r.TLS.PeerCertificates) {
+			http.Error(w, "unauthorized user", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			rawManifest, err := cc.GetManifest(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			if manifest.DetectFormat(rawManifest) == manifest.FormatYAML {
+				w.Header().Set("Content-Type", "application/yaml")
+			} else {
+				w.Header().Set("Content-Type", "application/json")
+			}
+			w.Write(rawManifest)
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+	})
+
 	mux.HandleFunc("/quote", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			cert, quote, err := cc.GetCertQuote(r.Context())
+			// an optional hex-encoded nonce binds the quote to the client's request
+			nonce, err := hex.DecodeString(r.URL.Query().Get("nonce"))
+			if err != nil {
+				http.Error(w, "nonce must be hex-encoded", http.StatusBadRequest)
+				return
+			}
+			if len(nonce) > quote.MaxNonceSize {
+				http.Error(w, fmt.Sprintf("nonce exceeds %d bytes", quote.MaxNonceSize), http.StatusBadRequest)
+				return
+			}
+			cert, certQuote, err := cc.GetCertQuote(r.Context(), nonce)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			writeJSON(w, certQuoteResp{cert, quote})
+			writeJSON(w, certQuoteResp{cert, certQuote, cc.InSimulationMode(r.Context()), cc.GetRootChain(r.Context())})
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+	})
+
+	// An external CA can sign the Coordinator's root key before the manifest is set
+	mux.HandleFunc("/csr", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			csr, err := cc.GetRootCSR(r.Context())
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writeJSON(w, csrResp{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))})
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/chain", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			chain, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if err := cc.SetRootCertificateChain(r.Context(), chain); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 		default:
 			http.Error(w, "", http.StatusMethodNotAllowed)
 		}
@@ -213,6 +317,24 @@ func CreateServeMux(cc core.ClientCore) *http.ServeMux {
 		}
 	})
 
+	mux.HandleFunc("/rotate", func(w http.ResponseWriter, r *http.Request) {
+		// Abort if no admin client certificate was provided
+		if r.TLS == nil || !cc.VerifyAdmin(r.Context(), r.TLS.PeerCertificates) {
+			http.Error(w, "unauthorized user", http.StatusUnauthorized)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPost:
+			if err := cc.RotateIntermediate(r.Context()); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "", http.StatusMethodNotAllowed)
+		}
+	})
+
 	return mux
 }
 
@@ -13,6 +13,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -35,6 +36,68 @@ func TestQuote(t *testing.T) {
 	resp := httptest.NewRecorder()
 	mux.ServeHTTP(resp, req)
 	assert.Equal(http.StatusOK, resp.Code)
+
+	// with nonce
+	req = httptest.NewRequest(http.MethodGet, "/quote?nonce="+hex.EncodeToString([]byte("nonce")), nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusOK, resp.Code)
+
+	// malformed nonce
+	req = httptest.NewRequest(http.MethodGet, "/quote?nonce=xyz", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+
+	// nonce too long
+	req = httptest.NewRequest(http.MethodGet, "/quote?nonce="+strings.Repeat("00", 65), nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
+}
+
+func TestStatus(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+
+	var status statusResp
+	require.NoError(json.Unmarshal(resp.Body.Bytes(), &status))
+	assert.False(status.SimulationMode)
+	assert.Equal("Marblerun Coordinator", status.RootCA.CommonName)
+	assert.Equal(core.KeyAlgorithmECDSAP256, status.RootCA.KeyAlgorithm)
+	assert.Equal("Marblerun Coordinator - Intermediate CA", status.IntermediateCA.CommonName)
+}
+
+func TestRootCSR(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := CreateServeMux(core.NewCoreWithMocks())
+
+	req := httptest.NewRequest(http.MethodGet, "/csr", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+
+	var csr csrResp
+	require.NoError(json.Unmarshal(resp.Body.Bytes(), &csr))
+	block, _ := pem.Decode([]byte(csr.CSR))
+	require.NotNil(block)
+	_, err := x509.ParseCertificateRequest(block.Bytes)
+	assert.NoError(err)
+
+	// the chain must be issued for the Coordinator's root key
+	req = httptest.NewRequest(http.MethodPost, "/chain", strings.NewReader("invalid"))
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusBadRequest, resp.Code)
 }
 
 func TestManifest(t *testing.T) {
@@ -57,7 +120,8 @@ func TestManifest(t *testing.T) {
 	require.Equal(http.StatusOK, resp.Code)
 
 	sig := hex.EncodeToString(c.GetManifestSignature(context.TODO()))
-	assert.JSONEq(`{"ManifestSignature":"`+sig+`"}`, resp.Body.String())
+	rawSig := hex.EncodeToString(c.GetRawManifestSignature(context.TODO()))
+	assert.JSONEq(`{"ManifestSignature":"`+sig+`","RawManifestSignature":"`+rawSig+`"}`, resp.Body.String())
 
 	// try setting manifest again, should fail
 	req = httptest.NewRequest(http.MethodPost, "/manifest", strings.NewReader(test.ManifestJSON))
@@ -96,6 +160,34 @@ func TestManifestWithRecoveryKey(t *testing.T) {
 	require.NotNil(recoveryData)
 }
 
+func TestManifestRaw(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := core.NewCoreWithMocks()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSONWithRecoveryKey))
+	require.NoError(err)
+	mux := CreateServeMux(c)
+
+	// getting the manifest requires an admin certificate
+	req := httptest.NewRequest(http.MethodGet, "/manifest/raw", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+
+	adminTestCert, otherTestCert := test.MustSetupTestCerts(test.RecoveryPrivateKey)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{otherTestCert}}
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{adminTestCert}}
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	assert.Equal(test.ManifestJSONWithRecoveryKey, resp.Body.String())
+}
+
 func TestUpdate(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -131,6 +223,50 @@ func TestUpdate(t *testing.T) {
 	assert.Equal(http.StatusOK, resp.Code)
 }
 
+func TestRotate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := core.NewCoreWithMocks()
+	_, err := c.SetManifest(context.TODO(), []byte(test.ManifestJSONWithRecoveryKey))
+	require.NoError(err)
+	mux := CreateServeMux(c)
+
+	// rotation requires an admin certificate
+	req := httptest.NewRequest(http.MethodPost, "/rotate", nil)
+	resp := httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+
+	adminTestCert, _ := test.MustSetupTestCerts(test.RecoveryPrivateKey)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{adminTestCert}}
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusOK, resp.Code)
+
+	// the rotation is shown in the status details, which require an admin certificate
+	req = httptest.NewRequest(http.MethodGet, "/status/details", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	assert.Equal(http.StatusUnauthorized, resp.Code)
+
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{adminTestCert}}
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	var details statusDetailsResp
+	require.NoError(json.Unmarshal(resp.Body.Bytes(), &details))
+	assert.NotNil(details.IntermediateRotation)
+
+	// the public status does not contain the details
+	req = httptest.NewRequest(http.MethodGet, "/status", nil)
+	resp = httptest.NewRecorder()
+	mux.ServeHTTP(resp, req)
+	require.Equal(http.StatusOK, resp.Code)
+	assert.NotContains(resp.Body.String(), "IntermediateRotation")
+	assert.NotContains(resp.Body.String(), "Secrets")
+}
+
 func TestConcurrent(t *testing.T) {
 	// This test is used to detect data races when run with -race
 
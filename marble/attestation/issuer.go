@@ -0,0 +1,123 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package attestation provides the quote issuer of a Marble as configured by the environment.
+package attestation
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/ertvalidator"
+	"github.com/edgelesssys/marblerun/marble/config"
+)
+
+// NewIssuer returns the issuer of the attestation format selected by the environment.
+// EdgelessRT quotes are not tagged, so they are also accepted by Coordinators that do not know the other formats.
+func NewIssuer() (quote.Issuer, error) {
+	switch format := quote.Format(os.Getenv(config.QuoteFormat)); format {
+	case "", quote.FormatERT:
+		return ertvalidator.NewERTIssuer(), nil
+	case quote.FormatSignedReport:
+		key, report, err := loadSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		chain, err := parseCertificates(os.Getenv(config.QuoteCertificates))
+		if err != nil {
+			return nil, err
+		}
+		if !publicKeyEqual(chain[0].PublicKey, key.Public()) {
+			return nil, fmt.Errorf("the first certificate in %v does not certify %v", config.QuoteCertificates, config.QuoteKey)
+		}
+		return quote.NewTaggedIssuer(format, quote.NewSignedReportIssuer(key, chain, report)), nil
+	case quote.FormatAttestationKey:
+		key, report, err := loadSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		ed25519Key, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("the %v format requires an Ed25519 key", format)
+		}
+		return quote.NewTaggedIssuer(format, quote.NewAttestationKeyIssuer(ed25519Key, report)), nil
+	default:
+		return nil, fmt.Errorf("unknown %v: %v", config.QuoteFormat, format)
+	}
+}
+
+// loadSigningKey parses the key and the report properties of the signed formats
+func loadSigningKey() (crypto.Signer, quote.Report, error) {
+	block, _ := pem.Decode([]byte(os.Getenv(config.QuoteKey)))
+	if block == nil {
+		return nil, quote.Report{}, fmt.Errorf("%v does not contain a PEM-encoded private key", config.QuoteKey)
+	}
+	privk, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, quote.Report{}, fmt.Errorf("failed to parse %v: %v", config.QuoteKey, err)
+	}
+	key, ok := privk.(crypto.Signer)
+	if !ok {
+		return nil, quote.Report{}, fmt.Errorf("%v is not a signing key", config.QuoteKey)
+	}
+
+	rawReport := os.Getenv(config.QuoteReport)
+	if rawReport == "" {
+		return nil, quote.Report{}, fmt.Errorf("%v is not set", config.QuoteReport)
+	}
+	var report quote.Report
+	decoder := json.NewDecoder(strings.NewReader(rawReport))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&report); err != nil {
+		return nil, quote.Report{}, fmt.Errorf("failed to parse %v: %v", config.QuoteReport, err)
+	}
+	if len(report.Data) > 0 {
+		return nil, quote.Report{}, fmt.Errorf("%v must not set Data, which is bound to every quote", config.QuoteReport)
+	}
+	return key, report, nil
+}
+
+// parseCertificates parses a PEM-encoded certificate chain
+func parseCertificates(chainPEM string) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	rest := []byte(chainPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %v: %v", config.QuoteCertificates, err)
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("%v does not contain a certificate", config.QuoteCertificates)
+	}
+	return chain, nil
+}
+
+// publicKeyEqual compares two public keys by their PKIX encoding
+func publicKeyEqual(a, b crypto.PublicKey) bool {
+	rawA, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	rawB, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+	return string(rawA) == string(rawB)
+}
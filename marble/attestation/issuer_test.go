@@ -0,0 +1,122 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package attestation
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+
+	"github.com/edgelesssys/marblerun/coordinator/quote"
+	"github.com/edgelesssys/marblerun/coordinator/quote/ertvalidator"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIssuer(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	defer os.Unsetenv(config.QuoteFormat)
+	defer os.Unsetenv(config.QuoteKey)
+	defer os.Unsetenv(config.QuoteCertificates)
+	defer os.Unsetenv(config.QuoteReport)
+
+	productID := uint64(42)
+	pp := quote.PackageProperties{SignerID: "signer", ProductID: &productID}
+	cert := []byte("cert")
+
+	// EdgelessRT is the default
+	issuer, err := NewIssuer()
+	require.NoError(err)
+	assert.IsType(&ertvalidator.ERTIssuer{}, issuer)
+	require.NoError(os.Setenv(config.QuoteFormat, string(quote.FormatERT)))
+	issuer, err = NewIssuer()
+	require.NoError(err)
+	assert.IsType(&ertvalidator.ERTIssuer{}, issuer)
+
+	// signed reports
+	signerCert, signerKey, err := util.GenerateCert([]string{"localhost"}, nil, true)
+	require.NoError(err)
+	roots := x509.NewCertPool()
+	roots.AddCert(signerCert)
+	registry := quote.NewRegistry(quote.NewFailValidator())
+	registry.Register(quote.FormatSignedReport, quote.NewSignedReportValidator(roots))
+
+	require.NoError(os.Setenv(config.QuoteFormat, string(quote.FormatSignedReport)))
+	require.NoError(os.Setenv(config.QuoteKey, encodePrivateKey(t, signerKey)))
+	require.NoError(os.Setenv(config.QuoteCertificates, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signerCert.Raw}))))
+	require.NoError(os.Setenv(config.QuoteReport, `{"SignerID": "signer", "ProductID": 42}`))
+	issuer, err = NewIssuer()
+	require.NoError(err)
+	signedReportQuote, err := issuer.Issue(cert)
+	require.NoError(err)
+	assert.NoError(registry.Validate(signedReportQuote, cert, pp, quote.InfrastructureProperties{}))
+
+	// the certificate must certify the key
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	require.NoError(os.Setenv(config.QuoteKey, encodePrivateKey(t, otherKey)))
+	_, err = NewIssuer()
+	assert.Error(err)
+	require.NoError(os.Setenv(config.QuoteKey, encodePrivateKey(t, signerKey)))
+
+	// the certificate chain is required
+	require.NoError(os.Unsetenv(config.QuoteCertificates))
+	_, err = NewIssuer()
+	assert.Error(err)
+
+	// software attestation keys
+	attestationPub, attestationPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(err)
+	registry.Register(quote.FormatAttestationKey, quote.NewAttestationKeyValidator(attestationPub))
+
+	require.NoError(os.Setenv(config.QuoteFormat, string(quote.FormatAttestationKey)))
+	require.NoError(os.Setenv(config.QuoteKey, encodePrivateKey(t, attestationPriv)))
+	issuer, err = NewIssuer()
+	require.NoError(err)
+	attestationKeyQuote, err := issuer.Issue(cert)
+	require.NoError(err)
+	assert.NoError(registry.Validate(attestationKeyQuote, cert, pp, quote.InfrastructureProperties{}))
+
+	// the attestation-key format requires an Ed25519 key
+	require.NoError(os.Setenv(config.QuoteKey, encodePrivateKey(t, signerKey)))
+	_, err = NewIssuer()
+	assert.Error(err)
+	require.NoError(os.Setenv(config.QuoteKey, encodePrivateKey(t, attestationPriv)))
+
+	// invalid report properties
+	for _, report := range []string{"", `{"SignerID": 1}`, `{"Foo": 1}`, `{"Data": "AA=="}`} {
+		require.NoError(os.Setenv(config.QuoteReport, report))
+		_, err = NewIssuer()
+		assert.Error(err, report)
+	}
+	require.NoError(os.Setenv(config.QuoteReport, `{"SignerID": "signer"}`))
+
+	// invalid key
+	require.NoError(os.Setenv(config.QuoteKey, "not a key"))
+	_, err = NewIssuer()
+	assert.Error(err)
+
+	// unknown format
+	require.NoError(os.Setenv(config.QuoteFormat, "unknown"))
+	_, err = NewIssuer()
+	assert.Error(err)
+}
+
+func encodePrivateKey(t *testing.T, key interface{}) string {
+	rawKey, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rawKey}))
+}
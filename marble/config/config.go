@@ -18,3 +18,29 @@ const DNSNames = "EDG_MARBLE_DNS_NAMES"
 
 // UUIDFile is the file path to store the marble's uuid
 const UUIDFile = "EDG_MARBLE_UUID_FILE"
+
+// SimulationMode enables simulation mode if set to "1". The Marble then activates without a quote.
+// EdgelessRT's erthost uses the same variable to enable SGX simulation.
+const SimulationMode = "OE_SIMULATION"
+
+// CoordinatorRootCA is the PEM-encoded root certificate the Coordinator must present (required outside simulation mode unless CoordinatorPackage is set)
+const CoordinatorRootCA = "EDG_MARBLE_COORDINATOR_ROOT_CA"
+
+// CoordinatorClientAddr is the address of the Coordinator's client API to fetch its quote from (required if CoordinatorPackage is set)
+const CoordinatorClientAddr = "EDG_MARBLE_COORDINATOR_CLIENT_ADDR"
+
+// CoordinatorPackage are the JSON-encoded package properties the Coordinator's quote must comply with (required outside simulation mode unless CoordinatorRootCA is set)
+const CoordinatorPackage = "EDG_MARBLE_COORDINATOR_PACKAGE"
+
+// QuoteFormat is the attestation format of the Marble's quotes: "ert" (default), "signed-report", or "attestation-key" (for testing only)
+const QuoteFormat = "EDG_MARBLE_QUOTE_FORMAT"
+
+// QuoteKey is the PEM-encoded PKCS #8 private key that signs the Marble's reports (required for the signed-report and attestation-key formats).
+// The attestation-key format requires an Ed25519 key.
+const QuoteKey = "EDG_MARBLE_QUOTE_KEY"
+
+// QuoteCertificates is the PEM-encoded certificate chain of QuoteKey, leaf first (required for the signed-report format)
+const QuoteCertificates = "EDG_MARBLE_QUOTE_CERTIFICATES"
+
+// QuoteReport are the JSON-encoded properties the Marble's reports attest, e.g., {"SignerID": "...", "ProductID": 1} (required for the signed-report and attestation-key formats)
+const QuoteReport = "EDG_MARBLE_QUOTE_REPORT"
@@ -10,17 +10,28 @@ package premain
 import (
 	"context"
 	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"syscall"
 
 	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/edgelesssys/marblerun/coordinator/quote/ertvalidator"
 	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/attestation"
 	"github.com/edgelesssys/marblerun/marble/config"
 	"github.com/edgelesssys/marblerun/util"
 	"github.com/google/uuid"
@@ -94,16 +105,21 @@ func PreMain() error {
 		return err
 	}
 	enclavefs := afero.NewOsFs()
-	return preMain(ertvalidator.NewERTIssuer(), activateRPC, hostfs, enclavefs)
+	simulationMode := os.Getenv(config.SimulationMode) == "1"
+	issuer, err := attestation.NewIssuer()
+	if err != nil {
+		return err
+	}
+	return preMain(issuer, ertvalidator.NewERTValidator(), simulationMode, getCertQuoteHTTP, activateRPC, hostfs, enclavefs)
 }
 
-// PreMainMock mocks the quoting and file system handling in the PreMain routine for testing.
+// PreMainMock mocks the quoting and file system handling in the PreMain routine for testing. It always runs in simulation mode.
 func PreMainMock() error {
 	hostfs := afero.NewOsFs()
-	return preMain(quote.NewFailIssuer(), activateRPC, hostfs, hostfs)
+	return preMain(quote.NewFailIssuer(), quote.NewFailValidator(), true, getCertQuoteHTTP, activateRPC, hostfs, hostfs)
 }
 
-func preMain(issuer quote.Issuer, activate activateFunc, hostfs, enclavefs afero.Fs) error {
+func preMain(issuer quote.Issuer, validator quote.NonceValidator, simulationMode bool, getCertQuote getCertQuoteFunc, activate activateFunc, hostfs, enclavefs afero.Fs) error {
 	prefixBackup := log.Prefix()
 	defer log.SetPrefix(prefixBackup)
 	log.SetPrefix("[PreMain] ")
@@ -122,9 +138,21 @@ func preMain(issuer quote.Issuer, activate activateFunc, hostfs, enclavefs afero
 		return err
 	}
 
-	// Load TLS Credentials with InsecureSkipVerify enabled. (The coordinator verifies the marble, but not the other way round.)
+	// Verify the Coordinator before sending it the CSR and receiving secrets
+	coordinatorRoot, err := verifyCoordinator(validator, simulationMode, getCertQuote)
+	if err != nil {
+		return fmt.Errorf("failed to verify the Coordinator: %v", err)
+	}
+
 	log.Println("loading TLS Credentials")
-	tlsCredentials, err := util.LoadGRPCTLSCredentials(cert, privk, true)
+	var tlsCredentials credentials.TransportCredentials
+	if coordinatorRoot != nil {
+		tlsCredentials, err = util.LoadGRPCTLSCredentialsWithRoot(cert, privk, coordinatorRoot)
+	} else {
+		// Load TLS Credentials with InsecureSkipVerify enabled. (The coordinator verifies the marble, but not the other way round.)
+		log.Println("WARNING: running in simulation mode without a Coordinator root certificate or Coordinator package properties. The Coordinator is not verified")
+		tlsCredentials, err = util.LoadGRPCTLSCredentials(cert, privk, true)
+	}
 	if err != nil {
 		return err
 	}
@@ -143,18 +171,20 @@ func preMain(issuer quote.Issuer, activate activateFunc, hostfs, enclavefs afero
 	}
 
 	// generate Quote
-	log.Println("generating quote")
-	if issuer == nil {
-		// default
-		issuer = ertvalidator.NewERTIssuer()
-	}
-	quote, err := issuer.Issue(cert.Raw)
-	if err != nil {
-		log.Println("failed to get quote. Proceeding in simulation mode")
-		// If we run in SimulationMode we get an error here
-		// For testing purpose we do not want to just fail here
-		// Instead we store an empty quote that will only be accepted if the coordinator also runs in SimulationMode
-		quote = []byte{}
+	var quote []byte
+	if simulationMode {
+		// We send an empty quote that will only be accepted if the coordinator also runs in simulation mode
+		log.Println("running in simulation mode. Skipping quote generation")
+	} else {
+		log.Println("generating quote")
+		if issuer == nil {
+			// default
+			issuer = ertvalidator.NewERTIssuer()
+		}
+		quote, err = issuer.Issue(cert.Raw)
+		if err != nil {
+			return fmt.Errorf("failed to get quote: %v", err)
+		}
 	}
 
 	// authenticate with Coordinator
@@ -184,6 +214,113 @@ func preMain(issuer quote.Issuer, activate activateFunc, hostfs, enclavefs afero
 	return nil
 }
 
+// verifyCoordinator returns the Coordinator's trusted root certificate as configured by the environment.
+// The root certificate is pinned, attested by the Coordinator's quote, or both.
+// If neither is configured, it returns nil in simulation mode and an error otherwise.
+func verifyCoordinator(validator quote.NonceValidator, simulationMode bool, getCertQuote getCertQuoteFunc) (*x509.Certificate, error) {
+	var pinnedRoot *x509.Certificate
+	if rootPEM := os.Getenv(config.CoordinatorRootCA); rootPEM != "" {
+		block, _ := pem.Decode([]byte(rootPEM))
+		if block == nil {
+			return nil, fmt.Errorf("%v does not contain a PEM-encoded certificate", config.CoordinatorRootCA)
+		}
+		root, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		pinnedRoot = root
+	}
+
+	pkgJSON := os.Getenv(config.CoordinatorPackage)
+	if pkgJSON == "" {
+		if pinnedRoot == nil && !simulationMode {
+			return nil, fmt.Errorf("neither %v nor %v is set, so the Coordinator cannot be verified", config.CoordinatorRootCA, config.CoordinatorPackage)
+		}
+		return pinnedRoot, nil
+	}
+	var pkg quote.PackageProperties
+	if err := json.Unmarshal([]byte(pkgJSON), &pkg); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", config.CoordinatorPackage, err)
+	}
+	clientAddr := os.Getenv(config.CoordinatorClientAddr)
+	if clientAddr == "" {
+		return nil, fmt.Errorf("%v is required if %v is set", config.CoordinatorClientAddr, config.CoordinatorPackage)
+	}
+
+	// Bind the quote to a nonce, so an old or cloned Coordinator cannot replay its quote
+	log.Println("fetching the Coordinator's quote")
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	certChain, certQuote, err := getCertQuote(clientAddr, nonce)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parseRootCertificate(certChain)
+	if err != nil {
+		return nil, err
+	}
+	if pinnedRoot != nil && !root.Equal(pinnedRoot) {
+		return nil, errors.New("the Coordinator's root certificate does not match the pinned certificate")
+	}
+
+	if simulationMode {
+		log.Println("WARNING: running in simulation mode. Skipping verification of the Coordinator's quote")
+		return root, nil
+	}
+	log.Println("verifying the Coordinator's quote")
+	if err := validator.ValidateWithNonce(certQuote, root.Raw, nonce, pkg); err != nil {
+		return nil, fmt.Errorf("invalid Coordinator quote: %v", err)
+	}
+	return root, nil
+}
+
+// parseRootCertificate returns the last certificate of a PEM-encoded certificate chain
+func parseRootCertificate(certChain string) (*x509.Certificate, error) {
+	var block *pem.Block
+	rest := []byte(certChain)
+	for {
+		next, remaining := pem.Decode(rest)
+		if next == nil {
+			break
+		}
+		block, rest = next, remaining
+	}
+	if block == nil {
+		return nil, errors.New("the Coordinator did not send a certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+type getCertQuoteFunc func(clientAddr string, nonce []byte) (certChain string, certQuote []byte, err error)
+
+func getCertQuoteHTTP(clientAddr string, nonce []byte) (string, []byte, error) {
+	// The certificate is not trusted yet, it is verified using the quote
+	client := http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	quoteURL := url.URL{Scheme: "https", Host: clientAddr, Path: "quote", RawQuery: "nonce=" + hex.EncodeToString(nonce)}
+	resp, err := client.Get(quoteURL.String())
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("fetching the Coordinator's quote failed: %s %s", resp.Status, body)
+	}
+	var certQuote struct {
+		Cert  string
+		Quote []byte
+	}
+	if err := json.Unmarshal(body, &certQuote); err != nil {
+		return "", nil, err
+	}
+	return certQuote.Cert, certQuote.Quote, nil
+}
+
 type activateFunc func(req *rpc.ActivationReq, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.Parameters, error)
 
 func activateRPC(req *rpc.ActivationReq, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.Parameters, error) {
@@ -202,6 +339,56 @@ func activateRPC(req *rpc.ActivationReq, coordAddr string, tlsCredentials creden
 	return activationResp.GetParameters(), nil
 }
 
+// applyFile creates a structured file with its mode and owner.
+// Directories are created owner-writable, so their children can be created. Their mode and owner are applied by applyMode afterwards.
+func applyFile(path string, file *rpc.File, fs afero.Fs) error {
+	if err := file.Check(); err != nil {
+		return err
+	}
+
+	if file.Directory {
+		return fs.MkdirAll(path, 0700)
+	}
+	content, err := file.DecodeContent()
+	if err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err := afero.WriteFile(fs, path, content, file.FileMode()); err != nil {
+		return err
+	}
+	return applyMode(path, file, fs)
+}
+
+// applyMode sets the mode and owner of a structured file or directory.
+func applyMode(path string, file *rpc.File, fs afero.Fs) error {
+	// The mode passed on creation is subject to the umask and not applied to existing files
+	if err := fs.Chmod(path, file.FileMode()); err != nil {
+		return err
+	}
+
+	uid, gid, err := file.ParseOwner()
+	if err != nil || uid < 0 {
+		return err
+	}
+	return chown(fs, path, uid, gid)
+}
+
+// chown changes the owner of a file. afero.Fs does not support this, so it is only implemented for file systems that provide it and for the OS file system.
+func chown(fs afero.Fs, path string, uid int, gid int) error {
+	if chowner, ok := fs.(interface {
+		Chown(name string, uid, gid int) error
+	}); ok {
+		return chowner.Chown(path, uid, gid)
+	}
+	if _, ok := fs.(*afero.OsFs); ok {
+		return os.Chown(path, uid, gid)
+	}
+	return errors.New("the file system does not support changing the owner")
+}
+
 func applyParameters(params *rpc.Parameters, fs afero.Fs) error {
 	// Store files in file system
 	log.Println("creating files from manifest")
@@ -214,6 +401,60 @@ func applyParameters(params *rpc.Parameters, fs afero.Fs) error {
 		}
 	}
 
+	// Create structured files and directories. Parents are created before their children.
+	paths := make([]string, 0, len(params.StructuredFiles))
+	for path := range params.StructuredFiles {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := applyFile(path, params.StructuredFiles[path], fs); err != nil {
+			return fmt.Errorf("failed to create %v: %v", path, err)
+		}
+	}
+	// Apply the modes of directories after their children were created, deepest first, so that read-only directories can have children
+	for i := len(paths) - 1; i >= 0; i-- {
+		path := paths[i]
+		if file := params.StructuredFiles[path]; file.Directory {
+			if err := applyMode(path, file, fs); err != nil {
+				return fmt.Errorf("failed to set the mode of %v: %v", path, err)
+			}
+		}
+	}
+
+	// Remove host environment variables that are not allowed, collecting the host arguments first
+	var hostArgs, allowedHostArgs []string
+	if len(os.Args) > 1 {
+		hostArgs = os.Args[1:]
+	}
+	if allowlist := params.HostAllowlist; allowlist != nil {
+		if err := allowlist.Check(); err != nil {
+			return err
+		}
+		allowedEnv, droppedEnv, err := allowlist.FilterEnv(os.Environ())
+		if err != nil {
+			return err
+		}
+		if len(droppedEnv) > 0 {
+			log.Println("dropping host env vars not allowed by manifest:", strings.Join(droppedEnv, ", "))
+		}
+		os.Clearenv()
+		for key, value := range allowedEnv {
+			if err := os.Setenv(key, value); err != nil {
+				return err
+			}
+		}
+
+		var droppedArgs int
+		allowedHostArgs, droppedArgs, err = allowlist.FilterArgs(hostArgs)
+		if err != nil {
+			return err
+		}
+		if droppedArgs > 0 {
+			log.Printf("dropping %d host args not allowed by manifest\n", droppedArgs)
+		}
+	}
+
 	// Set environment variables
 	log.Println("setting env vars from manifest")
 	for key, value := range params.Env {
@@ -224,10 +465,11 @@ func applyParameters(params *rpc.Parameters, fs afero.Fs) error {
 
 	// Set Args
 	if len(params.Argv) > 0 {
-		os.Args = params.Argv
+		os.Args = append([]string{}, params.Argv...)
 	} else {
 		os.Args = []string{"./marble"}
 	}
+	os.Args = append(os.Args, allowedHostArgs...)
 
 	return nil
 }
@@ -8,13 +8,19 @@ package premain
 
 import (
 	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/edgelesssys/marblerun/coordinator/quote"
 	"github.com/edgelesssys/marblerun/coordinator/rpc"
 	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/util"
 	"github.com/google/uuid"
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -52,12 +58,23 @@ func TestPreMain(t *testing.T) {
 	}
 
 	issuer := quote.NewMockIssuer()
+	validator := quote.NewMockValidator()
+	getCertQuote := func(clientAddr string, nonce []byte) (string, []byte, error) {
+		assert.Fail("the Coordinator's quote must only be fetched if configured")
+		return "", nil, errors.New("not configured")
+	}
 
 	require.NoError(os.Setenv(config.CoordinatorAddr, "addr"))
 	require.NoError(os.Setenv(config.Type, "type"))
 	require.NoError(os.Setenv(config.UUIDFile, "uuidfile"))
 	require.NoError(os.Setenv(config.DNSNames, "dns1,dns2"))
 
+	// The Coordinator must be verified in production mode
+	coordinatorRoot, _, err := util.GenerateCert([]string{"localhost"}, nil, true)
+	require.NoError(err)
+	defer os.Unsetenv(config.CoordinatorRootCA)
+	require.NoError(os.Setenv(config.CoordinatorRootCA, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: coordinatorRoot.Raw}))))
+
 	// Actual tests follow.
 
 	{
@@ -66,7 +83,7 @@ func TestPreMain(t *testing.T) {
 
 		hostfs := afero.NewMemMapFs()
 		enclavefs := afero.NewMemMapFs()
-		require.NoError(preMain(issuer, activate, hostfs, enclavefs))
+		require.NoError(preMain(issuer, validator, false, getCertQuote, activate, hostfs, enclavefs))
 
 		savedUUID, err := afero.ReadFile(hostfs, "uuidfile")
 		assert.NoError(err)
@@ -82,7 +99,7 @@ func TestPreMain(t *testing.T) {
 
 		hostfs := afero.NewMemMapFs()
 		enclavefs := afero.NewMemMapFs()
-		require.Error(preMain(issuer, activate, hostfs, enclavefs))
+		require.Error(preMain(issuer, validator, false, getCertQuote, activate, hostfs, enclavefs))
 
 		_, err := afero.ReadFile(hostfs, "uuidfile")
 		assert.Error(err)
@@ -108,7 +125,7 @@ func TestPreMain(t *testing.T) {
 
 		hostfs := afero.NewMemMapFs()
 		enclavefs := afero.NewMemMapFs()
-		require.NoError(preMain(issuer, activate, hostfs, enclavefs))
+		require.NoError(preMain(issuer, validator, false, getCertQuote, activate, hostfs, enclavefs))
 
 		savedUUID, err := afero.ReadFile(hostfs, "uuidfile")
 		assert.NoError(err)
@@ -136,7 +153,7 @@ func TestPreMain(t *testing.T) {
 
 		hostfs := afero.NewMemMapFs()
 		enclavefs := afero.NewMemMapFs()
-		require.Error(preMain(issuer, activate, hostfs, enclavefs))
+		require.Error(preMain(issuer, validator, false, getCertQuote, activate, hostfs, enclavefs))
 
 		_, err := afero.ReadFile(hostfs, "uuidfile")
 		assert.Error(err)
@@ -151,4 +168,289 @@ func TestPreMain(t *testing.T) {
 
 		assert.Equal([]string{"not modified"}, os.Args)
 	}
+	{
+		parameters = &rpc.Parameters{
+			StructuredFiles: map[string]*rpc.File{
+				"dir":              {Directory: true, Mode: 0750},
+				"dir/binary":       {Content: []byte("AAEC/w=="), Encoding: "base64", Mode: 0644},
+				"dir/sub/hex":      {Content: []byte("00010203"), Encoding: "hex"},
+				"dir/sub/template": {Content: []byte("text")},
+			},
+		}
+		activateError = nil
+
+		hostfs := afero.NewMemMapFs()
+		enclavefs := afero.NewMemMapFs()
+		require.NoError(preMain(issuer, validator, false, getCertQuote, activate, hostfs, enclavefs))
+
+		info, err := enclavefs.Stat("dir")
+		require.NoError(err)
+		assert.True(info.IsDir())
+		assert.Equal(os.FileMode(0750), info.Mode().Perm())
+
+		data, err := afero.ReadFile(enclavefs, "dir/binary")
+		require.NoError(err)
+		assert.Equal([]byte{0, 1, 2, 255}, data)
+		info, err = enclavefs.Stat("dir/binary")
+		require.NoError(err)
+		assert.Equal(os.FileMode(0644), info.Mode().Perm())
+
+		data, err = afero.ReadFile(enclavefs, "dir/sub/hex")
+		require.NoError(err)
+		assert.Equal([]byte{0, 1, 2, 3}, data)
+		info, err = enclavefs.Stat("dir/sub/hex")
+		require.NoError(err)
+		assert.Equal(rpc.DefaultFileMode, info.Mode().Perm())
+
+		data, err = afero.ReadFile(enclavefs, "dir/sub/template")
+		require.NoError(err)
+		assert.Equal([]byte("text"), data)
+	}
+	{
+		// invalid content and unsupported owner
+		for _, file := range []*rpc.File{
+			{Content: []byte("not base64"), Encoding: "base64"},
+			{Content: []byte("data"), Encoding: "unknown"},
+			{Content: []byte("data"), Owner: "1000:1000"},
+		} {
+			parameters = &rpc.Parameters{StructuredFiles: map[string]*rpc.File{"file": file}}
+			activateError = nil
+			assert.Error(preMain(issuer, validator, false, getCertQuote, activate, afero.NewMemMapFs(), afero.NewMemMapFs()))
+		}
+	}
+}
+
+func TestApplyParametersReadOnlyDirectory(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	argsBackup := os.Args
+	defer func() { os.Args = argsBackup }()
+
+	tempDir, err := ioutil.TempDir("", "")
+	require.NoError(err)
+	defer os.RemoveAll(tempDir)
+	// make the directories removable again
+	defer filepath.Walk(tempDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			os.Chmod(path, 0700)
+		}
+		return nil
+	})
+	fs := afero.NewBasePathFs(afero.NewOsFs(), tempDir)
+
+	// The children of read-only directories are created before the directories' modes are applied
+	params := &rpc.Parameters{
+		StructuredFiles: map[string]*rpc.File{
+			"ro":          {Directory: true, Mode: 0500},
+			"ro/sub":      {Directory: true, Mode: 0555},
+			"ro/sub/file": {Content: []byte("data"), Mode: 0444},
+			"ro/template": {Content: []byte("text")},
+		},
+	}
+	require.NoError(applyParameters(params, fs))
+
+	for path, mode := range map[string]os.FileMode{"ro": 0500, "ro/sub": 0555, "ro/sub/file": 0444, "ro/template": rpc.DefaultFileMode} {
+		info, err := fs.Stat(path)
+		require.NoError(err)
+		assert.Equal(mode, info.Mode().Perm(), path)
+	}
+	data, err := afero.ReadFile(fs, "ro/sub/file")
+	require.NoError(err)
+	assert.Equal([]byte("data"), data)
+}
+
+func TestPreMainSimulationMode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	argsBackup := os.Args
+	defer func() { os.Args = argsBackup }()
+
+	var activated bool
+	activate := func(req *rpc.ActivationReq, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.Parameters, error) {
+		activated = true
+		assert.Empty(req.Quote)
+		return &rpc.Parameters{}, nil
+	}
+
+	issuer := quote.NewFailIssuer()
+	validator := quote.NewFailValidator()
+	getCertQuote := func(clientAddr string, nonce []byte) (string, []byte, error) {
+		return "", nil, errors.New("not configured")
+	}
+
+	require.NoError(os.Setenv(config.CoordinatorAddr, "addr"))
+	require.NoError(os.Setenv(config.Type, "type"))
+	require.NoError(os.Setenv(config.UUIDFile, "uuidfile"))
+	require.NoError(os.Setenv(config.DNSNames, "dns1,dns2"))
+
+	coordinatorRoot, _, err := util.GenerateCert([]string{"localhost"}, nil, true)
+	require.NoError(err)
+	defer os.Unsetenv(config.CoordinatorRootCA)
+	require.NoError(os.Setenv(config.CoordinatorRootCA, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: coordinatorRoot.Raw}))))
+
+	// quote failures are fatal in production mode
+	assert.Error(preMain(issuer, validator, false, getCertQuote, activate, afero.NewMemMapFs(), afero.NewMemMapFs()))
+	assert.False(activated)
+
+	// simulation mode activates without a quote
+	require.NoError(preMain(issuer, validator, true, getCertQuote, activate, afero.NewMemMapFs(), afero.NewMemMapFs()))
+	assert.True(activated)
+}
+
+func TestPreMainHostAllowlist(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// The test will modify os.Args and the environment, restore them afterwards.
+	argsBackup := os.Args
+	envBackup := os.Environ()
+	defer func() {
+		os.Args = argsBackup
+		os.Clearenv()
+		for _, keyValue := range envBackup {
+			parts := strings.SplitN(keyValue, "=", 2)
+			os.Setenv(parts[0], parts[1])
+		}
+	}()
+
+	parameters := &rpc.Parameters{
+		Env:  map[string]string{"EDG_TEST_MANIFEST": "manifest", "EDG_TEST_OVERRIDE": "manifest"},
+		Argv: []string{"arg0", "serve"},
+		HostAllowlist: &rpc.HostAllowlist{
+			Env: map[string]string{
+				"EDG_TEST_ANY":      "",
+				"EDG_TEST_PORT":     "[0-9]+",
+				"EDG_TEST_INVALID":  "[0-9]+",
+				"EDG_TEST_OVERRIDE": "",
+			},
+			Args: []string{"--verbose", "--port=[0-9]+"},
+		},
+	}
+	activate := func(req *rpc.ActivationReq, coordAddr string, tlsCredentials credentials.TransportCredentials) (*rpc.Parameters, error) {
+		return parameters, nil
+	}
+	getCertQuote := func(clientAddr string, nonce []byte) (string, []byte, error) {
+		return "", nil, errors.New("not configured")
+	}
+
+	require.NoError(os.Setenv(config.CoordinatorAddr, "addr"))
+	require.NoError(os.Setenv(config.Type, "type"))
+	require.NoError(os.Setenv(config.UUIDFile, "uuidfile"))
+	require.NoError(os.Setenv(config.DNSNames, "dns1,dns2"))
+	require.NoError(os.Setenv("EDG_TEST_ANY", "any value"))
+	require.NoError(os.Setenv("EDG_TEST_PORT", "8080"))
+	require.NoError(os.Setenv("EDG_TEST_INVALID", "80a"))
+	require.NoError(os.Setenv("EDG_TEST_OVERRIDE", "host"))
+	require.NoError(os.Setenv("EDG_TEST_NOT_ALLOWED", "host"))
+	os.Args = []string{"host", "--verbose", "--port=443", "--port=x", "--debug"}
+
+	require.NoError(preMain(quote.NewMockIssuer(), quote.NewMockValidator(), true, getCertQuote, activate, afero.NewMemMapFs(), afero.NewMemMapFs()))
+
+	assert.Equal("any value", os.Getenv("EDG_TEST_ANY"))
+	assert.Equal("8080", os.Getenv("EDG_TEST_PORT"))
+	assert.Equal("manifest", os.Getenv("EDG_TEST_MANIFEST"))
+	assert.Equal("manifest", os.Getenv("EDG_TEST_OVERRIDE"))
+	_, ok := os.LookupEnv("EDG_TEST_INVALID")
+	assert.False(ok)
+	_, ok = os.LookupEnv("EDG_TEST_NOT_ALLOWED")
+	assert.False(ok)
+	_, ok = os.LookupEnv(config.CoordinatorAddr)
+	assert.False(ok)
+
+	assert.Equal([]string{"arg0", "serve", "--verbose", "--port=443"}, os.Args)
+	assert.Equal([]string{"arg0", "serve"}, parameters.Argv)
+}
+
+func TestVerifyCoordinator(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	issuer := quote.NewMockIssuer()
+	validator := quote.NewMockValidator()
+	productID := uint64(42)
+	pkg := quote.PackageProperties{SignerID: "signer", ProductID: &productID}
+	rawPkg, err := json.Marshal(pkg)
+	require.NoError(err)
+
+	root, _, err := util.GenerateCert([]string{"localhost"}, nil, true)
+	require.NoError(err)
+	rootPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}))
+	otherRoot, _, err := util.GenerateCert([]string{"localhost"}, nil, true)
+	require.NoError(err)
+	otherRootPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherRoot.Raw}))
+
+	// Mocks the Coordinator's client API. Only quotes of the root certificate are valid.
+	getCertQuote := func(clientAddr string, nonce []byte) (string, []byte, error) {
+		assert.Equal("clientaddr", clientAddr)
+		certQuote, err := issuer.IssueWithNonce(root.Raw, nonce)
+		require.NoError(err)
+		validator.AddValidQuote(certQuote, quote.NonceReportData(root.Raw, nonce), pkg, quote.InfrastructureProperties{})
+		return rootPEM, certQuote, nil
+	}
+	replayedQuote, err := issuer.IssueWithNonce(root.Raw, []byte("old nonce"))
+	require.NoError(err)
+	validator.AddValidQuote(replayedQuote, quote.NonceReportData(root.Raw, []byte("old nonce")), pkg, quote.InfrastructureProperties{})
+	getReplayedCertQuote := func(clientAddr string, nonce []byte) (string, []byte, error) {
+		return rootPEM, replayedQuote, nil
+	}
+
+	defer os.Unsetenv(config.CoordinatorRootCA)
+	defer os.Unsetenv(config.CoordinatorPackage)
+	defer os.Unsetenv(config.CoordinatorClientAddr)
+	require.NoError(os.Setenv(config.CoordinatorClientAddr, "clientaddr"))
+
+	// not configured, which is only allowed in simulation mode
+	_, err = verifyCoordinator(validator, false, getCertQuote)
+	assert.Error(err)
+	coordinatorRoot, err := verifyCoordinator(validator, true, getCertQuote)
+	require.NoError(err)
+	assert.Nil(coordinatorRoot)
+
+	// pinned root certificate
+	require.NoError(os.Setenv(config.CoordinatorRootCA, rootPEM))
+	coordinatorRoot, err = verifyCoordinator(validator, false, getCertQuote)
+	require.NoError(err)
+	assert.True(root.Equal(coordinatorRoot))
+
+	// pinned root certificate and attested quote
+	require.NoError(os.Setenv(config.CoordinatorPackage, string(rawPkg)))
+	coordinatorRoot, err = verifyCoordinator(validator, false, getCertQuote)
+	require.NoError(err)
+	assert.True(root.Equal(coordinatorRoot))
+
+	// replayed quote
+	_, err = verifyCoordinator(validator, false, getReplayedCertQuote)
+	assert.Error(err)
+
+	// pinned root certificate does not match
+	require.NoError(os.Setenv(config.CoordinatorRootCA, otherRootPEM))
+	_, err = verifyCoordinator(validator, false, getCertQuote)
+	assert.Error(err)
+
+	// attested quote only
+	require.NoError(os.Unsetenv(config.CoordinatorRootCA))
+	coordinatorRoot, err = verifyCoordinator(validator, false, getCertQuote)
+	require.NoError(err)
+	assert.True(root.Equal(coordinatorRoot))
+
+	// package properties do not comply
+	otherProductID := uint64(43)
+	rawOtherPkg, err := json.Marshal(quote.PackageProperties{SignerID: "signer", ProductID: &otherProductID})
+	require.NoError(err)
+	require.NoError(os.Setenv(config.CoordinatorPackage, string(rawOtherPkg)))
+	_, err = verifyCoordinator(validator, false, getCertQuote)
+	assert.Error(err)
+
+	// the client address is required to fetch the quote
+	require.NoError(os.Unsetenv(config.CoordinatorClientAddr))
+	_, err = verifyCoordinator(validator, false, getCertQuote)
+	assert.Error(err)
+	require.NoError(os.Setenv(config.CoordinatorClientAddr, "clientaddr"))
+
+	// the quote is not verified in simulation mode
+	coordinatorRoot, err = verifyCoordinator(validator, true, getCertQuote)
+	require.NoError(err)
+	assert.True(root.Equal(coordinatorRoot))
 }
@@ -0,0 +1,152 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tlsconfig
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/marble/attestation"
+	"github.com/edgelesssys/marblerun/marble/config"
+	"github.com/edgelesssys/marblerun/util"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Renew fetches a new certificate for the Marble from the Coordinator's Marble API at coordAddr, e.g., the value of EDG_MARBLE_COORDINATOR_ADDR.
+//
+// The Marble authenticates with its current certificate and a quote over it, so the Coordinator verifies it against the manifest again.
+// The new certificate and the intermediate CAs the Coordinator currently trusts replace the Marble's credentials.
+// They are used by all configurations returned by GetServerConfig and GetClientConfig, including existing ones, and stored in the environment.
+// After the Coordinator rotated its intermediate CA, Marbles must renew before the overlap period ends.
+// Until then, renewing also lets them accept peers that were activated with the new intermediate CA.
+func Renew(coordAddr string) error {
+	root, err := loadCoordinatorRootFromEnv()
+	if err != nil {
+		return err
+	}
+	return renew(func(cert tls.Certificate, csr []byte) (*rpc.RenewResp, error) {
+		quote, err := issueQuote(cert.Certificate[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to get quote: %v", err)
+		}
+		return renewRPC(coordAddr, util.GRPCTLSCredentialsWithRoot(cert, root), csr, quote)
+	})
+}
+
+// issueQuote returns a quote over the Marble's current certificate, so the Coordinator can verify the Marble again.
+// The quote has the attestation format the Marble was activated with. In simulation mode, the quote is empty like during activation.
+func issueQuote(cert []byte) ([]byte, error) {
+	if os.Getenv(config.SimulationMode) == "1" {
+		return nil, nil
+	}
+	issuer, err := attestation.NewIssuer()
+	if err != nil {
+		return nil, err
+	}
+	return issuer.Issue(cert)
+}
+
+// RenewPeriodically calls Renew at the given interval until ctx is done. The interval should be shorter than the overlap period of the Coordinator's intermediate CA rotation.
+// Errors are passed to handleError, if it is not nil, and renewal is retried at the next interval.
+func RenewPeriodically(ctx context.Context, coordAddr string, interval time.Duration, handleError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := Renew(coordAddr); err != nil && handleError != nil {
+				handleError(err)
+			}
+		}
+	}
+}
+
+type renewFunc func(cert tls.Certificate, csr []byte) (*rpc.RenewResp, error)
+
+func renew(renewCert renewFunc) error {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+	creds, env, err := loadFromEnvLocked()
+	if err != nil {
+		return err
+	}
+
+	// The new certificate keeps the key and names of the current one
+	cert, _ := creds.get()
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	template := x509.CertificateRequest{
+		DNSNames:    leaf.DNSNames,
+		IPAddresses: leaf.IPAddresses,
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &template, cert.PrivateKey)
+	if err != nil {
+		return err
+	}
+	resp, err := renewCert(cert, csr)
+	if err != nil {
+		return fmt.Errorf("failed to renew the Marble's certificate: %v", err)
+	}
+
+	newEnv := envCredentials{
+		certChain:      resp.GetCertificateChain(),
+		intermediateCA: resp.GetIntermediateCA(),
+		privk:          env.privk,
+	}
+	newCert, roots, err := parseCredentials(newEnv)
+	if err != nil {
+		return err
+	}
+	if err := os.Setenv(marble.MarbleEnvironmentCertificateChain, newEnv.certChain); err != nil {
+		return err
+	}
+	if err := os.Setenv(marble.MarbleEnvironmentIntermediateCA, newEnv.intermediateCA); err != nil {
+		return err
+	}
+	creds.set(newCert, roots)
+	delete(loadedCredentials, env)
+	loadedCredentials[newEnv] = creds
+	return nil
+}
+
+func renewRPC(coordAddr string, tlsCredentials credentials.TransportCredentials, csr []byte, quote []byte) (*rpc.RenewResp, error) {
+	connection, err := grpc.Dial(coordAddr, grpc.WithTransportCredentials(tlsCredentials))
+	if err != nil {
+		return nil, err
+	}
+	defer connection.Close()
+
+	client := rpc.NewMarbleClient(connection)
+	return client.Renew(context.Background(), &rpc.RenewReq{CSR: csr, Quote: quote})
+}
+
+// loadCoordinatorRootFromEnv loads the Coordinator's root certificate the Marble received during activation
+func loadCoordinatorRootFromEnv() (*x509.Certificate, error) {
+	rootPEM, err := getEnv(util.MarbleEnvironmentCoordinatorRootCA)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(rootPEM)
+	if block == nil {
+		return nil, errors.New("cannot parse the Coordinator's root certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,308 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+// Package tlsconfig provides TLS configurations for connections between Marbles.
+//
+// The configurations are built from the credentials a Marble receives from the Coordinator during activation.
+// Peers are accepted if the Coordinator issued their certificate to one of the allowed Marble types.
+// If the manifest declares Connections, peers must additionally be allowed by them.
+// Marbles can renew their credentials with Renew, e.g., after the Coordinator rotated its intermediate CA.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/util"
+)
+
+// GetServerConfig returns a TLS configuration for a Marble's server.
+// Clients must present a certificate issued by the Coordinator to one of the allowed Marble types. If no types are given, any Marble is accepted.
+// If the manifest declares Connections, clients must also be allowed to connect to this Marble on the port of the connection.
+// If the port of a connection is unknown, e.g., because it is not a TCP connection, only clients that may connect on any port are accepted.
+//
+// The configuration uses the Marble's current credentials for each connection, so it keeps working after Renew.
+func GetServerConfig(allowedTypes ...string) (*tls.Config, error) {
+	creds, err := loadFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	peers, err := loadPeersFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, _ := creds.get()
+			return &cert, nil
+		},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+
+	// The trusted CAs and the port a client connects to are only known per connection
+	base := config.Clone()
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		connConfig := base.Clone()
+		_, connConfig.ClientCAs = creds.get()
+		connConfig.VerifyPeerCertificate = verifyMarbleType(allowedTypes, allowedClients(peers, localPort(hello.Conn)))
+		return connConfig, nil
+	}
+	return config, nil
+}
+
+// GetClientConfig returns a TLS configuration for a Marble's client.
+// Servers must present a certificate issued by the Coordinator to one of the allowed Marble types. If no types are given, any Marble is accepted.
+// If the manifest declares Connections, this Marble must also be allowed to connect to the server's Marble type. The ports are enforced by the server.
+//
+// The configuration uses the Marble's current credentials for each connection, so it keeps working after Renew.
+// Servers are identified by their Marble type, the hostname is not verified.
+func GetClientConfig(allowedTypes ...string) (*tls.Config, error) {
+	creds, err := loadFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	peers, err := loadPeersFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	var allowedByManifest func(string) bool
+	if peers != nil {
+		allowedByManifest = func(marbleType string) bool { return peers.AllowsServer(marbleType, 0) }
+	}
+	verify := verifyMarbleType(allowedTypes, allowedByManifest)
+	return &tls.Config{
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := creds.get()
+			return &cert, nil
+		},
+		// RootCAs can not change after the configuration was created, so the chain is verified in VerifyPeerCertificate instead
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			verifiedChains, err := creds.verify(rawCerts, x509.ExtKeyUsageServerAuth)
+			if err != nil {
+				return err
+			}
+			return verify(rawCerts, verifiedChains)
+		},
+	}, nil
+}
+
+// PeerIdentity returns the identity of the peer Marble of a verified connection
+func PeerIdentity(state tls.ConnectionState) (util.MarbleIdentity, error) {
+	if len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 0 {
+		return util.MarbleIdentityFromCert(state.VerifiedChains[0][0])
+	}
+
+	// Client configurations verify the server's chain themselves, so the state does not contain it
+	if len(state.PeerCertificates) == 0 {
+		return util.MarbleIdentity{}, errors.New("connection has no verified peer certificate")
+	}
+	creds, err := loadFromEnv()
+	if err != nil {
+		return util.MarbleIdentity{}, err
+	}
+	rawCerts := make([][]byte, 0, len(state.PeerCertificates))
+	for _, cert := range state.PeerCertificates {
+		rawCerts = append(rawCerts, cert.Raw)
+	}
+	if _, err := creds.verify(rawCerts, x509.ExtKeyUsageAny); err != nil {
+		return util.MarbleIdentity{}, fmt.Errorf("connection has no verified peer certificate: %v", err)
+	}
+	return util.MarbleIdentityFromCert(state.PeerCertificates[0])
+}
+
+// verifyMarbleType returns a tls.Config.VerifyPeerCertificate function that checks the Marble type of the verified peer certificate
+//
+// allowedByManifest checks the type against the Connections of the manifest. It is nil if the manifest does not declare any.
+func verifyMarbleType(allowedTypes []string, allowedByManifest func(marbleType string) bool) func([][]byte, [][]*x509.Certificate) error {
+	return func(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+			return errors.New("peer certificate was not verified")
+		}
+		identity, err := util.MarbleIdentityFromCert(verifiedChains[0][0])
+		if err != nil {
+			return err
+		}
+		if allowedByManifest != nil && !allowedByManifest(identity.Type) {
+			return fmt.Errorf("connection with peer of Marble type %v is not declared in the manifest", identity.Type)
+		}
+		if len(allowedTypes) == 0 {
+			return nil
+		}
+		for _, allowed := range allowedTypes {
+			if identity.Type == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("peer of Marble type %v is not allowed", identity.Type)
+	}
+}
+
+// allowedClients returns a function that checks if a client of a Marble type may connect on the given port.
+// If the port is unknown (0), only clients that may connect on any port are allowed.
+func allowedClients(peers *util.MarblePeers, port uint16) func(string) bool {
+	if peers == nil {
+		return nil
+	}
+	return func(marbleType string) bool { return peers.AllowsClient(marbleType, port) }
+}
+
+// localPort returns the local port of a connection, or 0 if it is unknown. Callers must not treat an unknown port as allowed.
+func localPort(conn net.Conn) uint16 {
+	if conn == nil {
+		return 0
+	}
+	if addr, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		return uint16(addr.Port)
+	}
+	_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		return 0
+	}
+	result, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(result)
+}
+
+// loadPeersFromEnv loads the peers the manifest allows the Marble to communicate with. Returns nil if the manifest does not declare any connections.
+func loadPeersFromEnv() (*util.MarblePeers, error) {
+	rawPeers := os.Getenv(util.MarbleEnvironmentPeers)
+	if rawPeers == "" {
+		return nil, nil
+	}
+	var peers util.MarblePeers
+	if err := json.Unmarshal([]byte(rawPeers), &peers); err != nil {
+		return nil, fmt.Errorf("cannot parse the Marble's peers: %v", err)
+	}
+	return &peers, nil
+}
+
+// marbleCredentials are the Marble's certificate and the intermediate CAs it trusts.
+// They are shared by all configurations loaded from the same environment, so Renew can replace them.
+type marbleCredentials struct {
+	mutex sync.RWMutex
+	cert  tls.Certificate
+	roots *x509.CertPool
+}
+
+func (c *marbleCredentials) get() (tls.Certificate, *x509.CertPool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, c.roots
+}
+
+func (c *marbleCredentials) set(cert tls.Certificate, roots *x509.CertPool) {
+	c.mutex.Lock()
+	c.cert = cert
+	c.roots = roots
+	c.mutex.Unlock()
+}
+
+// verify verifies a peer's certificate chain against the trusted intermediate CAs
+func (c *marbleCredentials) verify(rawCerts [][]byte, usage x509.ExtKeyUsage) ([][]*x509.Certificate, error) {
+	if len(rawCerts) == 0 {
+		return nil, errors.New("peer did not present a certificate")
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, rawCert := range rawCerts {
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, roots := c.get()
+	return certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+}
+
+// envCredentials are the PEM-encoded credentials of the environment variables set during activation
+type envCredentials struct {
+	certChain      string
+	intermediateCA string
+	privk          string
+}
+
+var (
+	credentialsMutex sync.Mutex
+	// loadedCredentials maps the credentials of the environment to the marbleCredentials loaded from them
+	loadedCredentials = make(map[envCredentials]*marbleCredentials)
+)
+
+// loadFromEnv loads the Marble's certificate and the Coordinator's CA from the environment variables set during activation
+func loadFromEnv() (*marbleCredentials, error) {
+	credentialsMutex.Lock()
+	defer credentialsMutex.Unlock()
+	creds, _, err := loadFromEnvLocked()
+	return creds, err
+}
+
+// loadFromEnvLocked is like loadFromEnv, but also returns the environment's credentials. The caller must hold credentialsMutex.
+func loadFromEnvLocked() (*marbleCredentials, envCredentials, error) {
+	var env envCredentials
+	for _, variable := range []struct {
+		name  string
+		value *string
+	}{
+		{marble.MarbleEnvironmentCertificateChain, &env.certChain},
+		{marble.MarbleEnvironmentIntermediateCA, &env.intermediateCA},
+		{marble.MarbleEnvironmentPrivateKey, &env.privk},
+	} {
+		value, err := getEnv(variable.name)
+		if err != nil {
+			return nil, envCredentials{}, err
+		}
+		*variable.value = string(value)
+	}
+
+	if creds, ok := loadedCredentials[env]; ok {
+		return creds, env, nil
+	}
+	cert, roots, err := parseCredentials(env)
+	if err != nil {
+		return nil, envCredentials{}, err
+	}
+	creds := &marbleCredentials{cert: cert, roots: roots}
+	loadedCredentials[env] = creds
+	return creds, env, nil
+}
+
+func parseCredentials(env envCredentials) (tls.Certificate, *x509.CertPool, error) {
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(env.intermediateCA)) {
+		return tls.Certificate{}, nil, errors.New("cannot parse the Coordinator's intermediate CA")
+	}
+	cert, err := tls.X509KeyPair([]byte(env.certChain), []byte(env.privk))
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("cannot create TLS certificate: %v", err)
+	}
+	return cert, roots, nil
+}
+
+func getEnv(name string) ([]byte, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return nil, fmt.Errorf("environment variable not set: %v", name)
+	}
+	return []byte(value), nil
+}
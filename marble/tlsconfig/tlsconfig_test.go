@@ -0,0 +1,360 @@
+// Copyright (c) Edgeless Systems GmbH.
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at https://mozilla.org/MPL/2.0/.
+
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/edgelesssys/ertgolib/marble"
+	"github.com/edgelesssys/marblerun/coordinator/rpc"
+	"github.com/edgelesssys/marblerun/util"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarbleTypes(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ca := newTestCA(require)
+	server := ca.issue(require, util.MarbleIdentity{Type: "server", UUID: uuid.New().String()}.URI())
+	client := ca.issue(require, util.MarbleIdentity{Type: "client", UUID: uuid.New().String()}.URI())
+	other := ca.issue(require, util.MarbleIdentity{Type: "other", UUID: uuid.New().String()}.URI())
+	noType := ca.issue(require)
+
+	defer os.Unsetenv(marble.MarbleEnvironmentCertificateChain)
+	defer os.Unsetenv(marble.MarbleEnvironmentIntermediateCA)
+	defer os.Unsetenv(marble.MarbleEnvironmentPrivateKey)
+
+	// the server only accepts clients, the clients only accept servers
+	server.setEnv(require, ca)
+	serverConfig, err := GetServerConfig("client")
+	require.NoError(err)
+	client.setEnv(require, ca)
+	clientConfig, err := GetClientConfig("server")
+	require.NoError(err)
+	other.setEnv(require, ca)
+	otherConfig, err := GetClientConfig("server")
+	require.NoError(err)
+	noType.setEnv(require, ca)
+	noTypeConfig, err := GetClientConfig()
+	require.NoError(err)
+
+	state, err := handshake(serverConfig, clientConfig)
+	require.NoError(err)
+	peer, err := PeerIdentity(state)
+	require.NoError(err)
+	assert.Equal("client", peer.Type)
+
+	_, err = handshake(serverConfig, otherConfig)
+	assert.Error(err)
+	_, err = handshake(serverConfig, noTypeConfig)
+	assert.Error(err)
+
+	// the client only accepts servers
+	server.setEnv(require, ca)
+	otherServerConfig, err := GetServerConfig()
+	require.NoError(err)
+	_, err = handshake(otherServerConfig, clientConfig)
+	assert.NoError(err)
+	other.setEnv(require, ca)
+	otherServerConfig, err = GetServerConfig()
+	require.NoError(err)
+	_, err = handshake(otherServerConfig, clientConfig)
+	assert.Error(err)
+
+	// missing credentials
+	require.NoError(os.Unsetenv(marble.MarbleEnvironmentPrivateKey))
+	_, err = GetServerConfig()
+	assert.Error(err)
+}
+
+func TestConnections(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ca := newTestCA(require)
+	server := ca.issue(require, util.MarbleIdentity{Type: "server", UUID: uuid.New().String()}.URI())
+	client := ca.issue(require, util.MarbleIdentity{Type: "client", UUID: uuid.New().String()}.URI())
+	other := ca.issue(require, util.MarbleIdentity{Type: "other", UUID: uuid.New().String()}.URI())
+
+	defer os.Unsetenv(marble.MarbleEnvironmentCertificateChain)
+	defer os.Unsetenv(marble.MarbleEnvironmentIntermediateCA)
+	defer os.Unsetenv(marble.MarbleEnvironmentPrivateKey)
+	defer os.Unsetenv(util.MarbleEnvironmentPeers)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer listener.Close()
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+
+	// getConfig returns the configuration of a Marble with the given peers
+	getConfig := func(m testMarble, peers util.MarblePeers, getter func(...string) (*tls.Config, error)) *tls.Config {
+		m.setEnv(require, ca)
+		rawPeers, err := json.Marshal(peers)
+		require.NoError(err)
+		require.NoError(os.Setenv(util.MarbleEnvironmentPeers, string(rawPeers)))
+		config, err := getter()
+		require.NoError(err)
+		return config
+	}
+	connect := func(serverConfig, clientConfig *tls.Config) error {
+		clientConn, err := net.Dial("tcp", listener.Addr().String())
+		require.NoError(err)
+		serverConn, err := listener.Accept()
+		require.NoError(err)
+		_, err = handshakeOver(serverConn, clientConn, serverConfig, clientConfig)
+		return err
+	}
+
+	clientConfig := getConfig(client, util.MarblePeers{Servers: map[string][]uint16{"server": {port}}}, GetClientConfig)
+	otherConfig := getConfig(other, util.MarblePeers{Servers: map[string][]uint16{"server": {}}}, GetClientConfig)
+
+	// the server accepts clients on its port, but not other Marbles
+	serverConfig := getConfig(server, util.MarblePeers{Clients: map[string][]uint16{"client": {port}}}, GetServerConfig)
+	assert.NoError(connect(serverConfig, clientConfig))
+	assert.Error(connect(serverConfig, otherConfig))
+
+	// the server rejects clients on other ports
+	serverConfig = getConfig(server, util.MarblePeers{Clients: map[string][]uint16{"client": {port + 1}}}, GetServerConfig)
+	assert.Error(connect(serverConfig, clientConfig))
+
+	// the client only connects to declared servers
+	serverConfig = getConfig(server, util.MarblePeers{Clients: map[string][]uint16{"client": {}, "other": {}}}, GetServerConfig)
+	assert.NoError(connect(serverConfig, clientConfig))
+	otherConfig = getConfig(other, util.MarblePeers{Servers: map[string][]uint16{"backend": {}}}, GetClientConfig)
+	assert.Error(connect(serverConfig, otherConfig))
+
+	// explicitly allowed types must be declared in the manifest, too
+	client.setEnv(require, ca)
+	require.NoError(os.Setenv(util.MarbleEnvironmentPeers, `{"Servers":{"server":[]}}`))
+	restrictedConfig, err := GetClientConfig("server", "other")
+	require.NoError(err)
+	assert.NoError(connect(serverConfig, restrictedConfig))
+	restrictedConfig, err = GetClientConfig("other")
+	require.NoError(err)
+	assert.Error(connect(serverConfig, restrictedConfig))
+
+	// if the port is unknown, the server only accepts clients that may connect on any port
+	clientConfig = getConfig(client, util.MarblePeers{Servers: map[string][]uint16{"server": {port}}}, GetClientConfig)
+	serverConfig = getConfig(server, util.MarblePeers{Clients: map[string][]uint16{"client": {port}}}, GetServerConfig)
+	_, err = handshake(serverConfig, clientConfig)
+	assert.Error(err)
+	serverConfig = getConfig(server, util.MarblePeers{Clients: map[string][]uint16{"client": {}}}, GetServerConfig)
+	_, err = handshake(serverConfig, clientConfig)
+	assert.NoError(err)
+
+	// invalid peers
+	require.NoError(os.Setenv(util.MarbleEnvironmentPeers, "invalid"))
+	_, err = GetServerConfig()
+	assert.Error(err)
+}
+
+func TestRenew(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// the Coordinator rotated its intermediate CA after the client was activated
+	oldCA := newTestCA(require)
+	newCA := newTestCA(require)
+	clientURI := util.MarbleIdentity{Type: "client", UUID: uuid.New().String()}.URI()
+	client := oldCA.issue(require, clientURI)
+	server := newCA.issue(require, util.MarbleIdentity{Type: "server", UUID: uuid.New().String()}.URI())
+
+	defer os.Unsetenv(marble.MarbleEnvironmentCertificateChain)
+	defer os.Unsetenv(marble.MarbleEnvironmentIntermediateCA)
+	defer os.Unsetenv(marble.MarbleEnvironmentPrivateKey)
+
+	// during the overlap period, the server trusts both intermediate CAs
+	server.setEnv(require, newCA, oldCA)
+	serverConfig, err := GetServerConfig("client")
+	require.NoError(err)
+	client.setEnv(require, oldCA)
+	clientConfig, err := GetClientConfig("server")
+	require.NoError(err)
+
+	// the client does not trust the new intermediate CA yet
+	_, err = handshake(serverConfig, clientConfig)
+	assert.Error(err)
+
+	// a failed renewal keeps the current credentials
+	assert.Error(renew(func(tls.Certificate, []byte) (*rpc.RenewResp, error) { return nil, errors.New("failed") }))
+	_, err = handshake(serverConfig, clientConfig)
+	assert.Error(err)
+
+	// the Coordinator issues a certificate with the new intermediate CA for the CSR
+	var renewedCert []byte
+	require.NoError(renew(func(cert tls.Certificate, rawCSR []byte) (*rpc.RenewResp, error) {
+		csr, err := x509.ParseCertificateRequest(rawCSR)
+		require.NoError(err)
+		require.NoError(csr.CheckSignature())
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(err)
+		assert.Equal(leaf.PublicKey, csr.PublicKey)
+		assert.Equal([]string{"localhost"}, csr.DNSNames)
+
+		renewedCert = newCA.certify(require, csr.PublicKey, clientURI)
+		return &rpc.RenewResp{
+			CertificateChain: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: renewedCert})),
+			IntermediateCA:   newCA.pem() + oldCA.pem(),
+		}, nil
+	}))
+
+	// existing configurations use the renewed credentials
+	state, err := handshake(serverConfig, clientConfig)
+	require.NoError(err)
+	assert.Equal(renewedCert, state.PeerCertificates[0].Raw)
+	peer, err := PeerIdentity(state)
+	require.NoError(err)
+	assert.Equal("client", peer.Type)
+
+	// the client verifies the server's chain itself, so its identity is verified against the renewed credentials
+	serverBlock, _ := pem.Decode(server.certPEM)
+	serverCert, err := x509.ParseCertificate(serverBlock.Bytes)
+	require.NoError(err)
+	peer, err = PeerIdentity(tls.ConnectionState{PeerCertificates: []*x509.Certificate{serverCert}})
+	require.NoError(err)
+	assert.Equal("server", peer.Type)
+
+	// the renewed credentials are stored in the environment
+	assert.Equal(newCA.pem()+oldCA.pem(), os.Getenv(marble.MarbleEnvironmentIntermediateCA))
+	newClientConfig, err := GetClientConfig("server")
+	require.NoError(err)
+
+	// after the overlap period, only the new intermediate CA is trusted, which both client configurations use now
+	server.setEnv(require, newCA)
+	serverConfig, err = GetServerConfig("client")
+	require.NoError(err)
+	_, err = handshake(serverConfig, newClientConfig)
+	assert.NoError(err)
+	_, err = handshake(serverConfig, clientConfig)
+	assert.NoError(err)
+}
+
+// handshake connects a client to a server and returns the server's connection state
+func handshake(serverConfig, clientConfig *tls.Config) (tls.ConnectionState, error) {
+	serverConn, clientConn := net.Pipe()
+	return handshakeOver(serverConn, clientConn, serverConfig, clientConfig)
+}
+
+// handshakeOver performs a handshake over the given connections and returns the server's connection state
+func handshakeOver(serverConn, clientConn net.Conn, serverConfig, clientConfig *tls.Config) (tls.ConnectionState, error) {
+	clientConfig = clientConfig.Clone()
+	clientConfig.ServerName = "localhost"
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	clientErr := make(chan error, 1)
+	go func() {
+		conn := tls.Client(clientConn, clientConfig)
+		err := conn.Handshake()
+		if err == nil {
+			// wait for the server to finish verifying the client
+			_, err = conn.Read(make([]byte, 1))
+		}
+		clientConn.Close()
+		clientErr <- err
+	}()
+
+	conn := tls.Server(serverConn, serverConfig)
+	err := conn.Handshake()
+	if err == nil {
+		_, err = conn.Write([]byte{0})
+	}
+	serverConn.Close()
+	if cerr := <-clientErr; err == nil {
+		err = cerr
+	}
+	return conn.ConnectionState(), err
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(require *require.Assertions) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(err)
+	cert, err := x509.ParseCertificate(raw)
+	require.NoError(err)
+	return testCA{cert: cert, key: key}
+}
+
+type testMarble struct {
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func (ca testCA) issue(require *require.Assertions, uris ...*url.URL) testMarble {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	raw := ca.certify(require, &key.PublicKey, uris...)
+	rawKey, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(err)
+	return testMarble{
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: raw}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rawKey}),
+	}
+}
+
+// certify issues a Marble certificate for the public key
+func (ca testCA) certify(require *require.Assertions, pub interface{}, uris ...*url.URL) []byte {
+	serialNumber, err := util.GenerateCertificateSerialNumber()
+	require.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		URIs:         uris,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, ca.cert, pub, ca.key)
+	require.NoError(err)
+	return raw
+}
+
+func (ca testCA) pem() string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+}
+
+// setEnv sets the environment of a Marble whose certificate was issued by ca. The Marble trusts ca and the additionally trusted CAs.
+func (m testMarble) setEnv(require *require.Assertions, ca testCA, additionallyTrusted ...testCA) {
+	trustedPEM := ca.pem()
+	for _, trusted := range additionallyTrusted {
+		trustedPEM += trusted.pem()
+	}
+	require.NoError(os.Setenv(marble.MarbleEnvironmentCertificateChain, string(m.certPEM)+ca.pem()))
+	require.NoError(os.Setenv(marble.MarbleEnvironmentIntermediateCA, trustedPEM))
+	require.NoError(os.Setenv(marble.MarbleEnvironmentPrivateKey, string(m.keyPEM)))
+}
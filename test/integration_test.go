@@ -58,9 +58,9 @@ func TestMain(m *testing.M) {
 	}
 
 	if *simulationMode {
-		simFlag = makeEnv("OE_SIMULATION", "1")
+		simFlag = makeEnv(config.SimulationMode, "1")
 	} else {
-		simFlag = makeEnv("OE_SIMULATION", "0")
+		simFlag = makeEnv(config.SimulationMode, "0")
 	}
 
 	if err := json.Unmarshal([]byte(IntegrationManifestJSON), &testManifest); err != nil {
@@ -248,7 +248,7 @@ func TestClientAPI(t *testing.T) {
 	manifest, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 	require.NoError(err)
-	assert.JSONEq(`{"ManifestSignature":""}`, string(manifest))
+	assert.JSONEq(`{"ManifestSignature":"","RawManifestSignature":""}`, string(manifest))
 }
 
 func TestRecoveryRestoreKey(t *testing.T) {
@@ -615,9 +615,42 @@ func getMarbleCmd(cfg marbleConfig) *exec.Cmd {
 		makeEnv("EDG_TEST_ADDR", marbleTestAddr),
 		simFlag,
 	}
+	if !*simulationMode {
+		// Marbles must verify the Coordinator outside simulation mode
+		cmd.Env = append(cmd.Env, makeEnv(mconfig.CoordinatorRootCA, getCoordinatorRoot()))
+	}
 	return cmd
 }
 
+// getCoordinatorRoot returns the PEM-encoded root certificate of the running Coordinator, which is the last certificate of the chain returned with its quote
+func getCoordinatorRoot() string {
+	client := http.Client{Transport: transportSkipVerify}
+	clientAPIURL := url.URL{Scheme: "https", Host: clientServerAddr, Path: "quote"}
+	resp, err := client.Get(clientAPIURL.String())
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		panic(err)
+	}
+
+	var root *pem.Block
+	rest := []byte(gjson.GetBytes(body, "Cert").String())
+	for {
+		block, remaining := pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		root, rest = block, remaining
+	}
+	if root == nil {
+		panic("the Coordinator did not send a certificate")
+	}
+	return string(pem.EncodeToMemory(root))
+}
+
 func startMarbleServer(cfg marbleConfig) *os.Process {
 	cmd := getMarbleCmd(cfg)
 	output := startCommand(cmd)
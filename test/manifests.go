@@ -58,6 +58,16 @@ const ManifestJSON string = `{
 					"/tmp/defg.txt": "foo",
 					"/tmp/jkl.mno": "bar"
 				},
+				"StructuredFiles": {
+					"/tmp/marblerun-test": {
+						"Directory": true
+					},
+					"/tmp/marblerun-test/cert.der": {
+						"Content": "{{ base64 (der .Secrets.cert_shared.Cert) }}",
+						"Encoding": "base64",
+						"Mode": "0644"
+					}
+				},
 				"Env": {
 					"IS_FIRST": "true",
 					"SEAL_KEY": "{{ hex .Marblerun.SealKey }}",
@@ -187,12 +197,7 @@ var IntegrationManifestJSON string = `{
 		}
 	},
 	"Infrastructures": {
-		"Azure": {
-			"QESVN": 2,
-			"PCESVN": 3,
-			"CPUSVN": [0,1,2,3,4,5,6,7,8,9,10,11,12,13,14,15],
-			"RootCA": [3,3,3]
-		}
+		"localhost": {}
 	},
 	"Marbles": {
 		"test_marble_server": {
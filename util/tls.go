@@ -13,9 +13,12 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"math"
 	"math/big"
 	"net"
+	"net/url"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc/credentials"
@@ -23,6 +26,12 @@ import (
 
 const marbleName string = "Marblerun Marble"
 
+// MarbleURIScheme is the scheme of the URI SAN that identifies a Marble in the certificate issued by the Coordinator
+const MarbleURIScheme = "marblerun"
+
+// marbleURIHost is the host of the URI SAN that identifies a Marble
+const marbleURIHost = "marble"
+
 // MustGenerateTestMarbleCredentials returns dummy Marble TLS credentials for testing
 func MustGenerateTestMarbleCredentials() (cert *x509.Certificate, csrRaw []byte, privk *ecdsa.PrivateKey) {
 	dnsNames := []string{"localhost", "*.foobar.net", "*.example.org"}
@@ -118,7 +127,177 @@ func LoadGRPCTLSCredentials(cert *x509.Certificate, privk *ecdsa.PrivateKey, ins
 	return credentials.NewTLS(tlsConfig), nil
 }
 
+// LoadGRPCTLSCredentialsWithRoot returns a TLS configuration based on cert and privk that only accepts servers whose certificate chains up to root.
+// The server's hostname is not verified, because the server is identified by the root certificate.
+func LoadGRPCTLSCredentialsWithRoot(cert *x509.Certificate, privk *ecdsa.PrivateKey, root *x509.Certificate) (credentials.TransportCredentials, error) {
+	return GRPCTLSCredentialsWithRoot(*TLSCertFromDER(cert.Raw, privk), root), nil
+}
+
+// GRPCTLSCredentialsWithRoot returns a TLS configuration that presents cert and only accepts servers whose certificate chains up to root.
+// The server's hostname is not verified, because the server is identified by the root certificate.
+func GRPCTLSCredentialsWithRoot(cert tls.Certificate, root *x509.Certificate) credentials.TransportCredentials {
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// The default verification requires a hostname, so the chain is verified in VerifyPeerCertificate instead
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyPeerCertificateWithRoot(root),
+	}
+	return credentials.NewTLS(tlsConfig)
+}
+
+// verifyPeerCertificateWithRoot returns a tls.Config.VerifyPeerCertificate function that verifies the peer's chain against root
+func verifyPeerCertificateWithRoot(root *x509.Certificate) func([][]byte, [][]*x509.Certificate) error {
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("peer did not present a certificate")
+		}
+		certs := make([]*x509.Certificate, 0, len(rawCerts))
+		for _, rawCert := range rawCerts {
+			cert, err := x509.ParseCertificate(rawCert)
+			if err != nil {
+				return err
+			}
+			certs = append(certs, cert)
+		}
+		intermediates := x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
+
+// MarbleIdentity identifies a Marble. The Coordinator embeds it as URI SAN into the certificates it issues to Marbles, e.g.,
+// marblerun://marble/backend/<uuid>?package=backend&manifest=<hex-encoded SHA-256 hash>
+type MarbleIdentity struct {
+	// Type is the Marble type as defined in the manifest
+	Type string
+	// UUID is the Marble's UUID
+	UUID string
+	// Package is the name of the manifest package the Marble's quote matched on activation
+	Package string
+	// ManifestHash is the hex-encoded SHA-256 hash of the manifest the Marble was activated with
+	ManifestHash string
+}
+
+// URI returns the URI SAN representation of the identity
+func (m MarbleIdentity) URI() *url.URL {
+	query := url.Values{}
+	if m.Package != "" {
+		query.Set("package", m.Package)
+	}
+	if m.ManifestHash != "" {
+		query.Set("manifest", m.ManifestHash)
+	}
+	return &url.URL{
+		Scheme:   MarbleURIScheme,
+		Host:     marbleURIHost,
+		Path:     "/" + m.Type + "/" + m.UUID,
+		RawPath:  "/" + url.PathEscape(m.Type) + "/" + url.PathEscape(m.UUID),
+		RawQuery: query.Encode(),
+	}
+}
+
+// ParseMarbleURI parses the URI SAN representation of a MarbleIdentity
+func ParseMarbleURI(uri *url.URL) (MarbleIdentity, error) {
+	if uri.Scheme != MarbleURIScheme || uri.Host != marbleURIHost {
+		return MarbleIdentity{}, errors.New("not a Marble URI")
+	}
+	parts := strings.Split(strings.TrimPrefix(uri.EscapedPath(), "/"), "/")
+	if len(parts) != 2 {
+		return MarbleIdentity{}, errors.New("malformed Marble URI")
+	}
+	marbleType, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return MarbleIdentity{}, err
+	}
+	marbleUUID, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return MarbleIdentity{}, err
+	}
+	query, err := url.ParseQuery(uri.RawQuery)
+	if err != nil {
+		return MarbleIdentity{}, err
+	}
+	return MarbleIdentity{
+		Type:         marbleType,
+		UUID:         marbleUUID,
+		Package:      query.Get("package"),
+		ManifestHash: query.Get("manifest"),
+	}, nil
+}
+
+// MarbleIdentityFromCert returns the identity of the Marble a certificate was issued to by the Coordinator
+func MarbleIdentityFromCert(cert *x509.Certificate) (MarbleIdentity, error) {
+	for _, uri := range cert.URIs {
+		if identity, err := ParseMarbleURI(uri); err == nil {
+			return identity, nil
+		}
+	}
+	return MarbleIdentity{}, errors.New("certificate does not identify a Marble")
+}
+
 // TLSCertFromDER converts a DER certificate to a TLS certificate.
 func TLSCertFromDER(certDER []byte, privk interface{}) *tls.Certificate {
 	return &tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: privk}
 }
+
+// MarbleEnvironmentCoordinatorRootCA contains the name of the environment variable holding the PEM-encoded root certificate of the Coordinator.
+// Marbles use it to verify the Coordinator when they renew their certificate.
+const MarbleEnvironmentCoordinatorRootCA = "MARBLE_PREDEFINED_COORDINATOR_ROOT_CA"
+
+// MarbleEnvironmentPeers contains the name of the environment variable holding the JSON-encoded MarblePeers of a Marble
+const MarbleEnvironmentPeers = "MARBLE_PREDEFINED_PEERS"
+
+// MarblePeers lists the Marble types a Marble may communicate with, as declared by the Connections of the manifest.
+// The Marble types are mapped to the allowed ports of the server. An empty list allows any port.
+type MarblePeers struct {
+	// Clients contains the Marble types that may connect to the Marble
+	Clients map[string][]uint16
+	// Servers contains the Marble types the Marble may connect to
+	Servers map[string][]uint16
+}
+
+// AllowsClient returns true if a Marble of the given type may connect to the Marble on the given port.
+// A port of 0 means that the port is unknown. Then, only Marble types that may connect on any port are allowed.
+func (p MarblePeers) AllowsClient(marbleType string, port uint16) bool {
+	return allowsPeer(p.Clients, marbleType, port)
+}
+
+// AllowsServer returns true if the Marble may connect to a Marble of the given type on the given port.
+// A port of 0 is not checked, as clients may not know the port of the server. The server enforces the ports instead.
+func (p MarblePeers) AllowsServer(marbleType string, port uint16) bool {
+	if port == 0 {
+		_, ok := p.Servers[marbleType]
+		return ok
+	}
+	return allowsPeer(p.Servers, marbleType, port)
+}
+
+// allowsPeer fails closed: if the port is unknown (0), it is only allowed if the peer may use any port.
+func allowsPeer(peers map[string][]uint16, marbleType string, port uint16) bool {
+	ports, ok := peers[marbleType]
+	if !ok {
+		return false
+	}
+	if len(ports) == 0 {
+		return true
+	}
+	if port == 0 {
+		return false
+	}
+	for _, allowed := range ports {
+		if allowed == port {
+			return true
+		}
+	}
+	return false
+}
@@ -7,8 +7,15 @@
 package util
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"net/url"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -44,3 +51,83 @@ func TestXORBytes(t *testing.T) {
 	require.NoError(err)
 	assert.Equal(expectedResult, result)
 }
+
+func TestVerifyPeerCertificateWithRoot(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	rawRoot, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	require.NoError(err)
+	root, err := x509.ParseCertificate(rawRoot)
+	require.NoError(err)
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		// the hostname is not verified
+		DNSNames: []string{"other.example.org"},
+	}
+	rawLeaf, err := x509.CreateCertificate(rand.Reader, template, root, &leafKey.PublicKey, rootKey)
+	require.NoError(err)
+	other, _, err := GenerateCert([]string{"localhost"}, nil, true)
+	require.NoError(err)
+
+	verify := verifyPeerCertificateWithRoot(root)
+	assert.NoError(verify([][]byte{rawLeaf}, nil))
+	assert.NoError(verify([][]byte{root.Raw}, nil))
+	assert.Error(verify([][]byte{other.Raw}, nil))
+	assert.Error(verify(nil, nil))
+}
+
+func TestMarbleURI(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	identity := MarbleIdentity{Type: "back end/first", UUID: "uuid", Package: "backend", ManifestHash: "abcd"}
+	parsed, err := url.Parse(identity.URI().String())
+	require.NoError(err)
+	parsedIdentity, err := ParseMarbleURI(parsed)
+	require.NoError(err)
+	assert.Equal(identity, parsedIdentity)
+
+	_, err = ParseMarbleURI(&url.URL{Scheme: "https", Host: "marble", Path: "/type/uuid"})
+	assert.Error(err)
+	_, err = ParseMarbleURI(&url.URL{Scheme: MarbleURIScheme, Host: "marble", Path: "/type"})
+	assert.Error(err)
+}
+
+func TestMarblePeers(t *testing.T) {
+	assert := assert.New(t)
+
+	peers := MarblePeers{
+		Clients: map[string][]uint16{"frontend": {8080}, "admin": {}},
+		Servers: map[string][]uint16{"backend": {9000}},
+	}
+
+	assert.True(peers.AllowsClient("frontend", 8080))
+	assert.False(peers.AllowsClient("frontend", 8081))
+	assert.True(peers.AllowsClient("admin", 8081))
+	assert.False(peers.AllowsClient("other", 8080))
+	// an unknown port only allows clients that may connect on any port
+	assert.False(peers.AllowsClient("frontend", 0))
+	assert.True(peers.AllowsClient("admin", 0))
+
+	assert.True(peers.AllowsServer("backend", 9000))
+	assert.False(peers.AllowsServer("backend", 9001))
+	assert.False(peers.AllowsServer("frontend", 8080))
+	// clients may not know the port of the server, which enforces it instead
+	assert.True(peers.AllowsServer("backend", 0))
+	assert.False(peers.AllowsServer("frontend", 0))
+}